@@ -16,6 +16,7 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/honeydipper/honeydipper/v3/internal/api"
 	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 	"github.com/logrusorgru/aurora/v3"
 	"github.com/mitchellh/mapstructure"
@@ -33,6 +34,11 @@ var (
 	ErrorNotAllowed = fmt.Errorf("not allowed without pairing field")
 	// ErrorNotAList is the message when a field is supposed to be a list.
 	ErrorNotAList = fmt.Errorf("must be a list or something interpolated into a list")
+	// ErrorNotAdvertised is the message when a driver does not advertise the command being called.
+	ErrorNotAdvertised = fmt.Errorf("not advertised by driver")
+
+	// driverCapabilities caches the capabilities described by driver executables, nil if not available.
+	driverCapabilities = map[string]*dipper.Capabilities{}
 )
 
 type dipperCLError struct {
@@ -123,6 +129,22 @@ func runConfigCheck(cfg *config.Config) int {
 		}
 	}
 
+	functionErrors := false
+	for systemName, system := range cfg.Staged.Systems {
+		for functionName, function := range system.Functions {
+			errMsg := checkFunctionDriver(function, cfg)
+			if len(errMsg) > 0 {
+				if !functionErrors {
+					fmt.Printf("\nFound errors in functions:\n")
+					fmt.Println("─────────────────────────────────────────────────────────────")
+					functionErrors = true
+				}
+				fmt.Printf("function(%s.%s): %s\n", systemName, functionName, aurora.Red(errMsg))
+				ret = 1
+			}
+		}
+	}
+
 	if checkAuthRules(cfg) > 0 {
 		ret = 1
 	}
@@ -339,8 +361,48 @@ func checkWorkflowDriver(w config.Workflow, cfg *config.Config) {
 	if w.CallDriver != "" && !hasInterpolation(w.CallDriver) {
 		parts := strings.Split(w.CallDriver, ".")
 		checkObjectExists("driver", parts[0], cfg.Staged.Drivers)
+		if len(parts) > 1 {
+			checkDriverCommand(parts[0], parts[1], cfg)
+		}
 	} else if w.Function.Driver != "" && !hasInterpolation(w.Function.Driver) {
 		checkObjectExists("driver", w.Function.Driver, cfg.Staged.Drivers)
+		checkDriverCommand(w.Function.Driver, w.Function.RawAction, cfg)
+	}
+}
+
+func checkFunctionDriver(f config.Function, cfg *config.Config) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = r.(error).Error()
+		}
+	}()
+
+	if f.Driver != "" && !hasInterpolation(f.Driver) {
+		checkDriverCommand(f.Driver, f.RawAction, cfg)
+	}
+
+	return msg
+}
+
+// checkDriverCommand makes sure the driver advertises the command, skipped if the
+// driver executable is not available to describe itself.
+func checkDriverCommand(driverName, command string, cfg *config.Config) {
+	if command == "" || hasInterpolation(command) || hasInterpolation(driverName) {
+		return
+	}
+
+	caps, ok := driverCapabilities[driverName]
+	if !ok {
+		if meta, found := cfg.GetStagedDriverData("daemon.drivers." + driverName); found {
+			if metaData, isMap := meta.(map[string]interface{}); isMap {
+				caps, _ = driver.QueryCapabilities(metaData, "operator")
+			}
+		}
+		driverCapabilities[driverName] = caps
+	}
+
+	if caps != nil && !caps.HasCommand(command) {
+		panic(fmt.Errorf("command \"%s.%s\" %w", driverName, command, ErrorNotAdvertised))
 	}
 }
 
@@ -23,6 +23,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
 
@@ -190,6 +191,28 @@ func readFile(root string, file string) string {
 	return strings.Join(sections, "")
 }
 
+// describeDrivers collects the capabilities of the drivers defined in the config, drivers
+// not available to describe themselves are left out.
+func describeDrivers(cfg *config.Config) map[string]*dipper.Capabilities {
+	ret := map[string]*dipper.Capabilities{}
+	drivers, ok := cfg.GetStagedDriverData("daemon.drivers")
+	if !ok {
+		return ret
+	}
+
+	for name, meta := range drivers.(map[string]interface{}) {
+		if metaData, ok := meta.(map[string]interface{}); ok {
+			if caps, err := driver.QueryCapabilities(metaData, DocGenService); err == nil && caps != nil {
+				ret[name] = caps
+			} else {
+				dipper.Logger.Debugf("Skipping capabilities of driver %s: %v", name, err)
+			}
+		}
+	}
+
+	return ret
+}
+
 func createItem(item DocItem, envData map[string]interface{}, cfg *config.Config) {
 	name := dipper.InterpolateStr(item.Name, envData)
 	dipper.Logger.Infof("Generating file %s from template %s", name, item.Template)
@@ -210,6 +233,7 @@ func createItem(item DocItem, envData map[string]interface{}, cfg *config.Config
 			currentRepo.AdvanceStage(DocGenService, config.StageBooting)
 			currentRepo.AdvanceStage(DocGenService, config.StageDiscovering)
 			envData["current_repo"] = currentRepo.Staged
+			envData["current_capabilities"] = describeDrivers(currentRepo)
 		}
 	}
 
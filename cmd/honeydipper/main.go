@@ -17,6 +17,7 @@ import (
 
 	"github.com/honeydipper/honeydipper/v3/internal/config"
 	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
 	"github.com/honeydipper/honeydipper/v3/internal/service"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
@@ -174,6 +175,10 @@ func getLogger() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == driver.SandboxCommand {
+		driver.RunSandbox(os.Args[2:])
+	}
+
 	initEnv()
 	switch {
 	case cfg.IsConfigCheck:
@@ -31,7 +31,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "auth-gcp-iap")
+	driver = dipper.NewDriver(os.Args[1], "auth-gcp-iap", dipper.DriverWithServices("receiver", "api"))
 	driver.RPCHandlers["auth_web_request"] = authWebRequest
 	driver.Reload = func(*dipper.Message) {}
 	driver.Run()
@@ -49,7 +49,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "auth-simple")
+	driver = dipper.NewDriver(os.Args[1], "auth-simple", dipper.DriverWithServices("receiver", "api"))
 	driver.RPCHandlers["auth_web_request"] = authWebRequest
 	driver.Reload = func(*dipper.Message) {}
 	driver.Run()
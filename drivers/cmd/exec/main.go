@@ -0,0 +1,250 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+// Package exec enables Honeydipper to run local commands and scripts as functions.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+const (
+	// DefaultTimeout is the default time limit for running a command.
+	DefaultTimeout = 60 * time.Second
+	// DefaultMaxOutput is the default maximum number of bytes captured from stdout or stderr.
+	DefaultMaxOutput = 1 << 20
+)
+
+var (
+	// ErrMissingCommand means the command to run is not specified.
+	ErrMissingCommand = errors.New("missing command")
+	// ErrNotAllowed means the executable is not in the allowed list.
+	ErrNotAllowed = errors.New("executable not allowed")
+	// ErrTimeout means the command did not finish in time.
+	ErrTimeout = errors.New("command timed out")
+	// ErrEnvNotAllowed means the environment variable can not be set through the parameters.
+	ErrEnvNotAllowed = errors.New("environment variable not allowed")
+	// ErrCancelled means the command is killed as the workflow is cancelled.
+	ErrCancelled = errors.New("cancelled")
+)
+
+func initFlags() {
+	flag.Usage = func() {
+		fmt.Printf("%s [ -h ] <service name>\n", os.Args[0])
+		fmt.Printf("    This driver supports operator service\n")
+		fmt.Printf("  This program provides honeydipper with capability of running local commands and scripts\n")
+	}
+}
+
+var driver *dipper.Driver
+
+func main() {
+	initFlags()
+	flag.Parse()
+	driver = dipper.NewDriver(os.Args[1], "exec")
+	driver.Commands["run"] = run
+	driver.Reload = func(*dipper.Message) {}
+	driver.Run()
+}
+
+// cappedBuffer keeps up to max bytes written to it, and discards the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// isAllowed checks the executable against the allowed list in the driver data, which holds the
+// paths or the glob patterns of the executables.
+func isAllowed(executable string) bool {
+	allowed, _ := driver.GetOption("data.allowed")
+	patterns, _ := allowed.([]interface{})
+	for _, p := range patterns {
+		if pattern, ok := p.(string); ok {
+			if matched, _ := filepath.Match(pattern, executable); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolve finds the absolute path of the executable, relative paths are resolved from the working
+// directory and bare names are looked up in the PATH.
+func resolve(command string, workdir string) (string, error) {
+	switch {
+	case filepath.IsAbs(command):
+		return filepath.Clean(command), nil
+	case strings.ContainsRune(command, os.PathSeparator):
+		return filepath.Abs(filepath.Join(workdir, command))
+	default:
+		return exec.LookPath(command)
+	}
+}
+
+// isEnvAllowed checks if the variable can be set through the parameters, against the names or the
+// glob patterns in the envAllowed list in the driver data.  The variables of the dynamic linker, i.e.
+// LD_*, are never allowed, as they can load arbitrary code into the allowed executables.
+func isEnvAllowed(name string) bool {
+	if strings.HasPrefix(name, "LD_") {
+		return false
+	}
+	allowed, _ := driver.GetOption("data.envAllowed")
+	patterns, _ := allowed.([]interface{})
+	for _, p := range patterns {
+		if pattern, ok := p.(string); ok {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// environ builds the environment of the command, the PATH of the driver is passed by default, and
+// the variables from the driver data and the parameters are added in that order.  Only the variables
+// allowed in the driver data can be set through the parameters.
+func environ(params interface{}) []string {
+	env := map[string]string{"PATH": os.Getenv("PATH")}
+	if defaults, ok := driver.GetOption("data.env"); ok {
+		for k, v := range defaults.(map[string]interface{}) {
+			env[k] = fmt.Sprint(v)
+		}
+	}
+	if overrides, ok := dipper.GetMapData(params, "env"); ok {
+		for k, v := range overrides.(map[string]interface{}) {
+			if !isEnvAllowed(k) {
+				panic(fmt.Errorf("%w: %s", ErrEnvNotAllowed, k))
+			}
+			env[k] = fmt.Sprint(v)
+		}
+	}
+
+	ret := make([]string, 0, len(env))
+	for k, v := range env {
+		ret = append(ret, k+"="+v)
+	}
+
+	return ret
+}
+
+func run(msg *dipper.Message) {
+	msg = dipper.DeserializePayload(msg)
+	params := msg.Payload
+
+	command, ok := dipper.GetMapDataStr(params, "command")
+	if !ok || command == "" {
+		panic(ErrMissingCommand)
+	}
+
+	workdir, ok := dipper.GetMapDataStr(params, "workdir")
+	if !ok {
+		workdir, _ = driver.GetOptionStr("data.workdir")
+	}
+	executable := dipper.Must(resolve(command, workdir)).(string)
+	if !isAllowed(executable) {
+		panic(fmt.Errorf("%w: %s", ErrNotAllowed, executable))
+	}
+
+	args := []string{}
+	if list, ok := dipper.GetMapData(params, "args"); ok {
+		for _, arg := range list.([]interface{}) {
+			args = append(args, fmt.Sprint(arg))
+		}
+	}
+
+	timeout := DefaultTimeout
+	if t, ok := driver.GetOptionStr("data.timeout"); ok {
+		timeout = dipper.Must(time.ParseDuration(t)).(time.Duration)
+	}
+	if t, ok := dipper.GetMapDataStr(params, "timeout"); ok {
+		timeout = dipper.Must(time.ParseDuration(t)).(time.Duration)
+	}
+
+	maxOutput, ok := dipper.GetMapDataInt(driver.Options, "data.maxOutput")
+	if !ok {
+		maxOutput = DefaultMaxOutput
+	}
+
+	env := environ(params)
+
+	// the command is killed when the workflow is cancelled
+	ctx, cancel := context.WithTimeout(msg.Context(), timeout)
+	defer cancel()
+
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.Dir = workdir
+	cmd.Env = env
+	// do not wait forever for the children holding the output after the command is killed
+	cmd.WaitDelay = time.Second
+	if stdin, ok := dipper.GetMapDataStr(params, "stdin"); ok {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	stdout := &cappedBuffer{max: maxOutput}
+	stderr := &cappedBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	// the timeout of the command is enforced here instead of the default timeout of the commands
+	msg.Reply <- dipper.Message{Labels: map[string]string{"no-timeout": "true"}}
+	err := cmd.Run()
+	exitCode := cmd.ProcessState.ExitCode()
+	payload := map[string]interface{}{
+		"stdout":    stdout.String(),
+		"stderr":    stderr.String(),
+		"exitCode":  exitCode,
+		"truncated": stdout.truncated || stderr.truncated,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		msg.Reply <- dipper.Message{
+			Labels:  map[string]string{"status": dipper.ERROR, "reason": fmt.Sprintf("%v after %s", ErrTimeout, timeout)},
+			Payload: payload,
+		}
+	case errors.Is(ctx.Err(), context.Canceled):
+		msg.Reply <- dipper.Message{
+			Labels:  map[string]string{"status": dipper.ERROR, "reason": ErrCancelled.Error()},
+			Payload: payload,
+		}
+	case errors.As(err, &exitErr):
+		msg.Reply <- dipper.Message{
+			Labels:  map[string]string{"status": dipper.FAILURE, "reason": fmt.Sprintf("exit code %d", exitCode)},
+			Payload: payload,
+		}
+	case err != nil:
+		panic(err)
+	default:
+		msg.Reply <- dipper.Message{Payload: payload}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	if dipper.Logger == nil {
+		logFile, err := os.Create("test.log")
+		if err != nil {
+			panic(err)
+		}
+		defer logFile.Close()
+		dipper.Logger = dipper.GetLogger("test", "INFO", logFile, logFile)
+	}
+	driver = &dipper.Driver{
+		Service: "test",
+		Options: map[string]interface{}{
+			"data": map[string]interface{}{
+				"allowed":    []interface{}{"/bin/*", "/usr/bin/*"},
+				"env":        map[string]interface{}{"GREETING": "hello", "NAME": "driver"},
+				"envAllowed": []interface{}{"NAME", "LD_*"},
+			},
+		},
+	}
+	m.Run()
+}
+
+func runCommand(payload map[string]interface{}) dipper.Message {
+	msg := &dipper.Message{
+		Payload: payload,
+		Reply:   make(chan dipper.Message, 2),
+	}
+	run(msg)
+
+	reply := <-msg.Reply
+	if _, ok := reply.Labels["no-timeout"]; ok {
+		reply = <-msg.Reply
+	}
+
+	return reply
+}
+
+func TestRun(t *testing.T) {
+	ret := runCommand(map[string]interface{}{
+		"command": "sh",
+		"args":    []interface{}{"-c", `echo "$GREETING $NAME $1"; cat; echo oops >&2`, "sh", "world"},
+		"env":     map[string]interface{}{"NAME": "test"},
+		"stdin":   "input",
+		"workdir": "/",
+	})
+
+	assert.Empty(t, ret.Labels, "should succeed")
+	assert.Equal(t, "hello test world\ninput", dipper.MustGetMapDataStr(ret.Payload, "stdout"), "should capture stdout with the env and args")
+	assert.Equal(t, "oops\n", dipper.MustGetMapDataStr(ret.Payload, "stderr"), "should capture stderr")
+	assert.Equal(t, 0, dipper.MustGetMapDataInt(ret.Payload, "exitCode"))
+}
+
+func TestRunFailure(t *testing.T) {
+	ret := runCommand(map[string]interface{}{
+		"command": "/bin/sh",
+		"args":    []interface{}{"-c", "pwd; exit 3"},
+		"workdir": "/tmp",
+	})
+
+	assert.Equal(t, dipper.FAILURE, ret.Labels["status"], "should fail with non-zero exit code")
+	assert.Equal(t, 3, dipper.MustGetMapDataInt(ret.Payload, "exitCode"))
+	assert.Equal(t, "/tmp\n", dipper.MustGetMapDataStr(ret.Payload, "stdout"), "should run in the workdir")
+}
+
+func TestRunTimeout(t *testing.T) {
+	ret := runCommand(map[string]interface{}{
+		"command": "sleep",
+		"args":    []interface{}{"10"},
+		"timeout": "100ms",
+	})
+
+	assert.Equal(t, dipper.ERROR, ret.Labels["status"], "should error on timeout")
+	assert.Contains(t, ret.Labels["reason"], ErrTimeout.Error())
+}
+
+func TestRunCancelled(t *testing.T) {
+	returns := make(chan *dipper.Message, 1)
+	p := &dipper.CommandProvider{}
+	p.Init("eventbus", "return", &dipper.NullReceiver{SendMessageFunc: func(m *dipper.Message) {
+		returns <- m
+	}})
+	p.Commands["execute"] = run
+	go p.Router(&dipper.Message{
+		Labels:  map[string]string{"method": "execute", "sessionID": "test"},
+		Payload: map[string]interface{}{"command": "sleep", "args": []interface{}{"10"}},
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	p.Cancel(&dipper.Message{Labels: map[string]string{"sessionID": "test"}})
+
+	select {
+	case ret := <-returns:
+		assert.Equal(t, dipper.ERROR, ret.Labels["status"], "should error when cancelled")
+		assert.Equal(t, ErrCancelled.Error(), ret.Labels["reason"])
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "should kill the command when cancelled")
+	}
+}
+
+func TestRunEnvNotAllowed(t *testing.T) {
+	for _, name := range []string{"GREETING", "LD_PRELOAD", "LD_LIBRARY_PATH"} {
+		assert.PanicsWithError(t, ErrEnvNotAllowed.Error()+": "+name, func() {
+			runCommand(map[string]interface{}{
+				"command": "true",
+				"env":     map[string]interface{}{name: "x"},
+			})
+		}, "should reject %s", name)
+	}
+}
+
+func TestRunNotAllowed(t *testing.T) {
+	assert.PanicsWithError(t, ErrMissingCommand.Error(), func() { runCommand(map[string]interface{}{}) }, "should require command")
+	assert.Panics(t, func() {
+		runCommand(map[string]interface{}{"command": "./script.sh", "workdir": "/tmp"})
+	}, "should reject executables not in the allowed list")
+	assert.Panics(t, func() {
+		runCommand(map[string]interface{}{"command": "/bin/../tmp/script.sh"})
+	}, "should reject paths escaping the allowed directory")
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 5}
+	n, err := b.Write([]byte("hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, 11, n, "should discard the extra bytes silently")
+	assert.Equal(t, "hello", b.String())
+	assert.True(t, b.truncated)
+}
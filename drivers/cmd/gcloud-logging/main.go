@@ -34,7 +34,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "google-logging")
+	driver = dipper.NewDriver(os.Args[1], "google-logging", dipper.DriverWithServices("operator"))
 	if driver.Service == "operator" {
 		driver.Reload = func(*dipper.Message) {}
 		driver.Commands["log"] = sendLog
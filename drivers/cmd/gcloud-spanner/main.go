@@ -60,7 +60,7 @@ func main() {
 
 	lro = &sync.Map{}
 
-	driver = dipper.NewDriver(os.Args[1], "gcloud-spanner")
+	driver = dipper.NewDriver(os.Args[1], "gcloud-spanner", dipper.DriverWithServices("operator"))
 	driver.Commands["backup"] = backup
 	driver.Commands["waitForBackup"] = waitForBackup
 	driver.Run()
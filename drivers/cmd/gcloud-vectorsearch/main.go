@@ -30,7 +30,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "gcloud-vectorsearch")
+	driver = dipper.NewDriver(os.Args[1], "gcloud-vectorsearch", dipper.DriverWithServices("operator"))
 	driver.RPCHandlers["query"] = query
 	driver.Start = func(_ *dipper.Message) {
 		redisOptions = redisclient.GetRedisOpts(driver)
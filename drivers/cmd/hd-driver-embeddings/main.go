@@ -43,7 +43,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "embeddings")
+	driver = dipper.NewDriver(os.Args[1], "embeddings", dipper.DriverWithServices("operator"))
 	driver.RPCHandlers["vertex-ai"] = vertexAI
 	driver.RPCHandlers["ollama"] = ollama
 	driver.Run()
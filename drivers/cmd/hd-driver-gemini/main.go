@@ -36,7 +36,7 @@ type ToolSpec struct {
 // newGemini creates and initializes a new Gemini driver instance.
 func newGemini() *gemini {
 	this := &gemini{}
-	this.driver = dipper.NewDriver(os.Args[1], "gemini")
+	this.driver = dipper.NewDriver(os.Args[1], "gemini", dipper.DriverWithServices("operator"))
 
 	return this
 }
@@ -49,7 +49,7 @@ func main() {
 
 // initDriver sets up the driver with required commands and handlers.
 func initDriver() {
-	driver = dipper.NewDriver(os.Args[1], "ollama")
+	driver = dipper.NewDriver(os.Args[1], "ollama", dipper.DriverWithServices("operator"))
 	driver.Commands["chat"] = chat
 	driver.Commands["chatContinue"] = func(m *dipper.Message) { ai.ChatContinue(driver, m) }
 	driver.Commands["chatStop"] = func(m *dipper.Message) { ai.ChatStop(driver, m) }
@@ -36,7 +36,7 @@ type ToolSpec struct {
 // newOpenAI creates and initializes a new OpenAI driver instance.
 func newOpenAI() *openAIDriver {
 	this := &openAIDriver{}
-	this.driver = dipper.NewDriver(os.Args[1], "openai")
+	this.driver = dipper.NewDriver(os.Args[1], "openai", dipper.DriverWithServices("operator"))
 
 	return this
 }
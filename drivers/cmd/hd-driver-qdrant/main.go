@@ -28,7 +28,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "qdrant")
+	driver = dipper.NewDriver(os.Args[1], "qdrant", dipper.DriverWithServices("operator"))
 	driver.RPCHandlers["query"] = query
 	driver.Run()
 }
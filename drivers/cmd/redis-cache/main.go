@@ -52,6 +52,9 @@ func main() {
 	driver.RPCHandlers["rpush"] = rpush
 	driver.RPCHandlers["del"] = del
 	driver.RPCHandlers["exists"] = exists
+	driver.RPCHandlers["hset"] = hset
+	driver.RPCHandlers["hdel"] = hdel
+	driver.RPCHandlers["hgetall"] = hgetall
 	driver.Run()
 }
 
@@ -276,3 +279,60 @@ func exists(msg *dipper.Message) {
 	}
 	msg.Reply <- dipper.Message{Payload: payload, IsRaw: true}
 }
+
+func hset(msg *dipper.Message) {
+	dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	field := dipper.MustGetMapDataStr(msg.Payload, "field")
+	val := dipper.MustGetMapData(msg.Payload, "value")
+
+	valStr, ok := val.(string)
+	if !ok {
+		valStr = string(dipper.Must(json.Marshal(val)).([]byte))
+	}
+
+	client := redisclient.NewClient(redisOptions)
+	defer client.Close()
+	ctx, cancel := driver.GetContext()
+	defer cancel()
+	if err := client.HSet(ctx, key, field, valStr).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Panicf("[%s] redis error: %v", driver.Service, err)
+	}
+	msg.Reply <- dipper.Message{}
+}
+
+func hdel(msg *dipper.Message) {
+	dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	field := dipper.MustGetMapDataStr(msg.Payload, "field")
+
+	client := redisclient.NewClient(redisOptions)
+	defer client.Close()
+	ctx, cancel := driver.GetContext()
+	defer cancel()
+	if err := client.HDel(ctx, key, field).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Panicf("[%s] redis error: %v", driver.Service, err)
+	}
+	msg.Reply <- dipper.Message{}
+}
+
+func hgetall(msg *dipper.Message) {
+	dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+
+	client := redisclient.NewClient(redisOptions)
+	defer client.Close()
+	ctx, cancel := driver.GetContext()
+	defer cancel()
+	val, err := client.HGetAll(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Panicf("[%s] redis error: %v", driver.Service, err)
+	}
+	if val == nil {
+		val = map[string]string{}
+	}
+	msg.Reply <- dipper.Message{
+		Payload: dipper.Must(json.Marshal(val)).([]byte),
+		IsRaw:   true,
+	}
+}
@@ -372,3 +372,53 @@ func TestExists(t *testing.T) {
 		assert.Fail(t, "exists should reply a dipper message")
 	}
 }
+
+func TestHash(t *testing.T) {
+	if driver == nil {
+		TestLoadOptions(t)
+	}
+
+	db, mock := redismock.NewClientMock()
+	redisOptions = &redisclient.Options{
+		Client: db,
+	}
+
+	assert.Panics(t, func() { hset(&dipper.Message{}) }, "hset should panic with empty data")
+
+	msg := &dipper.Message{
+		Payload: map[string]interface{}{
+			"key":   "foo",
+			"field": "1",
+			"value": map[string]interface{}{"a": "b"},
+		},
+		Reply: make(chan dipper.Message, 1),
+	}
+	mock.ExpectHSet("foo", "1", `{"a":"b"}`).SetVal(1)
+	assert.NotPanics(t, func() { hset(msg) }, "hset should not panic with good data")
+	assert.Len(t, msg.Reply, 1, "hset should reply a dipper message")
+
+	msg = &dipper.Message{
+		Payload: map[string]interface{}{"key": "foo"},
+		Reply:   make(chan dipper.Message, 1),
+	}
+	mock.ExpectHGetAll("foo").SetVal(map[string]string{"1": `{"a":"b"}`})
+	assert.NotPanics(t, func() { hgetall(msg) }, "hgetall should not panic with good data")
+	reply := <-msg.Reply
+	assert.JSONEq(t, `{"1":"{\"a\":\"b\"}"}`, string(reply.Payload.([]byte)), "hgetall should return the fields in json")
+
+	msg = &dipper.Message{
+		Payload: map[string]interface{}{"key": "foo", "field": "1"},
+		Reply:   make(chan dipper.Message, 1),
+	}
+	mock.ExpectHDel("foo", "1").SetVal(1)
+	assert.NotPanics(t, func() { hdel(msg) }, "hdel should not panic with good data")
+	assert.Len(t, msg.Reply, 1, "hdel should reply a dipper message")
+
+	msg = &dipper.Message{
+		Payload: map[string]interface{}{"key": "foo", "field": "1"},
+		Reply:   make(chan dipper.Message, 1),
+	}
+	mock.ExpectHDel("foo", "1").SetErr(errors.New("something is wrong"))
+	assert.Panics(t, func() { hdel(msg) }, "hdel should panic on redis error")
+	assert.Nil(t, mock.ExpectationsWereMet(), "all redis commands should be called")
+}
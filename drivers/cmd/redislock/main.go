@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/honeydipper/honeydipper/v3/drivers/pkg/redisclient"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
@@ -28,6 +29,15 @@ var (
 	ErrFailToLock = errors.New("fail to lock")
 	// ErrFailToUnlock means not being able to unlock.
 	ErrFailToUnlock = errors.New("fail to unlock")
+	// ErrNotOwner means the lock is no longer held by the owner.
+	ErrNotOwner = errors.New("not the lock owner")
+)
+
+var (
+	// refreshScript extends the lock only if it is still held by the owner.
+	refreshScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`)
+	// unlockScript deletes the lock only if it is still held by the owner.
+	unlockScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
 )
 
 // Locker holds the driver, configurations and runtime information.
@@ -67,6 +77,7 @@ func main() {
 	l.driver.Reload = l.loadOptions
 	l.driver.RPCHandlers["lock"] = l.lock
 	l.driver.RPCHandlers["unlock"] = l.unlock
+	l.driver.RPCHandlers["refresh"] = l.refresh
 	l.driver.Run()
 	l.nodeID = dipper.GetIP()
 }
@@ -88,10 +99,15 @@ func (l *Locker) lock(msg *dipper.Message) {
 	}
 	defer cancel()
 
+	owner, ok := dipper.GetMapDataStr(msg.Payload, "owner")
+	if !ok {
+		owner = l.nodeID
+	}
+
 	client := redisclient.NewClient(l.redisOptions)
 	defer client.Close()
 
-	ok := dipper.Must(client.SetNX(ctx, l.prefix+name, l.nodeID, expire).Result()).(bool)
+	ok = dipper.Must(client.SetNX(ctx, l.prefix+name, owner, expire).Result()).(bool)
 	if !ok {
 		panic(ErrFailToLock)
 	}
@@ -109,10 +125,36 @@ func (l *Locker) unlock(msg *dipper.Message) {
 	client := redisclient.NewClient(l.redisOptions)
 	defer client.Close()
 
-	ok := dipper.Must(client.Del(ctx, l.prefix+name).Result()).(int64) > 0
+	var ok bool
+	if owner, owned := dipper.GetMapDataStr(msg.Payload, "owner"); owned {
+		// only the owner can unlock, so an expired lock taken by others is kept
+		ok = dipper.Must(unlockScript.Run(ctx, client, []string{l.prefix + name}, owner).Int64()).(int64) > 0
+	} else {
+		ok = dipper.Must(client.Del(ctx, l.prefix+name).Result()).(int64) > 0
+	}
 	if !ok {
 		panic(ErrFailToUnlock)
 	}
 
 	msg.Reply <- dipper.Message{}
 }
+
+func (l *Locker) refresh(msg *dipper.Message) {
+	msg = dipper.DeserializePayload(msg)
+	expire := dipper.Must(time.ParseDuration(dipper.MustGetMapDataStr(msg.Payload, "expire"))).(time.Duration)
+	name := dipper.MustGetMapDataStr(msg.Payload, "name")
+	owner := dipper.MustGetMapDataStr(msg.Payload, "owner")
+
+	ctx, cancel := l.driver.GetContext()
+	defer cancel()
+
+	client := redisclient.NewClient(l.redisOptions)
+	defer client.Close()
+
+	ok := dipper.Must(refreshScript.Run(ctx, client, []string{l.prefix + name}, owner, expire.Milliseconds()).Int64()).(int64) > 0
+	if !ok {
+		panic(ErrNotOwner)
+	}
+
+	msg.Reply <- dipper.Message{}
+}
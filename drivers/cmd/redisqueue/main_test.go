@@ -1,7 +1,6 @@
 package main
 
 import (
-	"io"
 	"os"
 	"testing"
 	"time"
@@ -9,6 +8,7 @@ import (
 	"github.com/go-redis/redismock/v8"
 	"github.com/honeydipper/honeydipper/v3/drivers/pkg/redisclient"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper/drivertest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,52 +21,30 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func testStartDriver(t *testing.T, service string, i *io.PipeReader, o *io.PipeWriter) <-chan struct{} {
-	driver = dipper.NewDriver(service, "redisqueue", dipper.DriverWithReader(i), dipper.DriverWithWriter(o))
-	done := make(chan struct{})
-	go func() {
+func testStartDriver(t *testing.T, service string) *drivertest.Harness {
+	h := drivertest.New(t, service, "redisqueue", func(d *dipper.Driver) {
+		driver = d
 		assert.NotPanics(t, main, "driver main should not panic")
-		close(done)
 		driver = nil
-	}()
+	})
+	h.Start(map[string]interface{}{
+		"connection": map[string]interface{}{
+			"Addr":     "1.1.1.1:6379",
+			"Username": "nouser",
+			"Password": "123",
+			"DB":       "2",
+		},
+	})
 
-	return done
+	return h
 }
 
 func TestLoadDriver(t *testing.T) {
-	i, inbuf := io.Pipe()
-	outbuf, o := io.Pipe()
-	done := testStartDriver(t, "test-service", i, o)
-
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "options",
-		Payload: map[string]interface{}{
-			"data": map[string]interface{}{
-				"connection": map[string]interface{}{
-					"Addr":     "1.1.1.1:6379",
-					"Username": "nouser",
-					"Password": "123",
-					"DB":       "2",
-				},
-			},
-		},
-	})
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "start",
-	})
+	h := testStartDriver(t, "test-service")
 
-	reply := dipper.FetchRawMessage(outbuf)
-	assert.Equal(t, "state", reply.Channel, "reply channel should be state")
-	assert.Equal(t, "alive", reply.Subject, "reply subject should be alive")
-	_, exists := dipper.GetMapData(driver.Options, "data.connection.Password")
+	_, exists := dipper.GetMapData(h.Driver.Options, "data.connection.Password")
 	assert.False(t, exists, "Password should be removed from the driver options")
 	assert.NotNil(t, redisOptions, "redisOptions should not be nil afterwards")
-
-	driver.State = dipper.DriverStateCompleted
-	inbuf.Close()
-	<-done
 }
 
 func TestOperatorRelayToRedis(t *testing.T) {
@@ -75,40 +53,14 @@ func TestOperatorRelayToRedis(t *testing.T) {
 		Client: db,
 	}
 
-	i, inbuf := io.Pipe()
-	outbuf, o := io.Pipe()
-
-	done := testStartDriver(t, "operator", i, o)
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "options",
-		Payload: map[string]interface{}{
-			"data": map[string]interface{}{
-				"connection": map[string]interface{}{
-					"Addr":     "1.1.1.1:6379",
-					"Username": "nouser",
-					"Password": "123",
-					"DB":       "2",
-				},
-			},
-		},
-	})
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "start",
-	})
-
-	reply := dipper.FetchRawMessage(outbuf)
-	assert.Equal(t, "state", reply.Channel, "reply channel should be state")
-	assert.Equal(t, "alive", reply.Subject, "reply subject should be alive")
-
-	driver.State = dipper.DriverStateCompleted
+	h := testStartDriver(t, "operator")
+	h.Driver.State = dipper.DriverStateCompleted
 
 	mock.MatchExpectationsInOrder(false)
 	mock.ExpectBLPop(time.Second, "honeydipper:commands").SetVal([]string{})
 	mock.ExpectRPush("honeydipper:events", `{"data":"{\"foo\":\"bar\"}","labels":{"from":"`+dipper.GetIP()+`"}}`).SetVal(1)
 
-	dipper.SendMessage(inbuf, &dipper.Message{
+	h.Send(&dipper.Message{
 		Channel: "eventbus",
 		Subject: "message",
 		Payload: map[string]interface{}{
@@ -117,8 +69,6 @@ func TestOperatorRelayToRedis(t *testing.T) {
 	})
 	time.Sleep(time.Millisecond * 100)
 	assert.NoError(t, mock.ExpectationsWereMet(), "mock redis expectations not met")
-	inbuf.Close()
-	<-done
 }
 
 func TestOperatorEmit(t *testing.T) {
@@ -127,44 +77,15 @@ func TestOperatorEmit(t *testing.T) {
 		Client: db,
 	}
 
-	i, inbuf := io.Pipe()
-	outbuf, o := io.Pipe()
-
-	done := testStartDriver(t, "operator", i, o)
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "options",
-		Payload: map[string]interface{}{
-			"data": map[string]interface{}{
-				"connection": map[string]interface{}{
-					"Addr":     "1.1.1.1:6379",
-					"Username": "nouser",
-					"Password": "123",
-					"DB":       "2",
-				},
-			},
-		},
-	})
-	dipper.SendMessage(inbuf, &dipper.Message{
-		Channel: "command",
-		Subject: "start",
-	})
-
-	reply := dipper.FetchRawMessage(outbuf)
-	assert.Equal(t, "state", reply.Channel, "reply channel should be state")
-	assert.Equal(t, "alive", reply.Subject, "reply subject should be alive")
-	driver.State = "completed"
+	h := testStartDriver(t, "operator")
+	h.Driver.State = dipper.DriverStateCompleted
 
 	mock.MatchExpectationsInOrder(false)
 	mock.ExpectBLPop(time.Second, "honeydipper:commands").SetVal([]string{"honeydipper:command", `{"labels": {"from": "1.1.1.1"}, "data": {"foo": "bar"}}`})
 
-	reply = dipper.FetchRawMessage(outbuf)
-	assert.Equal(t, "eventbus", reply.Channel, "reply channel should be state")
-	assert.Equal(t, "command", reply.Subject, "reply subject should be alive")
+	reply := h.Next("eventbus", "command")
+	assert.NotNil(t, reply, "driver should relay the command from redis")
 
 	time.Sleep(time.Millisecond * 100)
 	assert.NoError(t, mock.ExpectationsWereMet(), "mock redis expectations not met")
-
-	inbuf.Close()
-	<-done
 }
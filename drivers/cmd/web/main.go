@@ -39,7 +39,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "web")
+	driver = dipper.NewDriver(os.Args[1], "web", dipper.DriverWithServices("operator"))
 	if driver.Service == "operator" {
 		driver.Reload = func(*dipper.Message) {
 			log = nil
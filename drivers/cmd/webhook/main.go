@@ -68,7 +68,7 @@ func main() {
 	initFlags()
 	flag.Parse()
 
-	driver = dipper.NewDriver(os.Args[1], "webhook")
+	driver = dipper.NewDriver(os.Args[1], "webhook", dipper.DriverWithServices("receiver"))
 	if driver.Service == "receiver" {
 		driver.Start = startWebhook
 		driver.Stop = stopWebhook
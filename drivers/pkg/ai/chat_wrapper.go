@@ -10,6 +10,9 @@ import (
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
 
+// CancelCheckTimeout is the timeout in seconds for checking if the user cancelled the turn.
+const CancelCheckTimeout time.Duration = 2
+
 // ChatWrapper wraps around the chat client and sessions handles streaming, emitting and error handling.
 type ChatWrapper struct {
 	driver         *dipper.Driver
@@ -232,8 +235,13 @@ func (w *ChatWrapper) streamHandler(t string, done bool) {
 		now := time.Now()
 		if time.Now().After(w.lastCheck.Add(time.Second * 10)) {
 			w.lastCheck = now
-			cancelled := len(dipper.Must(w.driver.CallRaw("cache", "exists", []byte(w.step))).([]byte)) == 0
-			if cancelled {
+			ctx, cancel := context.WithTimeout(w.internalCtx, time.Second*CancelCheckTimeout)
+			ret, err := w.driver.CallRawContext(ctx, "cache", "exists", []byte(w.step))
+			cancel()
+			if err != nil {
+				// don't hold up the stream, check again later
+				dipper.Logger.Warningf("unable to check for user cancellation: %v", err)
+			} else if len(ret) == 0 {
 				defer w.cancel()
 				dipper.Logger.Warningf("cancelling after user cancel")
 				w.chatEmit(true)
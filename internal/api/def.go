@@ -21,6 +21,7 @@ type Def struct {
 	Service    string
 	AckTimeout time.Duration
 	Timeout    time.Duration
+	Identity   bool // pass the subject and the roles of the caller to the service
 }
 
 const (
@@ -38,13 +39,43 @@ const (
 // GetDefs return definition for all known API calls.
 func GetDefs() map[string]map[string]Def {
 	return map[string]map[string]Def{
+		"events/:eventID": {
+			http.MethodGet: {Object: "event", Name: "eventHistory", ReqType: TypeMatch, Service: "engine"},
+		},
+		"events/:eventID/sessions/:sessionID": {
+			http.MethodGet: {Object: "event", Name: "eventSessionHistory", ReqType: TypeMatch, Service: "engine"},
+		},
 		"events/:eventID/wait": {
 			http.MethodGet: {Object: "event", Name: "eventWait", ReqType: TypeMatch, Service: "engine", Timeout: InfiniteDuration},
 		},
+		"events/:eventID/cancel": {
+			http.MethodPost: {Object: "event", Name: "eventCancel", ReqType: TypeMatch, Service: "engine"},
+		},
 		"events": {
 			http.MethodGet:  {Object: "event", Name: "eventList", ReqType: TypeAll, Service: "engine"},
 			http.MethodPost: {Object: "event", Name: "eventAdd", ReqType: TypeFirst, Service: "receiver"},
 		},
+		"approvals": {
+			http.MethodGet: {Object: "approval", Name: "approvalList", ReqType: TypeAll, Service: "engine"},
+		},
+		"approvals/:approvalID/approve": {
+			http.MethodPost: {Object: "approval", Name: "approvalApprove", ReqType: TypeMatch, Service: "engine", Identity: true},
+		},
+		"approvals/:approvalID/reject": {
+			http.MethodPost: {Object: "approval", Name: "approvalReject", ReqType: TypeMatch, Service: "engine", Identity: true},
+		},
+		"deadletters": {
+			http.MethodGet: {Object: "deadletter", Name: "deadLetterList", ReqType: TypeAll, Service: "engine"},
+		},
+		"deadletters/:deadLetterID": {
+			http.MethodGet: {Object: "deadletter", Name: "deadLetterGet", ReqType: TypeMatch, Service: "engine"},
+		},
+		"deadletters/:deadLetterID/replay": {
+			http.MethodPost: {Object: "deadletter", Name: "deadLetterReplay", ReqType: TypeMatch, Service: "engine"},
+		},
+		"drivers": {
+			http.MethodGet: {Object: "driver", Name: "driverList", ReqType: TypeAll, Service: "api"},
+		},
 	}
 }
 
@@ -11,6 +11,7 @@ package api
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"testing"
 	"time"
@@ -160,3 +161,38 @@ func TestTypeMatchAPILongRequest(t *testing.T) {
 func TestUnauthorizedAPI(t *testing.T) {
 	requestTest(t, "UnauthorizedAPI")
 }
+
+func TestGetRequestIdentity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	l := NewStore(nil)
+	l.config = map[string]interface{}{
+		"auth": map[string]interface{}{
+			"casbin": map[string]interface{}{
+				"models": []interface{}{
+					"[request_definition]\nr = sub, obj, act, provider\n" +
+						"[policy_definition]\np = sub, obj, act, provider\n" +
+						"[role_definition]\ng = _, _\n" +
+						"[policy_effect]\ne = some(where (p.eft == allow))\n" +
+						"[matchers]\nm = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act && r.provider == p.provider",
+				},
+				"policies": []interface{}{
+					"p, sre, approval, POST, auth-simple\ng, alice, oncall\ng, oncall, sre",
+				},
+			},
+		},
+	}
+	l.setupAuthorization()
+
+	mockReqCtx := mock_api.NewMockRequestContext(ctrl)
+	mockReqCtx.EXPECT().GetPath().Times(1).Return("/approvals/123/approve")
+	mockReqCtx.EXPECT().GetPayload(gomock.Eq(http.MethodPost)).Times(1).Return(map[string]interface{}{"approvalID": "123"})
+	mockReqCtx.EXPECT().Get(gomock.Eq("subject")).Times(1).Return("alice", true)
+	mockReqCtx.EXPECT().ContentType().Times(1).Return("application/json")
+
+	req := l.GetRequest(Def{Name: "approvalApprove", Method: http.MethodPost, Identity: true}, mockReqCtx)
+	assert.Equal(t, "alice", req.params["subject"], "should pass the subject")
+	assert.ElementsMatch(t, []string{"oncall", "sre"}, req.params["roles"], "should pass the roles including the inherited ones")
+	assert.Empty(t, l.GetRoles(nil), "should return no roles without subject")
+}
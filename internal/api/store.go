@@ -7,6 +7,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -28,6 +29,9 @@ const (
 	// DefaultAPIWriteTimeout is the default timeout in seconds for responding to the request.
 	DefaultAPIWriteTimeout time.Duration = 10
 
+	// DefaultAPIAuthTimeout is the default timeout in seconds for each auth provider to authenticate the request.
+	DefaultAPIAuthTimeout time.Duration = 5
+
 	// ACLAllow reprensts allowing the subject to access the API.
 	ACLAllow = "allow"
 
@@ -55,6 +59,7 @@ type Store struct {
 	enforcer        *casbin.Enforcer
 
 	writeTimeout time.Duration
+	authTimeout  time.Duration
 }
 
 // HandleAPIACK handles the call ACK from the eventbus.
@@ -153,6 +158,11 @@ func (l *Store) GetAPIHandler(prefix string, cfg interface{}) http.Handler {
 		l.writeTimeout = dipper.Must(time.ParseDuration(writeTimeoutStr)).(time.Duration)
 	}
 
+	l.authTimeout = DefaultAPIAuthTimeout * time.Second
+	if authTimeoutStr, ok := dipper.GetMapDataStr(l.config, "authTimeout"); ok {
+		l.authTimeout = dipper.Must(time.ParseDuration(authTimeoutStr)).(time.Duration)
+	}
+
 	l.setupRoutes(prefix)
 	l.setupAuthorization()
 
@@ -187,6 +197,22 @@ func (l *Store) Enforce(args ...interface{}) (bool, error) {
 	return ef, nil
 }
 
+// GetRoles returns the roles of the subject, including the inherited ones, defined in the enforcer.
+func (l *Store) GetRoles(subject interface{}) []string {
+	name, ok := subject.(string)
+	if !ok || l.enforcer == nil {
+		return []string{}
+	}
+	roles, err := l.enforcer.GetImplicitRolesForUser(name)
+	if err != nil {
+		dipper.Logger.Warningf("[api] unable to get roles for %s: %+v", name, err)
+
+		return []string{}
+	}
+
+	return roles
+}
+
 // AuthMiddleware is a middleware handles auth.
 func (l *Store) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -206,7 +232,10 @@ func (l *Store) AuthMiddleware() gin.HandlerFunc {
 				fn = parts[1]
 			}
 
-			subject, err := l.caller.Call("driver:"+provider, fn, dipper.ExtractWebRequestExceptBody(c.Request))
+			// give up when the client goes away
+			ctx, cancel := context.WithTimeout(c.Request.Context(), l.authTimeout)
+			subject, err := l.caller.CallContext(ctx, "driver:"+provider, fn, dipper.ExtractWebRequestExceptBody(c.Request))
+			cancel()
 			if err != nil || subject == nil {
 				allErrors[p.(string)] = err.Error()
 			} else {
@@ -330,6 +359,11 @@ func (l *Store) GetRequest(def Def, c RequestContext) *Request {
 
 	// prepare the parameters
 	payload := c.GetPayload(def.Method)
+	if def.Identity {
+		subject, _ := c.Get("subject")
+		payload["subject"] = subject
+		payload["roles"] = l.GetRoles(subject)
+	}
 
 	return &Request{
 		store:       l,
@@ -84,8 +84,14 @@ type Workflow struct {
 	IteratePool     string      `json:"iterate_pool" mapstructure:"iterate_pool"`
 	IterateAs       string      `json:"iterate_as" mapstructure:"iterate_as"`
 
-	Retry   string
-	Backoff string
+	Timeout     string
+	Concurrency *Concurrency
+
+	Retry       string
+	Backoff     string
+	BackoffType string      `json:"backoff_type" mapstructure:"backoff_type"`
+	MaxBackoff  string      `json:"max_backoff" mapstructure:"max_backoff"`
+	RetryOn     interface{} `json:"retry_on" mapstructure:"retry_on"`
 
 	OnError      string `json:"on_error" mapstructure:"on_error"`
 	OnFailure    string `json:"on_failure" mapstructure:"on_failure"`
@@ -96,7 +102,9 @@ type Workflow struct {
 	Steps        []Workflow
 	Threads      []Workflow
 	Wait         string
+	Approval     *Approval
 	Detach       bool
+	Compensate   interface{}
 
 	Switch  string
 	Cases   map[string]interface{}
@@ -109,6 +117,24 @@ type Workflow struct {
 	NoExport        []string               `json:"no_export" mapstructure:"no_export"`
 }
 
+// Approval defines a manual gate in a workflow, and who can approve it.  Anyone allowed to use the
+// approval API can approve if neither approvers nor groups are specified.
+type Approval struct {
+	Approvers   []string
+	Groups      []string
+	Description string
+}
+
+// Concurrency limits the sessions running at the same time with the same key, the workflow name is
+// used if the key is not specified.  The policy decides what to do when the limit is reached, one of
+// queue, skip or cancel_previous.
+type Concurrency struct {
+	Max    int
+	Key    string
+	Policy string
+	Expire string
+}
+
 // Rule is a data structure defining what action to take when certain event happen.
 type Rule struct {
 	When Trigger
@@ -7,33 +7,35 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/honeydipper/honeydipper/v3/internal/daemon"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
 
 // BuiltinDriver are compiled and delivered with daemon binary in the same container image.
 type BuiltinDriver struct {
-	meta   *Meta
-	stream chan<- *dipper.Message
+	connHandler
 
-	input  io.ReadCloser
-	output io.WriteCloser
-	run    *exec.Cmd
+	run      *exec.Cmd
+	runLock  sync.Mutex
+	exitCode int
 }
 
 // BuiltinPath is the path where the builtin drivers are kept. It will try using $HONEYDIPPER_DRIVERS_BUILTIN by default.
 // If $HONEYDIPPER_DRIVERS_BUILTIN is not set, will try using $GOPATH/bin.  If $GOPATH is not defined, use "/opt/honeydipper/driver/builtin".
 var BuiltinPath string
 
-// replace the func variable with mock during testing.
-var execCommand = exec.Command
+// replace the func variables with mock during testing.
+var (
+	execCommand        = exec.Command
+	execCommandContext = exec.CommandContext
+)
 
 func builtinPath() string {
 	if BuiltinPath == "" {
@@ -88,79 +90,84 @@ func (d *BuiltinDriver) Prepare(stream chan<- *dipper.Message) {
 	}
 }
 
-// Meta function exposes the metadata used for this driver handler.
-func (d *BuiltinDriver) Meta() *Meta {
-	return d.meta
-}
-
 // NewBuiltinDriver creates a handler for the builtin driver specified in the meta info.
 func NewBuiltinDriver(m *Meta) *BuiltinDriver {
-	return &BuiltinDriver{meta: m}
+	return &BuiltinDriver{connHandler: connHandler{meta: m}}
 }
 
 // Start the driver child process.  The "service" indicates which service this driver belongs to.
 func (d *BuiltinDriver) Start(service string) {
-	d.run = execCommand(d.meta.Executable, append([]string{service}, d.meta.Arguments...)...)
-	if input, err := d.run.StdoutPipe(); err != nil {
+	d.formatAck = make(chan struct{}, 1)
+	run, err := d.meta.Sandbox.Command(d.meta.Executable, append([]string{service}, d.meta.Arguments...)...)
+	if err != nil {
+		dipper.Logger.Panicf("[%s] Unable to sandbox driver %v", service, err)
+	}
+	input, err := run.StdoutPipe()
+	if err != nil {
 		dipper.Logger.Panicf("[%s] Unable to link to driver stdout %v", service, err)
-	} else {
-		d.input = input
-		go d.fetchMessages(service)
 	}
-
-	if output, err := d.run.StdinPipe(); err != nil {
+	output, err := run.StdinPipe()
+	if err != nil {
 		dipper.Logger.Panicf("[%s] Unable to link to driver stdin %v", service, err)
-	} else {
-		d.output = output
+	}
+	d.conn = d.newConn(input, output)
+	go d.fetchMessages(service, d.conn)
+
+	if d.meta.Framing == dipper.FramingJSONLines {
+		if run.Env == nil {
+			run.Env = os.Environ()
+		}
+		run.Env = append(run.Env, dipper.FramingEnv+"="+dipper.FramingJSONLines)
 	}
 
-	d.run.Stderr = os.Stderr
-	d.run.ExtraFiles = []*os.File{os.Stdout} // giving child process stdout for logging
-	if err := d.run.Start(); err != nil {
+	run.Stderr = os.Stderr
+	run.ExtraFiles = []*os.File{os.Stdout} // giving child process stdout for logging
+	if err := run.Start(); err != nil {
 		dipper.Logger.Panicf("[%s] Failed to start driver %v", service, err)
 	}
-}
 
-// SendMessage sends a dipper message to the driver child process.
-func (d *BuiltinDriver) SendMessage(msg *dipper.Message) {
-	dipper.SendMessage(d.output, msg)
+	d.runLock.Lock()
+	defer d.runLock.Unlock()
+	d.run = run
 }
 
-func (d *BuiltinDriver) fetchMessages(service string) {
-	quit := false
-	daemon.Children.Add(1)
-	defer daemon.Children.Done()
-	for !quit && !daemon.ShuttingDown {
-		func() {
-			defer dipper.SafeExitOnError(
-				"failed to fetching messages from driver %s.%s",
-				service,
-				d.meta.Name,
-			)
-			defer dipper.CatchError(io.EOF, func() { quit = true })
-			for !quit && !daemon.ShuttingDown {
-				message := dipper.FetchRawMessage(d.input)
-				d.stream <- message
-			}
-		}()
+// Wait wait for the driver process to exit.
+func (d *BuiltinDriver) Wait() {
+	d.runLock.Lock()
+	run := d.run
+	d.runLock.Unlock()
+	if run == nil {
+		return
 	}
-	dipper.Logger.Warningf("[%s-%s] driver closed for business", service, d.meta.Name)
-}
 
-// Close close all the channels for the driver and signal the child process to exit.
-func (d *BuiltinDriver) Close() {
-	if d.stream != nil {
-		close(d.stream)
-		d.stream = nil
+	err := run.Wait()
+	d.runLock.Lock()
+	if d.run == run {
+		d.run = nil
 	}
-	if d.output != nil {
-		d.output.Close()
-		d.output = nil
+	d.runLock.Unlock()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		d.exitCode = 0
+	case errors.As(err, &exitErr):
+		d.exitCode = exitErr.ExitCode()
+	default:
+		d.exitCode = ExitCodeUnknown
 	}
 }
 
-// Wait wait for the driver process to exit.
-func (d *BuiltinDriver) Wait() {
-	_ = d.run.Wait()
-	d.run = nil
+// Kill kills the driver process.
+func (d *BuiltinDriver) Kill() {
+	d.runLock.Lock()
+	defer d.runLock.Unlock()
+	if run := d.run; run != nil && run.Process != nil {
+		_ = run.Process.Kill()
+	}
+}
+
+// ExitCode returns the exit code of the driver process, -1 if killed by a signal.
+func (d *BuiltinDriver) ExitCode() int {
+	return d.exitCode
 }
@@ -33,19 +33,19 @@ func TestBuiltinAcquire(t *testing.T) {
 
 	testCases := map[string]interface{}{
 		"panic when shortName is missing": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{}}}},
 			"driver error: shortName is missing ", // error prefix
 		},
 		"panic when using relative path with / in shortName": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "../fakecmd"}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "../fakecmd"}}}},
 			"driver error: shortName has path separator ", // error prefix
 		},
 		"panic when using absolute path with / in shortName": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "/usr/bin/fakecmd"}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "/usr/bin/fakecmd"}}}},
 			"driver error: shortName has path separator ", // error prefix
 		},
 		"has full path in Executable": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "testDriver"}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{"shortName": "testDriver"}}}},
 			"",
 			"test_fixtures/testDriver",
 		},
@@ -78,18 +78,18 @@ func TestBuiltinPrepare(t *testing.T) {
 
 	testCases := map[string]interface{}{
 		"have empty arguments if missing in meta": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{}}}},
 			"", // no error
 			0,  // len(Arguments)
 		},
 		"convert the list of interface to list of strings": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{"arguments": []interface{}{1, 2, false, "test"}}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{"arguments": []interface{}{1, 2, false, "test"}}}}},
 			"",               // no error
 			4,                // len(Arguments)
 			"1 2 false test", // concatenated parameters
 		},
 		"panic when arguments is not a list": []interface{}{
-			&BuiltinDriver{meta: &Meta{HandlerData: map[string]interface{}{"arguments": false}}},
+			&BuiltinDriver{connHandler: connHandler{meta: &Meta{HandlerData: map[string]interface{}{"arguments": false}}}},
 			"driver error: arguments in driver ", // error prefix
 		},
 	}
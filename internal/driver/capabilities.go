@@ -0,0 +1,105 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/mitchellh/mapstructure"
+)
+
+// CapabilitiesQueryTimeout is the timeout in seconds for a driver executable to describe itself.
+const CapabilitiesQueryTimeout time.Duration = 10
+
+// ErrProtocolVersion indicates the driver speaks a protocol version not supported by the daemon.
+var ErrProtocolVersion = errors.New("unsupported protocol version")
+
+// SetCapabilities records the capabilities advertised in the state message from the driver.  Drivers
+// speaking a protocol older than supported are refused, other mismatches are only warned.
+func (runtime *Runtime) SetCapabilities(msg *dipper.Message) error {
+	var payload []byte
+	if msg.IsRaw && msg.Payload != nil {
+		payload = msg.Payload.([]byte)
+	}
+	caps, err := dipper.DecodeCapabilities(msg.Codec, payload)
+	if err != nil {
+		return fmt.Errorf("malformed capabilities: %w", err)
+	}
+
+	name := runtime.Handler.Meta().Name
+	if caps == nil {
+		dipper.Logger.Debugf("[%s] driver %s does not advertise capabilities", runtime.Service, name)
+
+		return nil
+	}
+
+	if caps.ProtocolVersion < dipper.MinProtocolVersion {
+		return fmt.Errorf("%w: driver %s speaks %d, daemon requires %d", ErrProtocolVersion, name, caps.ProtocolVersion, dipper.MinProtocolVersion)
+	}
+	if caps.ProtocolVersion > dipper.ProtocolVersion {
+		dipper.Logger.Warningf("[%s] driver %s speaks newer protocol %d than daemon %d", runtime.Service, name, caps.ProtocolVersion, dipper.ProtocolVersion)
+	}
+	if !caps.AcceptsService(runtime.Service) {
+		dipper.Logger.Warningf("[%s] driver %s is not meant for the service, only %v", runtime.Service, name, caps.Services)
+	}
+	if meta := runtime.Handler.Meta(); !caps.SupportsFraming(meta.Framing) || !caps.SupportsCodec(meta.Codec) {
+		dipper.Logger.Warningf("[%s] driver %s does not support %s framing with %s codec", runtime.Service, name, meta.Framing, meta.Codec)
+	}
+
+	runtime.Capabilities = caps
+
+	return nil
+}
+
+// QueryCapabilities runs the driver executable only to describe itself, without starting the driver.
+// Used for checking config and generating documents, only builtin drivers are supported.
+func QueryCapabilities(metaData map[string]interface{}, service string) (caps *dipper.Capabilities, err error) {
+	var meta Meta
+	if err = mapstructure.Decode(metaData, &meta); err != nil {
+		return nil, fmt.Errorf("malformat driver meta: %+v: %w", metaData, err)
+	}
+	if meta.Type != "builtin" {
+		return nil, fmt.Errorf("%w: unable to query capabilities for driver type: %s", ErrDriverError, meta.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			caps = nil
+			err = fmt.Errorf("%w: %v", ErrDriverError, r)
+		}
+	}()
+
+	d := NewBuiltinDriver(&meta)
+	d.Acquire()
+	d.Prepare(nil)
+
+	if _, err = os.Stat(meta.Executable); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CapabilitiesQueryTimeout*time.Second)
+	defer cancel()
+
+	cmd := execCommandContext(ctx, meta.Executable, append([]string{service}, meta.Arguments...)...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, dipper.CapabilitiesEnv+"=1")
+	cmd.ExtraFiles = []*os.File{os.Stderr} // driver logs are not needed
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: driver %s failed to describe itself: %w", ErrDriverError, meta.Name, err)
+	}
+
+	return dipper.DecodeCapabilities(dipper.CodecJSON, out)
+}
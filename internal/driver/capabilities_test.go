@@ -0,0 +1,89 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeSetCapabilities(t *testing.T) {
+	runtime := &Runtime{
+		Service: "operator",
+		Handler: NewNullDriver(&Meta{Name: "test", Type: "null"}),
+	}
+
+	assert.NoError(t, runtime.SetCapabilities(&dipper.Message{IsRaw: true}), "should accept drivers not advertising")
+	assert.Nil(t, runtime.Capabilities, "should not record capabilities when not advertised")
+
+	payload := dipper.Must(dipper.EncodeContent(dipper.CodecMsgpack, &dipper.Capabilities{
+		ProtocolVersion: dipper.ProtocolVersion,
+		Name:            "test",
+		Commands:        []string{"run"},
+	})).([]byte)
+	assert.NoError(t, runtime.SetCapabilities(&dipper.Message{IsRaw: true, Payload: payload, Codec: dipper.CodecMsgpack}), "should accept current protocol")
+	assert.True(t, runtime.Capabilities.HasCommand("run"), "should record capabilities")
+
+	payload = []byte(fmt.Sprintf(`{"protocolVersion": %d}`, dipper.MinProtocolVersion-1))
+	err := runtime.SetCapabilities(&dipper.Message{IsRaw: true, Payload: payload})
+	assert.ErrorIs(t, err, ErrProtocolVersion, "should refuse old protocol")
+}
+
+func TestQueryCapabilities(t *testing.T) {
+	savedPath, savedExec := BuiltinPath, execCommandContext
+	defer func() {
+		BuiltinPath, execCommandContext = savedPath, savedExec
+	}()
+	BuiltinPath = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(BuiltinPath, "test"), []byte{}, 0o600))
+
+	execCommandContext = generateFakeExecCommandContext("TestHelperDescribeDriver")
+	caps, err := QueryCapabilities(map[string]interface{}{
+		"name": "test",
+		"type": "builtin",
+		"handlerData": map[string]interface{}{
+			"shortName": "test",
+		},
+	}, "operator")
+	assert.NoError(t, err, "should query capabilities")
+	assert.True(t, caps.HasCommand("run"), "should describe driver commands")
+
+	_, err = QueryCapabilities(map[string]interface{}{
+		"name": "test",
+		"type": "builtin",
+		"handlerData": map[string]interface{}{
+			"shortName": "missing",
+		},
+	}, "operator")
+	assert.Error(t, err, "should fail when driver executable is missing")
+
+	_, err = QueryCapabilities(map[string]interface{}{"name": "test", "type": "null"}, "operator")
+	assert.ErrorIs(t, err, ErrDriverError, "should only query builtin drivers")
+}
+
+func TestHelperDescribeDriver(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	if os.Getenv(dipper.CapabilitiesEnv) != "" {
+		_ = json.NewEncoder(os.Stdout).Encode(&dipper.Capabilities{
+			ProtocolVersion: dipper.ProtocolVersion,
+			Name:            "test",
+			Commands:        []string{"run"},
+		})
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"io"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// FormatNegotiationTimeout is the timeout in seconds for a driver to acknowledge the requested message format.
+const FormatNegotiationTimeout time.Duration = 5
+
+// connHandler exchanges messages with a driver through a dipper.Conn, shared by the handlers
+// talking to a driver process, whether it is a child process or a remote one.
+type connHandler struct {
+	meta   *Meta
+	stream chan<- *dipper.Message
+
+	conn      *dipper.Conn
+	formatAck chan struct{}
+}
+
+// Meta function exposes the metadata used for this driver handler.
+func (d *connHandler) Meta() *Meta {
+	return d.meta
+}
+
+// newConn creates the connection to the driver, the jsonl framing is used from the start as it is
+// selected in the meta instead of negotiated.
+func (d *connHandler) newConn(in io.Reader, out io.Writer) *dipper.Conn {
+	conn := dipper.NewConn(in, out)
+	if d.meta.Framing == dipper.FramingJSONLines {
+		conn.SetFormat(dipper.NegotiateFormat(dipper.FramingJSONLines, dipper.CodecJSON))
+	}
+
+	return conn
+}
+
+// SendMessage sends a dipper message to the driver.
+func (d *connHandler) SendMessage(msg *dipper.Message) {
+	if _, ok := msg.Labels["framing"]; ok && msg.Channel == "command" && msg.Subject == "options" {
+		select {
+		case <-d.formatAck:
+		default:
+		}
+		d.conn.SendMessage(msg)
+		d.waitFormat()
+
+		return
+	}
+	d.conn.SendMessage(msg)
+}
+
+// waitFormat waits for the driver to acknowledge the format requested in options, legacy
+// drivers never acknowledge and keep using text framing with json.
+func (d *connHandler) waitFormat() {
+	timer := time.NewTimer(FormatNegotiationTimeout * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-d.formatAck:
+	case <-timer.C:
+		dipper.Logger.Warningf("[%s] driver did not acknowledge message format, using text", d.meta.Name)
+	}
+}
+
+// switchFormat changes the format of the comm channels when the driver acknowledges it.
+func (d *connHandler) switchFormat(msg *dipper.Message) {
+	framing, codecName := dipper.NegotiateFormat(msg.Labels["framing"], msg.Labels["codec"])
+	d.conn.SetFormat(framing, codecName)
+	dipper.Logger.Infof("[%s] driver using %s framing with %s codec", d.meta.Name, framing, codecName)
+
+	select {
+	case d.formatAck <- struct{}{}:
+	default:
+	}
+}
+
+func (d *connHandler) fetchMessages(service string, conn *dipper.Conn) {
+	quit := false
+	daemon.Children.Add(1)
+	defer daemon.Children.Done()
+	for !quit && !daemon.ShuttingDown {
+		func() {
+			defer dipper.SafeExitOnError(
+				"failed to fetching messages from driver %s.%s",
+				service,
+				d.meta.Name,
+			)
+			for !quit && !daemon.ShuttingDown {
+				message, err := conn.ReadMessage()
+				if dipper.IsConnClosed(err) {
+					quit = true
+
+					return
+				}
+				dipper.Must(err)
+				if message.Channel == dipper.ChannelState && message.Subject == "format" {
+					d.switchFormat(message)
+
+					continue
+				}
+				d.stream <- message
+			}
+		}()
+	}
+	dipper.Logger.Warningf("[%s-%s] driver closed for business", service, d.meta.Name)
+}
+
+// Close close all the channels for the driver, the driver is expected to exit or disconnect.
+func (d *connHandler) Close() {
+	if d.stream != nil {
+		close(d.stream)
+		d.stream = nil
+	}
+	if d.conn != nil {
+		d.conn.Close()
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnHandlerSwitchFormat(t *testing.T) {
+	d := &connHandler{
+		meta:      &Meta{Name: "test"},
+		conn:      dipper.NewConn(&bytes.Buffer{}, &nopWriteCloser{&bytes.Buffer{}}),
+		formatAck: make(chan struct{}, 1),
+	}
+	defer d.Close()
+
+	d.switchFormat(&dipper.Message{
+		Channel: dipper.ChannelState,
+		Subject: "format",
+		Labels:  map[string]string{"framing": dipper.FramingBinary, "codec": dipper.CodecMsgpack},
+	})
+
+	framing, codecName := d.conn.Format()
+	assert.Equal(t, dipper.FramingBinary, framing, "should switch to binary framing")
+	assert.Equal(t, dipper.CodecMsgpack, codecName, "should switch to msgpack")
+	assert.NotPanics(t, d.waitFormat, "should receive the acknowledgement")
+}
+
+func TestConnHandlerJSONLines(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := &connHandler{meta: &Meta{Name: "test", Framing: dipper.FramingJSONLines}}
+	d.conn = d.newConn(&bytes.Buffer{}, &nopWriteCloser{out})
+	d.formatAck = make(chan struct{}, 1)
+	defer d.Close()
+
+	d.SendMessage(&dipper.Message{Channel: "command", Subject: "start"})
+	assert.Equal(t, `{"channel":"command","subject":"start"}`+"\n", out.String(), "should send jsonl from the start")
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
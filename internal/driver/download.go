@@ -0,0 +1,330 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// DownloadTimeout is the default timeout in seconds for downloading a driver executable.
+const DownloadTimeout time.Duration = 60
+
+// OCI media types and annotations used when fetching driver executables from OCI artifacts.
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociTitle        = "org.opencontainers.image.title"
+)
+
+// ErrVerification indicates the downloaded driver executable does not match the checksum or the signature.
+var ErrVerification = errors.New("verification failed")
+
+// DriverCachePath is the path where the downloaded drivers are kept. It will try using $HONEYDIPPER_DRIVERS_CACHE
+// by default. If $HONEYDIPPER_DRIVERS_CACHE is not set, will use the honeydipper/drivers in user cache directory.
+var DriverCachePath string
+
+func driverCachePath() string {
+	if DriverCachePath == "" {
+		if cachePath, ok := os.LookupEnv("HONEYDIPPER_DRIVERS_CACHE"); ok {
+			DriverCachePath = cachePath
+		} else if userCache, err := os.UserCacheDir(); err == nil {
+			DriverCachePath = filepath.Join(userCache, "honeydipper", "drivers")
+		} else {
+			DriverCachePath = filepath.Join(os.TempDir(), "honeydipper", "drivers")
+		}
+	}
+
+	return DriverCachePath
+}
+
+// DownloadDriver fetches the driver executable from a URL or an OCI artifact into the cache directory,
+// verifies it with the sha256 checksum and optionally the ed25519 signature in the driver meta, then runs
+// it like a builtin driver.
+type DownloadDriver struct {
+	BuiltinDriver
+}
+
+// NewDownloadDriver creates a handler for the downloaded driver specified in the meta info.
+func NewDownloadDriver(m *Meta) *DownloadDriver {
+	return &DownloadDriver{BuiltinDriver: BuiltinDriver{connHandler: connHandler{meta: m}}}
+}
+
+// Acquire function downloads the driver executable unless it is already in the cache, and verifies it.
+func (d *DownloadDriver) Acquire() {
+	source, ok := d.meta.HandlerData["url"].(string)
+	if !ok || source == "" {
+		panic(fmt.Errorf("%w: url is missing for downloaded driver: %s", ErrDriverError, d.meta.Name))
+	}
+	checksum, _ := d.meta.HandlerData["sha256"].(string)
+	checksum = strings.ToLower(checksum)
+	if sum, err := hex.DecodeString(checksum); err != nil || len(sum) != sha256.Size {
+		panic(fmt.Errorf("%w: sha256 is missing or malformed for downloaded driver: %s", ErrDriverError, d.meta.Name))
+	}
+
+	cacheDir := driverCachePath()
+	executable := filepath.Join(cacheDir, checksum)
+	if err := verifyChecksum(executable, checksum); err != nil {
+		dipper.Logger.Infof("[%s] downloading driver from %s", d.meta.Name, source)
+		dipper.Must(os.MkdirAll(cacheDir, 0o755))
+		if err := d.download(source, cacheDir, executable, checksum); err != nil {
+			panic(fmt.Errorf("%w: unable to download driver %s: %w", ErrDriverError, d.meta.Name, err))
+		}
+	}
+
+	if err := d.verifySignature(executable); err != nil {
+		panic(fmt.Errorf("%w: driver %s: %w", ErrDriverError, d.meta.Name, err))
+	}
+
+	d.meta.Executable = executable
+}
+
+// download saves the executable into a temporary file in the cache and moves it in place once verified,
+// so a partial download is never used.
+func (d *DownloadDriver) download(source string, cacheDir string, executable string, checksum string) error {
+	tmp, err := os.CreateTemp(cacheDir, d.meta.Name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	timeout := DownloadTimeout * time.Second
+	if t, ok := dipper.GetMapDataStr(d.meta.HandlerData, "timeout"); ok {
+		timeout = dipper.Must(time.ParseDuration(t)).(time.Duration)
+	}
+	client := &http.Client{Timeout: timeout}
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+	if strings.HasPrefix(source, "oci://") {
+		err = d.fetchOCI(client, strings.TrimPrefix(source, "oci://"), w)
+	} else {
+		err = fetchURL(client, source, nil, w)
+	}
+	if err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("%w: sha256 mismatch, got %s", ErrVerification, actual)
+	}
+	if err := tmp.Chmod(0o755); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), executable)
+}
+
+// verifySignature checks the ed25519 signature of the executable, if a public key is given in the meta.
+func (d *DownloadDriver) verifySignature(executable string) error {
+	publicKey, hasKey := d.meta.HandlerData["publicKey"].(string)
+	signature, hasSignature := d.meta.HandlerData["signature"].(string)
+	if !hasKey && !hasSignature {
+		return nil
+	}
+	if !hasKey || !hasSignature {
+		return fmt.Errorf("%w: both publicKey and signature are required", ErrVerification)
+	}
+
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return fmt.Errorf("%w: publicKey is not in PEM format", ErrVerification)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVerification, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: publicKey is not an ed25519 key", ErrVerification)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVerification, err)
+	}
+
+	content, err := os.ReadFile(executable)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(edKey, content, sig) {
+		return fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	return nil
+}
+
+// fetchOCI downloads the driver executable from an OCI artifact, e.g. registry.example.com/team/driver:v1.
+// The layer titled with the file name in the meta is used, or the first layer if not specified.
+func (d *DownloadDriver) fetchOCI(client *http.Client, ref string, w io.Writer) error {
+	registry, repo, found := strings.Cut(ref, "/")
+	if !found {
+		return fmt.Errorf("%w: invalid OCI reference: %s", ErrDriverError, ref)
+	}
+	reference := "latest"
+	if i := strings.LastIndex(repo, "@"); i >= 0 {
+		repo, reference = repo[:i], repo[i+1:]
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, reference = repo[:i], repo[i+1:]
+	}
+
+	scheme := "https"
+	if dipper.CheckMapData(d.meta.HandlerData, "plainHTTP") {
+		scheme = "http"
+	}
+	base := fmt.Sprintf("%s://%s/v2/%s", scheme, registry, repo)
+
+	var manifest struct {
+		Layers []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	buf := &strings.Builder{}
+	if err := fetchURL(client, base+"/manifests/"+reference, map[string]string{"Accept": ociManifestType}, buf); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &manifest); err != nil {
+		return fmt.Errorf("%w: malformed OCI manifest: %w", ErrDriverError, err)
+	}
+
+	file, _ := d.meta.HandlerData["file"].(string)
+	for _, layer := range manifest.Layers {
+		if file == "" || layer.Annotations[ociTitle] == file {
+			return fetchURL(client, base+"/blobs/"+layer.Digest, nil, w)
+		}
+	}
+
+	return fmt.Errorf("%w: no matching layer in OCI artifact: %s", ErrDriverError, ref)
+}
+
+// fetchURL downloads the content of the URL into the writer, getting an anonymous token
+// if the server asks for a bearer token, e.g. OCI registries.
+func fetchURL(client *http.Client, source string, headers map[string]string, w io.Writer) error {
+	get := func(token string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		return client.Do(req)
+	}
+
+	resp, err := get("")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if challenge := resp.Header.Get("WWW-Authenticate"); resp.StatusCode == http.StatusUnauthorized && challenge != "" {
+		token, err := fetchToken(client, challenge)
+		if err != nil {
+			return err
+		}
+		if resp, err = get(token); err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", ErrDriverError, source, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+
+	return err
+}
+
+// fetchToken gets an anonymous bearer token as instructed in the challenge, e.g.
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:team/driver:pull".
+func fetchToken(client *http.Client, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "bearer") {
+		return "", fmt.Errorf("%w: unsupported auth challenge: %s", ErrDriverError, challenge)
+	}
+
+	query := url.Values{}
+	var realm string
+	for _, param := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+		v = strings.Trim(v, `"`)
+		if k == "realm" {
+			realm = v
+		} else {
+			query.Set(k, v)
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("%w: auth challenge without realm: %s", ErrDriverError, challenge)
+	}
+
+	resp, err := client.Get(realm + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s returned %s", ErrDriverError, realm, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("%w: malformed token: %w", ErrDriverError, err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	return token.AccessToken, nil
+}
+
+// verifyChecksum checks the sha256 checksum of the file.
+func verifyChecksum(file string, checksum string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return fmt.Errorf("%w: sha256 mismatch: %s", ErrVerification, file)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDriverContent = []byte("#!/bin/sh\necho driver\n")
+
+func testDriverChecksum() string {
+	sum := sha256.Sum256(testDriverContent)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadAcquire(t *testing.T) {
+	savedPath := DriverCachePath
+	defer func() { DriverCachePath = savedPath }()
+	DriverCachePath = t.TempDir()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testDriverContent)
+	}))
+
+	d := NewDownloadDriver(&Meta{Name: "test", HandlerData: map[string]interface{}{
+		"url":    server.URL + "/driver",
+		"sha256": testDriverChecksum(),
+	}})
+	d.Acquire()
+	assert.Equal(t, filepath.Join(DriverCachePath, testDriverChecksum()), d.meta.Executable, "should run from the cache")
+	content, err := os.ReadFile(d.meta.Executable)
+	assert.NoError(t, err, "should save the executable")
+	assert.Equal(t, testDriverContent, content, "should save the downloaded content")
+
+	server.Close()
+	assert.NotPanics(t, NewDownloadDriver(&Meta{Name: "test", HandlerData: d.meta.HandlerData}).Acquire, "should use the cached executable")
+
+	testCases := map[string]map[string]interface{}{
+		"missing url":       {"sha256": testDriverChecksum()},
+		"missing sha256":    {"url": server.URL},
+		"malformed sha256":  {"url": server.URL, "sha256": "abc"},
+		"checksum mismatch": {"url": server.URL, "sha256": strings.Repeat("0", 64)},
+	}
+	for msg, handlerData := range testCases {
+		assert.Panics(t, NewDownloadDriver(&Meta{Name: "test", HandlerData: handlerData}).Acquire, "should panic with %s", msg)
+	}
+
+	entries, err := os.ReadDir(DriverCachePath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "should not leave partial downloads in the cache")
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	savedPath := DriverCachePath
+	defer func() { DriverCachePath = savedPath }()
+	DriverCachePath = t.TempDir()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tampered"))
+	}))
+	defer server.Close()
+
+	d := NewDownloadDriver(&Meta{Name: "test", HandlerData: map[string]interface{}{
+		"url":    server.URL,
+		"sha256": testDriverChecksum(),
+	}})
+	assert.PanicsWithError(t, fmt.Sprintf(
+		"driver error: unable to download driver test: verification failed: sha256 mismatch, got %x",
+		sha256.Sum256([]byte("tampered")),
+	), d.Acquire, "should refuse tampered executable")
+
+	entries, err := os.ReadDir(DriverCachePath)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "should not keep tampered executable")
+}
+
+func TestDownloadSignature(t *testing.T) {
+	savedPath := DriverCachePath
+	defer func() { DriverCachePath = savedPath }()
+	DriverCachePath = t.TempDir()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testDriverContent)
+	}))
+	defer server.Close()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	handlerData := map[string]interface{}{
+		"url":       server.URL,
+		"sha256":    testDriverChecksum(),
+		"publicKey": publicKey,
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(priv, testDriverContent)),
+	}
+	assert.NotPanics(t, NewDownloadDriver(&Meta{Name: "test", HandlerData: handlerData}).Acquire, "should accept signed executable")
+
+	handlerData["signature"] = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("something else")))
+	assert.Panics(t, NewDownloadDriver(&Meta{Name: "test", HandlerData: handlerData}).Acquire, "should refuse invalid signature")
+
+	delete(handlerData, "publicKey")
+	assert.Panics(t, NewDownloadDriver(&Meta{Name: "test", HandlerData: handlerData}).Acquire, "should require public key with signature")
+}
+
+func TestDownloadOCI(t *testing.T) {
+	savedPath := DriverCachePath
+	defer func() { DriverCachePath = savedPath }()
+	DriverCachePath = t.TempDir()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			assert.Equal(t, "repository:team/driver:pull", r.URL.Query().Get("scope"), "should ask token for the scope")
+			_, _ = w.Write([]byte(`{"token": "anonymous"}`))
+		case r.Header.Get("Authorization") != "Bearer anonymous":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:team/driver:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/team/driver/manifests/v1":
+			assert.Equal(t, ociManifestType, r.Header.Get("Accept"), "should ask for OCI manifest")
+			_, _ = w.Write([]byte(`{"layers": [
+				{"digest": "sha256:readme", "annotations": {"org.opencontainers.image.title": "README.md"}},
+				{"digest": "sha256:driver", "annotations": {"org.opencontainers.image.title": "driver"}}
+			]}`))
+		case r.URL.Path == "/v2/team/driver/blobs/sha256:driver":
+			_, _ = w.Write(testDriverContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDownloadDriver(&Meta{Name: "test", HandlerData: map[string]interface{}{
+		"url":       "oci://" + strings.TrimPrefix(server.URL, "http://") + "/team/driver:v1",
+		"file":      "driver",
+		"plainHTTP": true,
+		"sha256":    testDriverChecksum(),
+	}})
+	d.Acquire()
+	content, err := os.ReadFile(d.meta.Executable)
+	assert.NoError(t, err, "should save the executable")
+	assert.Equal(t, testDriverContent, content, "should download the titled layer")
+}
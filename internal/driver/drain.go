@@ -0,0 +1,98 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDrainTimeout is the time in seconds to wait for the calls in flight before stopping a replaced driver.
+	DefaultDrainTimeout time.Duration = 30
+
+	// DrainCheckInterval is the interval in milliseconds for checking the calls in flight while draining.
+	DrainCheckInterval time.Duration = 100
+)
+
+// calls tracks the calls in flight in the driver, identified by the session or the rpc.
+type calls struct {
+	lock     sync.Mutex
+	inFlight map[string]int
+	total    int
+}
+
+// validateDrain checks the drain timeout in the meta.
+func (m *Meta) validateDrain() error {
+	if m.DrainTimeout == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(m.DrainTimeout); err != nil {
+		return fmt.Errorf("%w: invalid drainTimeout for driver %s: %w", ErrDriverError, m.Name, err)
+	}
+
+	return nil
+}
+
+// DrainPeriod returns the time to wait for the calls in flight before stopping a replaced driver.
+func (m *Meta) DrainPeriod() time.Duration {
+	return parseDuration(m.DrainTimeout, DefaultDrainTimeout*time.Second)
+}
+
+// CallStarted records a call sent to the driver, calls without id are not tracked.
+func (runtime *Runtime) CallStarted(id string) {
+	if id == "" {
+		return
+	}
+
+	runtime.calls.lock.Lock()
+	defer runtime.calls.lock.Unlock()
+	if runtime.calls.inFlight == nil {
+		runtime.calls.inFlight = map[string]int{}
+	}
+	runtime.calls.inFlight[id]++
+	runtime.calls.total++
+}
+
+// CallFinished records a call returned from the driver.
+func (runtime *Runtime) CallFinished(id string) {
+	runtime.calls.lock.Lock()
+	defer runtime.calls.lock.Unlock()
+
+	count, ok := runtime.calls.inFlight[id]
+	if !ok {
+		return
+	}
+	if count > 1 {
+		runtime.calls.inFlight[id] = count - 1
+	} else {
+		delete(runtime.calls.inFlight, id)
+	}
+	runtime.calls.total--
+}
+
+// Busy returns the number of calls in flight in the driver.
+func (runtime *Runtime) Busy() int {
+	runtime.calls.lock.Lock()
+	defer runtime.calls.lock.Unlock()
+
+	return runtime.calls.total
+}
+
+// Drain waits for the calls in flight to return, up to the timeout, and returns the number of the
+// calls still in flight.
+func (runtime *Runtime) Drain(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		busy := runtime.Busy()
+		if busy == 0 || !time.Now().Before(deadline) {
+			return busy
+		}
+		time.Sleep(DrainCheckInterval * time.Millisecond)
+	}
+}
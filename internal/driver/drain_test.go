@@ -0,0 +1,50 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainMeta(t *testing.T) {
+	assert.NoError(t, (&Meta{}).validateDrain())
+	assert.ErrorIs(t, (&Meta{DrainTimeout: "a while"}).validateDrain(), ErrDriverError)
+	assert.Equal(t, DefaultDrainTimeout*time.Second, (&Meta{}).DrainPeriod())
+	assert.Equal(t, 2*time.Minute, (&Meta{DrainTimeout: "2m"}).DrainPeriod())
+}
+
+func TestRuntimeCalls(t *testing.T) {
+	runtime := &Runtime{}
+	runtime.CallStarted("")
+	assert.Equal(t, 0, runtime.Busy(), "calls without id should not be tracked")
+
+	runtime.CallStarted("s1")
+	runtime.CallStarted("s1")
+	runtime.CallStarted("s2")
+	assert.Equal(t, 3, runtime.Busy())
+	runtime.CallFinished("s1")
+	runtime.CallFinished("unknown")
+	assert.Equal(t, 2, runtime.Busy(), "returns of unknown calls should be ignored")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		runtime.CallFinished("s1")
+		runtime.CallFinished("s2")
+	}()
+	assert.Equal(t, 0, runtime.Drain(time.Second), "should wait for the calls to return")
+
+	runtime.CallStarted("s3")
+	start := time.Now()
+	assert.Equal(t, 1, runtime.Drain(50*time.Millisecond), "should give up after the timeout")
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -35,13 +35,24 @@ type Handler interface {
 
 // Runtime contains the runtime information of the running driver.
 type Runtime struct {
-	Data        interface{}
-	DynamicData interface{}
-	Feature     string
-	Handler     Handler
-	Stream      <-chan *dipper.Message
-	Service     string
-	State       int
+	Data         interface{}
+	DynamicData  interface{}
+	Feature      string
+	Handler      Handler
+	Stream       <-chan *dipper.Message
+	Service      string
+	State        int
+	Capabilities *dipper.Capabilities
+	ExitCode     int
+	Instance     int
+
+	health health
+	calls  calls
+}
+
+// exitCoder is implemented by the handlers knowing how the driver exits.
+type exitCoder interface {
+	ExitCode() int
 }
 
 // NewDriver creates a driver object to represent a child process.
@@ -55,12 +66,33 @@ func NewDriver(feature string, metaData map[string]interface{}, driverData inter
 	if meta.Name == "" {
 		panic(fmt.Errorf("%w: driver name missing: %+v", ErrDriverError, meta))
 	}
+	if err := meta.Restart.Validate(); err != nil {
+		panic(err)
+	}
+	if err := meta.Sandbox.Validate(); err != nil {
+		panic(err)
+	}
+	if err := meta.HealthCheck.Validate(); err != nil {
+		panic(err)
+	}
+	if err := meta.validatePool(); err != nil {
+		panic(err)
+	}
+	if err := meta.validateDrain(); err != nil {
+		panic(err)
+	}
 
 	var dh Handler
 
 	switch meta.Type {
 	case "builtin":
 		dh = NewBuiltinDriver(&meta)
+	case "download":
+		dh = NewDownloadDriver(&meta)
+	case "remote":
+		dh = NewRemoteDriver(&meta)
+	case "wasm":
+		dh = NewWasmDriver(&meta)
 	case "null":
 		dh = NewNullDriver(&meta)
 	default:
@@ -90,9 +122,18 @@ func (runtime *Runtime) Start(service string) {
 	runtime.SendOptions()
 }
 
+// Wait waits for the driver to exit and records the exit code.
+func (runtime *Runtime) Wait() {
+	runtime.Handler.Wait()
+	runtime.ExitCode = ExitCodeUnknown
+	if h, ok := runtime.Handler.(exitCoder); ok {
+		runtime.ExitCode = h.ExitCode()
+	}
+}
+
 // SendOptions sends driver options and data to the child process as a dipper message.
 func (runtime *Runtime) SendOptions() {
-	runtime.SendMessage(&dipper.Message{
+	options := &dipper.Message{
 		Channel: "command",
 		Subject: "options",
 		IsRaw:   false,
@@ -100,7 +141,16 @@ func (runtime *Runtime) SendOptions() {
 			"data":        runtime.Data,
 			"dynamicData": runtime.DynamicData,
 		},
-	})
+	}
+	if meta := runtime.Handler.Meta(); meta != nil && meta.Framing != dipper.FramingJSONLines && (meta.Framing != "" || meta.Codec != "") {
+		// ask the driver to switch format, handler waits for the acknowledgement, jsonl is used
+		// from the start without negotiation
+		options.Labels = map[string]string{
+			"framing": meta.Framing,
+			"codec":   meta.Codec,
+		}
+	}
+	runtime.SendMessage(options)
 	runtime.SendMessage(&dipper.Message{
 		Channel: "command",
 		Subject: "start",
@@ -63,9 +63,11 @@ func TestDriverStart(t *testing.T) {
 		"start a driver": []interface{}{ // case msg
 			&Runtime{ // runtime
 				Handler: &BuiltinDriver{
-					meta: &Meta{
-						Executable: "/fakecommand1",
-						Arguments:  []string{},
+					connHandler: connHandler{
+						meta: &Meta{
+							Executable: "/fakecommand1",
+							Arguments:  []string{},
+						},
 					},
 				},
 			},
@@ -10,6 +10,7 @@
 package driver
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"testing"
@@ -32,6 +33,17 @@ func generateFakeExecCommand(fname string) func(string, ...string) *exec.Cmd {
 	return fakeExecCommand
 }
 
+func generateFakeExecCommandContext(fname string) func(context.Context, string, ...string) *exec.Cmd {
+	return func(ctx context.Context, command string, args ...string) *exec.Cmd {
+		cs := []string{"--test.run=" + fname, "--", command}
+		cs = append(cs, args...)
+		cmd := exec.CommandContext(ctx, os.Args[0], cs...)
+		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+
+		return cmd
+	}
+}
+
 func TestExecCommandDummy(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
@@ -0,0 +1,205 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// Defaults of the health check.
+const (
+	// DefaultHealthInterval is the interval in seconds between the pings.
+	DefaultHealthInterval time.Duration = 30
+
+	// DefaultUnhealthyAfter is the number of consecutive missed pings before the driver is unhealthy.
+	DefaultUnhealthyAfter = 2
+
+	// DefaultRestartAfter is the number of consecutive missed pings before the driver is restarted.
+	DefaultRestartAfter = 4
+)
+
+// HealthCheck defines the heartbeat for the driver, configured in daemon.drivers.<name>.healthCheck.
+type HealthCheck struct {
+	// Interval between the pings, "0" disables the heartbeat.
+	Interval       string
+	UnhealthyAfter int
+	RestartAfter   int
+}
+
+// Validate checks the health check config.
+func (h HealthCheck) Validate() error {
+	if h.Interval != "" {
+		if _, err := time.ParseDuration(h.Interval); err != nil {
+			return fmt.Errorf("%w: invalid interval in health check: %w", ErrDriverError, err)
+		}
+	}
+	if h.UnhealthyAfter < 0 || h.RestartAfter < 0 {
+		return fmt.Errorf("%w: negative threshold in health check", ErrDriverError)
+	}
+
+	return nil
+}
+
+// Period returns the interval between the pings, 0 if disabled.
+func (h HealthCheck) Period() time.Duration {
+	return parseDuration(h.Interval, DefaultHealthInterval*time.Second)
+}
+
+// unhealthyAfter returns the number of missed pings before the driver is unhealthy.
+func (h HealthCheck) unhealthyAfter() int {
+	if h.UnhealthyAfter == 0 {
+		return DefaultUnhealthyAfter
+	}
+
+	return h.UnhealthyAfter
+}
+
+// restartAfter returns the number of missed pings before the driver is restarted.
+func (h HealthCheck) restartAfter() int {
+	if h.RestartAfter == 0 {
+		return DefaultRestartAfter
+	}
+
+	return h.RestartAfter
+}
+
+// health tracks the replies to the pings sent to the driver.
+type health struct {
+	lock     sync.Mutex
+	started  bool
+	pending  bool
+	missed   int
+	lastPong time.Time
+}
+
+// killer is implemented by the handlers able to kill the driver process.
+type killer interface {
+	Kill()
+}
+
+// Heartbeat starts pinging the driver periodically until it is no longer alive, the hung function is
+// called when the driver misses too many replies.
+func (runtime *Runtime) Heartbeat(hung func()) {
+	check := runtime.Handler.Meta().HealthCheck
+	period := check.Period()
+	if period <= 0 {
+		return
+	}
+
+	runtime.health.lock.Lock()
+	defer runtime.health.lock.Unlock()
+	if runtime.health.started {
+		return
+	}
+	runtime.health.started = true
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if runtime.State != DriverAlive && runtime.State != DriverReloading {
+				return
+			}
+			if runtime.tick() >= check.restartAfter() {
+				dipper.Logger.Warningf("[%s] driver %s missed %d pings", runtime.Service, runtime.Handler.Meta().Name, check.restartAfter())
+				hung()
+
+				return
+			}
+			if err := runtime.ping(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// tick records a missed ping if the last one is not answered, and returns the consecutive misses.
+func (runtime *Runtime) tick() int {
+	runtime.health.lock.Lock()
+	defer runtime.health.lock.Unlock()
+
+	if runtime.health.pending {
+		runtime.health.missed++
+	}
+	runtime.health.pending = true
+
+	return runtime.health.missed
+}
+
+func (runtime *Runtime) ping() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: unable to ping driver: %v", ErrDriverError, r)
+		}
+	}()
+
+	runtime.SendMessage(&dipper.Message{
+		Channel: "command",
+		Subject: "ping",
+	})
+
+	return nil
+}
+
+// Pong records a reply from the driver.
+func (runtime *Runtime) Pong() {
+	runtime.health.lock.Lock()
+	defer runtime.health.lock.Unlock()
+
+	runtime.health.pending = false
+	runtime.health.missed = 0
+	runtime.health.lastPong = time.Now()
+}
+
+// LegacyPong checks if the driver replies to the pings with its state instead of "state:pong", i.e.
+// the drivers not advertising a protocol version since dipper.PongProtocolVersion.
+func (runtime *Runtime) LegacyPong() bool {
+	return runtime.Capabilities == nil || runtime.Capabilities.ProtocolVersion < dipper.PongProtocolVersion
+}
+
+// Healthy checks if the driver is alive and answering the pings.
+func (runtime *Runtime) Healthy() bool {
+	runtime.health.lock.Lock()
+	defer runtime.health.lock.Unlock()
+
+	alive := runtime.State == DriverAlive || runtime.State == DriverReloading
+
+	return alive && runtime.health.missed < runtime.Handler.Meta().HealthCheck.unhealthyAfter()
+}
+
+// HealthStatus returns the heartbeat status of the driver for reporting.
+func (runtime *Runtime) HealthStatus() map[string]interface{} {
+	healthy := runtime.Healthy()
+
+	runtime.health.lock.Lock()
+	defer runtime.health.lock.Unlock()
+
+	ret := map[string]interface{}{
+		"healthy":     healthy,
+		"missedPings": runtime.health.missed,
+	}
+	if !runtime.health.lastPong.IsZero() {
+		ret["lastPong"] = runtime.health.lastPong.Format(time.RFC3339)
+	}
+
+	return ret
+}
+
+// Kill stops a hung driver, the process is killed if possible, otherwise the connection is closed.
+func (runtime *Runtime) Kill() {
+	if h, ok := runtime.Handler.(killer); ok {
+		h.Kill()
+
+		return
+	}
+	runtime.Handler.Close()
+}
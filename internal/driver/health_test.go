@@ -0,0 +1,77 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckConfig(t *testing.T) {
+	assert.NoError(t, HealthCheck{}.Validate())
+	assert.ErrorIs(t, HealthCheck{Interval: "often"}.Validate(), ErrDriverError)
+	assert.ErrorIs(t, HealthCheck{RestartAfter: -1}.Validate(), ErrDriverError)
+
+	assert.Equal(t, DefaultHealthInterval*time.Second, HealthCheck{}.Period())
+	assert.Equal(t, time.Duration(0), HealthCheck{Interval: "0"}.Period(), "0 should disable the heartbeat")
+	assert.Equal(t, DefaultRestartAfter, HealthCheck{}.restartAfter())
+}
+
+func TestRuntimeHeartbeat(t *testing.T) {
+	pings := make(chan *dipper.Message, 10)
+	h := NewNullDriver(&Meta{Name: "test", HealthCheck: HealthCheck{Interval: "10ms", RestartAfter: 3}})
+	h.SendMessageFunc = func(msg *dipper.Message) {
+		pings <- msg
+	}
+	runtime := &Runtime{Feature: "driver:test", Handler: h, State: DriverAlive}
+
+	hung := make(chan struct{})
+	runtime.Heartbeat(func() { close(hung) })
+	runtime.Heartbeat(func() { assert.Fail(t, "heartbeat should only start once") })
+
+	msg := <-pings
+	assert.Equal(t, "command", msg.Channel)
+	assert.Equal(t, "ping", msg.Subject)
+	runtime.Pong()
+	assert.True(t, runtime.Healthy(), "driver answering pings should be healthy")
+
+	select {
+	case <-hung:
+	case <-time.After(time.Second):
+		assert.Fail(t, "driver not answering pings should be treated as hung")
+	}
+	assert.False(t, runtime.Healthy(), "driver missing pings should be unhealthy")
+	assert.Equal(t, 3, runtime.HealthStatus()["missedPings"])
+	assert.Contains(t, runtime.HealthStatus(), "lastPong")
+}
+
+func TestRuntimeHeartbeatStops(t *testing.T) {
+	h := NewNullDriver(&Meta{Name: "test", HealthCheck: HealthCheck{Interval: "10ms", RestartAfter: 1}})
+	runtime := &Runtime{Feature: "driver:test", Handler: h, State: DriverFailed}
+	runtime.Heartbeat(func() { assert.Fail(t, "heartbeat should stop for failed driver") })
+	time.Sleep(50 * time.Millisecond)
+
+	closed := false
+	h.CloseFunc = func() { closed = true }
+	runtime.Kill()
+	assert.True(t, closed, "kill should close the handler without a process")
+}
+
+func TestRuntimeLegacyPong(t *testing.T) {
+	runtime := &Runtime{}
+	assert.True(t, runtime.LegacyPong(), "drivers without capabilities reply to pings with their state")
+	runtime.Capabilities = &dipper.Capabilities{ProtocolVersion: dipper.PongProtocolVersion - 1}
+	assert.True(t, runtime.LegacyPong())
+	runtime.Capabilities = &dipper.Capabilities{ProtocolVersion: dipper.PongProtocolVersion}
+	assert.False(t, runtime.LegacyPong(), "newer drivers reply to pings with state:pong")
+}
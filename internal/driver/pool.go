@@ -0,0 +1,101 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// Balancing strategies for the calls to the instances in a driver pool.
+const (
+	// BalanceRoundRobin sends the calls to the instances in turn.
+	BalanceRoundRobin = "round-robin"
+	// BalanceLeastBusy sends the calls to the instance with the fewest calls in flight.
+	BalanceLeastBusy = "least-busy"
+)
+
+// InstanceKey returns the key of an instance of the driver for the feature, the first instance is
+// keyed by the feature itself.
+func InstanceKey(feature string, instance int) string {
+	if instance == 0 {
+		return feature
+	}
+
+	return feature + "#" + strconv.Itoa(instance)
+}
+
+// PoolSize returns the number of the instances to run for the driver.
+func (m *Meta) PoolSize() int {
+	if m.Instances < 1 {
+		return 1
+	}
+
+	return m.Instances
+}
+
+// validatePool checks the pool settings in the meta.
+func (m *Meta) validatePool() error {
+	if m.Instances < 0 {
+		return fmt.Errorf("%w: negative instances for driver: %s", ErrDriverError, m.Name)
+	}
+	switch m.Balance {
+	case "", BalanceRoundRobin, BalanceLeastBusy:
+	default:
+		return fmt.Errorf("%w: unknown balance %s for driver: %s", ErrDriverError, m.Balance, m.Name)
+	}
+
+	return nil
+}
+
+// Key returns the key of the runtime in the service.
+func (runtime *Runtime) Key() string {
+	return InstanceKey(runtime.Feature, runtime.Instance)
+}
+
+// Name returns the name of the driver instance, used for expecting messages from the instance.
+func (runtime *Runtime) Name() string {
+	return InstanceKey(runtime.Handler.Meta().Name, runtime.Instance)
+}
+
+// Pool balances the calls across the instances of a driver.
+type Pool struct {
+	next atomic.Uint64
+}
+
+// Pick chooses an alive instance for the call with the id, and records the call in the instance. If
+// none of the instances is alive, the first instance is used.
+func (p *Pool) Pick(instances []*Runtime, id string) *Runtime {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	alive := make([]*Runtime, 0, len(instances))
+	for _, runtime := range instances {
+		if runtime.State == DriverAlive {
+			alive = append(alive, runtime)
+		}
+	}
+
+	chosen := instances[0]
+	switch {
+	case len(alive) == 0:
+	case instances[0].Handler.Meta().Balance == BalanceLeastBusy:
+		chosen = alive[0]
+		for _, runtime := range alive[1:] {
+			if runtime.Busy() < chosen.Busy() {
+				chosen = runtime
+			}
+		}
+	default:
+		chosen = alive[(p.next.Add(1)-1)%uint64(len(alive))]
+	}
+	chosen.CallStarted(id)
+
+	return chosen
+}
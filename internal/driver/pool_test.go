@@ -0,0 +1,64 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolMeta(t *testing.T) {
+	assert.Equal(t, "driver:web", InstanceKey("driver:web", 0))
+	assert.Equal(t, "driver:web#2", InstanceKey("driver:web", 2))
+	assert.Equal(t, 1, (&Meta{}).PoolSize())
+	assert.Equal(t, 3, (&Meta{Instances: 3}).PoolSize())
+
+	assert.NoError(t, (&Meta{Instances: 2, Balance: BalanceLeastBusy}).validatePool())
+	assert.ErrorIs(t, (&Meta{Instances: -1}).validatePool(), ErrDriverError)
+	assert.ErrorIs(t, (&Meta{Balance: "random"}).validatePool(), ErrDriverError)
+
+	assert.Panics(t, func() {
+		NewDriver("driver:web", map[string]interface{}{"name": "web", "type": "null", "balance": "random"}, nil, nil)
+	}, "should reject unknown balance")
+}
+
+func TestPoolPick(t *testing.T) {
+	meta := &Meta{Name: "web", Instances: 3}
+	instances := []*Runtime{}
+	for i := 0; i < 3; i++ {
+		instances = append(instances, &Runtime{Feature: "driver:web", Instance: i, Handler: NewNullDriver(meta), State: DriverAlive})
+	}
+	assert.Equal(t, "web#1", instances[1].Name())
+	assert.Equal(t, "driver:web#1", instances[1].Key())
+
+	pool := &Pool{}
+	picked := []int{}
+	for i := 0; i < 4; i++ {
+		picked = append(picked, pool.Pick(instances, "session"+strconv.Itoa(i)).Instance)
+	}
+	assert.Equal(t, []int{0, 1, 2, 0}, picked, "should pick instances in turn")
+	assert.Equal(t, 2, instances[0].Busy())
+
+	instances[1].State = DriverFailed
+	assert.NotEqual(t, 1, pool.Pick(instances, "a").Instance, "should skip failed instance")
+	assert.NotEqual(t, 1, pool.Pick(instances, "b").Instance, "should skip failed instance")
+
+	meta.Balance = BalanceLeastBusy
+	instances[1].State = DriverAlive
+	assert.Equal(t, 1, pool.Pick(instances, "c").Instance, "should pick the least busy instance")
+
+	for _, runtime := range instances {
+		runtime.State = DriverFailed
+	}
+	assert.Equal(t, 0, pool.Pick(instances, "").Instance, "should fall back to the first instance")
+	assert.Nil(t, pool.Pick(nil, ""))
+}
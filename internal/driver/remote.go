@@ -0,0 +1,112 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// RemoteDialTimeout is the default timeout in seconds for connecting to a remote driver.
+const RemoteDialTimeout time.Duration = 10
+
+// RemoteDriver connects to a driver running outside of the daemon, e.g. in another pod, listening on a
+// unix socket or tcp with mutual tls.  Closing the handler only disconnects, the driver keeps running.
+type RemoteDriver struct {
+	connHandler
+
+	network     string
+	address     string
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	done        chan struct{}
+}
+
+// NewRemoteDriver creates a handler for the remote driver specified in the meta info.
+func NewRemoteDriver(m *Meta) *RemoteDriver {
+	return &RemoteDriver{connHandler: connHandler{meta: m}}
+}
+
+// Acquire function validates the address and loads the certificates for connecting to the driver.
+func (d *RemoteDriver) Acquire() {
+	addr, ok := d.meta.HandlerData["address"].(string)
+	if !ok || addr == "" {
+		panic(fmt.Errorf("%w: address is missing for remote driver: %s", ErrDriverError, d.meta.Name))
+	}
+	network, address, err := dipper.ParseAddress(addr)
+	if err != nil {
+		panic(fmt.Errorf("%w: remote driver %s: %w", ErrDriverError, d.meta.Name, err))
+	}
+	d.network, d.address = network, address
+
+	d.dialTimeout = RemoteDialTimeout * time.Second
+	if timeout, ok := dipper.GetMapDataStr(d.meta.HandlerData, "dialTimeout"); ok {
+		d.dialTimeout = dipper.Must(time.ParseDuration(timeout)).(time.Duration)
+	}
+
+	tlsData, ok := dipper.GetMapData(d.meta.HandlerData, "tls")
+	if !ok {
+		if network == "tcp" && !dipper.CheckMapData(d.meta.HandlerData, "insecure") {
+			panic(fmt.Errorf("%w: tls is required for remote driver over tcp: %s", ErrDriverError, d.meta.Name))
+		}
+
+		return
+	}
+
+	ca, _ := dipper.GetMapDataStr(tlsData, "ca")
+	cert, _ := dipper.GetMapDataStr(tlsData, "cert")
+	key, _ := dipper.GetMapDataStr(tlsData, "key")
+	if ca == "" || cert == "" || key == "" {
+		panic(fmt.Errorf("%w: ca, cert and key are required for mutual tls in remote driver: %s", ErrDriverError, d.meta.Name))
+	}
+	tlsConfig, err := dipper.LoadTLSConfig(ca, cert, key)
+	if err != nil {
+		panic(fmt.Errorf("%w: remote driver %s: %w", ErrDriverError, d.meta.Name, err))
+	}
+	tlsConfig.ServerName, _ = dipper.GetMapDataStr(tlsData, "serverName")
+	d.tlsConfig = tlsConfig
+}
+
+// Prepare function is used for preparing the stream for receiving messages from the driver.
+func (d *RemoteDriver) Prepare(stream chan<- *dipper.Message) {
+	d.stream = stream
+}
+
+// Start connects to the remote driver.  The "service" indicates which service this driver belongs to.
+func (d *RemoteDriver) Start(service string) {
+	d.formatAck = make(chan struct{}, 1)
+
+	dialer := &net.Dialer{Timeout: d.dialTimeout}
+	var c net.Conn
+	var err error
+	if d.tlsConfig != nil {
+		c, err = tls.DialWithDialer(dialer, d.network, d.address, d.tlsConfig)
+	} else {
+		c, err = dialer.Dial(d.network, d.address)
+	}
+	if err != nil {
+		dipper.Logger.Panicf("[%s] Failed to connect to remote driver %s: %v", service, d.meta.Name, err)
+	}
+
+	d.conn = d.newConn(c, c)
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		d.fetchMessages(service, d.conn)
+	}()
+}
+
+// Wait waits for the connection to the remote driver to close.
+func (d *RemoteDriver) Wait() {
+	if d.done != nil {
+		<-d.done
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteAcquire(t *testing.T) {
+	testCases := map[string]struct {
+		handlerData map[string]interface{}
+		fails       bool
+	}{
+		"missing address":         {map[string]interface{}{}, true},
+		"invalid address":         {map[string]interface{}{"address": "driver:9000"}, true},
+		"tcp without tls":         {map[string]interface{}{"address": "tcp://driver:9000"}, true},
+		"tcp with incomplete tls": {map[string]interface{}{"address": "tcp://driver:9000", "tls": map[string]interface{}{"ca": "ca.crt"}}, true},
+		"tcp marked insecure":     {map[string]interface{}{"address": "tcp://driver:9000", "insecure": true}, false},
+		"unix socket":             {map[string]interface{}{"address": "unix:///var/run/driver.sock"}, false},
+	}
+
+	for msg, tc := range testCases {
+		d := NewRemoteDriver(&Meta{Name: "test", HandlerData: tc.handlerData})
+		if tc.fails {
+			assert.Panics(t, d.Acquire, "should panic with %s", msg)
+		} else {
+			assert.NotPanics(t, d.Acquire, "should accept %s", msg)
+		}
+	}
+}
+
+func TestRemoteDriverUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "driver.sock")
+	l, err := dipper.Listen("unix", sock, nil)
+	assert.NoError(t, err, "should listen on unix socket")
+	defer l.Close()
+
+	testRemoteDriver(t, l, map[string]interface{}{"address": "unix://" + sock})
+}
+
+func TestRemoteDriverMutualTLS(t *testing.T) {
+	ca, cert, key := generateTestCertificate(t)
+	serverConfig, err := dipper.LoadTLSConfig(ca, cert, key)
+	assert.NoError(t, err, "should load certificates")
+	serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	l, err := dipper.Listen("tcp", "127.0.0.1:0", serverConfig)
+	assert.NoError(t, err, "should listen on tcp")
+	defer l.Close()
+
+	testRemoteDriver(t, l, map[string]interface{}{
+		"address": "tcp://" + l.Addr().String(),
+		"tls": map[string]interface{}{
+			"ca":         ca,
+			"cert":       cert,
+			"key":        key,
+			"serverName": "localhost",
+		},
+	})
+}
+
+func testRemoteDriver(t *testing.T, l net.Listener, handlerData map[string]interface{}) {
+	t.Helper()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if tc, ok := c.(*tls.Conn); ok && err == nil {
+			err = tc.Handshake()
+		}
+		if err == nil {
+			accepted <- c
+		}
+		close(accepted)
+	}()
+
+	stream := make(chan *dipper.Message, 1)
+	d := NewRemoteDriver(&Meta{Name: "test", HandlerData: handlerData})
+	d.Acquire()
+	d.Prepare(stream)
+	d.Start("operator")
+
+	c := <-accepted
+	assert.NotNil(t, c, "driver should accept the connection")
+	defer c.Close()
+	conn := dipper.NewConn(c, c)
+
+	d.SendMessage(&dipper.Message{Channel: "command", Subject: "start"})
+	msg := conn.FetchRawMessage()
+	assert.Equal(t, "start", msg.Subject, "driver should receive messages from daemon")
+
+	conn.SendMessage(&dipper.Message{Channel: dipper.ChannelState, Subject: "alive"})
+	msg = <-stream
+	assert.Equal(t, "alive", msg.Subject, "daemon should receive messages from driver")
+
+	d.Close()
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		d.Wait()
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		assert.Fail(t, "should stop waiting after disconnected")
+	}
+}
+
+// generateTestCertificate creates a self-signed certificate used as both the CA and the
+// certificate of the server and the client.
+func generateTestCertificate(t *testing.T) (string, string, string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "tls.crt")
+	key := filepath.Join(dir, "tls.key")
+	assert.NoError(t, os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return cert, cert, key
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Restart policies for crashed drivers.
+const (
+	// RestartAlways restarts the driver whenever it exits.
+	RestartAlways = "always"
+	// RestartOnFailure restarts the driver only when it exits with an error.
+	RestartOnFailure = "on-failure"
+	// RestartNever leaves the driver failed once it exits.
+	RestartNever = "never"
+
+	// ExitCodeUnknown is used when the exit code of a driver is not available, e.g. a remote driver disconnected.
+	ExitCodeUnknown = -1
+)
+
+// Defaults of the restart policy.
+const (
+	// DefaultMaxRestarts is the number of restarts allowed within the reset window.
+	DefaultMaxRestarts = 3
+
+	// DefaultRestartBackoff is the delay in seconds before the first restart, doubled for each following restart.
+	DefaultRestartBackoff time.Duration = 1
+
+	// DefaultRestartMaxBackoff is the maximum delay in seconds between restarts.
+	DefaultRestartMaxBackoff time.Duration = 30
+
+	// DefaultRestartResetWindow is the time in minutes a driver needs to stay up for the restart count to reset.
+	DefaultRestartResetWindow time.Duration = 10
+)
+
+// RestartPolicy defines how a crashed driver is restarted, configured in daemon.drivers.<name>.restart.
+type RestartPolicy struct {
+	Policy      string
+	MaxRestarts int
+	Backoff     string
+	MaxBackoff  string
+	ResetWindow string
+}
+
+// Validate checks the policy name and the durations in the restart policy.
+func (p RestartPolicy) Validate() error {
+	switch p.Policy {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("%w: unknown restart policy: %s", ErrDriverError, p.Policy)
+	}
+	for _, d := range []string{p.Backoff, p.MaxBackoff, p.ResetWindow} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("%w: invalid duration in restart policy: %w", ErrDriverError, err)
+		}
+	}
+	if p.MaxRestarts < 0 {
+		return fmt.Errorf("%w: negative maxRestarts in restart policy", ErrDriverError)
+	}
+
+	return nil
+}
+
+// ShouldRestart checks if the driver exited with the exit code should be restarted.
+func (p RestartPolicy) ShouldRestart(exitCode int) bool {
+	switch p.Policy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+// Limit returns the number of restarts allowed within the reset window.
+func (p RestartPolicy) Limit() int {
+	if p.MaxRestarts == 0 {
+		return DefaultMaxRestarts
+	}
+
+	return p.MaxRestarts
+}
+
+// Delay returns the exponential backoff before the nth restart in the reset window.
+func (p RestartPolicy) Delay(n int) time.Duration {
+	delay := parseDuration(p.Backoff, DefaultRestartBackoff*time.Second)
+	maxDelay := parseDuration(p.MaxBackoff, DefaultRestartMaxBackoff*time.Second)
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+
+	return delay
+}
+
+// Window returns the time a driver needs to stay up for the restart count to reset.
+func (p RestartPolicy) Window() time.Duration {
+	return parseDuration(p.ResetWindow, DefaultRestartResetWindow*time.Minute)
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+
+	return def
+}
+
+// RestartStatus tracks the restarts of the driver for a feature across the runtimes replacing each other.
+type RestartStatus struct {
+	Restarts      int
+	TotalRestarts int
+	LastExitCode  int
+	LastExit      time.Time
+	LastRestart   time.Time
+	GaveUp        bool
+
+	lock sync.Mutex
+}
+
+// Exited records the exit code of the driver.
+func (st *RestartStatus) Exited(exitCode int, now time.Time) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.LastExitCode = exitCode
+	st.LastExit = now
+}
+
+// Next records a restart attempt and returns the delay before restarting, or false if the driver
+// has been restarted too many times within the reset window.
+func (st *RestartStatus) Next(p RestartPolicy, now time.Time) (time.Duration, bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if !st.LastRestart.IsZero() && now.Sub(st.LastRestart) > p.Window() {
+		st.Restarts = 0
+	}
+	if st.Restarts >= p.Limit() {
+		st.GaveUp = true
+
+		return 0, false
+	}
+
+	st.Restarts++
+	st.TotalRestarts++
+	st.LastRestart = now
+	st.GaveUp = false
+
+	return p.Delay(st.Restarts), true
+}
+
+// Snapshot returns the status as a map for reporting.
+func (st *RestartStatus) Snapshot() map[string]interface{} {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	ret := map[string]interface{}{
+		"restarts":      st.Restarts,
+		"totalRestarts": st.TotalRestarts,
+		"lastExitCode":  st.LastExitCode,
+		"gaveUp":        st.GaveUp,
+	}
+	if !st.LastExit.IsZero() {
+		ret["lastExit"] = st.LastExit.Format(time.RFC3339)
+	}
+	if !st.LastRestart.IsZero() {
+		ret["lastRestart"] = st.LastRestart.Format(time.RFC3339)
+	}
+
+	return ret
+}
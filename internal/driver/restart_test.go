@@ -0,0 +1,74 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartPolicyValidate(t *testing.T) {
+	assert.NoError(t, RestartPolicy{}.Validate(), "empty policy should be valid")
+	assert.NoError(t, RestartPolicy{Policy: RestartOnFailure, Backoff: "2s", MaxBackoff: "1m", ResetWindow: "5m"}.Validate())
+	assert.ErrorIs(t, RestartPolicy{Policy: "sometimes"}.Validate(), ErrDriverError, "unknown policy should be rejected")
+	assert.ErrorIs(t, RestartPolicy{Backoff: "soon"}.Validate(), ErrDriverError, "invalid duration should be rejected")
+	assert.ErrorIs(t, RestartPolicy{MaxRestarts: -1}.Validate(), ErrDriverError, "negative maxRestarts should be rejected")
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	assert.True(t, RestartPolicy{}.ShouldRestart(0), "default policy should restart on clean exit")
+	assert.True(t, RestartPolicy{Policy: RestartAlways}.ShouldRestart(1))
+	assert.False(t, RestartPolicy{Policy: RestartOnFailure}.ShouldRestart(0), "on-failure should not restart on clean exit")
+	assert.True(t, RestartPolicy{Policy: RestartOnFailure}.ShouldRestart(2))
+	assert.True(t, RestartPolicy{Policy: RestartOnFailure}.ShouldRestart(ExitCodeUnknown))
+	assert.False(t, RestartPolicy{Policy: RestartNever}.ShouldRestart(1))
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	p := RestartPolicy{Backoff: "1s", MaxBackoff: "5s"}
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, 2*time.Second, p.Delay(2))
+	assert.Equal(t, 4*time.Second, p.Delay(3))
+	assert.Equal(t, 5*time.Second, p.Delay(4), "delay should be capped at maxBackoff")
+	assert.Equal(t, 5*time.Second, p.Delay(100))
+
+	assert.Equal(t, DefaultRestartBackoff*time.Second, RestartPolicy{}.Delay(1))
+	assert.Equal(t, DefaultRestartResetWindow*time.Minute, RestartPolicy{}.Window())
+}
+
+func TestRestartStatusNext(t *testing.T) {
+	p := RestartPolicy{MaxRestarts: 2, Backoff: "1s", ResetWindow: "1m"}
+	st := &RestartStatus{}
+	now := time.Now()
+
+	st.Exited(3, now)
+	delay, ok := st.Next(p, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+	delay, ok = st.Next(p, now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, delay)
+
+	_, ok = st.Next(p, now.Add(2*time.Second))
+	assert.False(t, ok, "should give up after maxRestarts within the reset window")
+	assert.True(t, st.GaveUp)
+
+	delay, ok = st.Next(p, now.Add(2*time.Minute))
+	assert.True(t, ok, "should reset the count after the reset window")
+	assert.Equal(t, time.Second, delay)
+	assert.False(t, st.GaveUp)
+
+	snapshot := st.Snapshot()
+	assert.Equal(t, 1, snapshot["restarts"])
+	assert.Equal(t, 3, snapshot["totalRestarts"])
+	assert.Equal(t, 3, snapshot["lastExitCode"])
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SandboxCommand is the first argument for the daemon executable to run as the sandbox shim, which
+	// applies the resource limits and the seccomp filter before executing the driver.
+	SandboxCommand = "sandbox-exec"
+
+	// SandboxEnv is the environment variable used for passing the sandbox config to the shim.
+	SandboxEnv = "HONEYDIPPER_SANDBOX"
+)
+
+// Namespaces that can be unshared for the driver processes.
+var sandboxNamespaces = []string{"ipc", "mount", "net", "pid", "user", "uts"}
+
+// Limits are the resource limits of the driver process.
+type Limits struct {
+	// CPU is the cpu time in seconds.
+	CPU int
+	// Memory is the size of the address space, in bytes or with a K, M, G, Ki, Mi or Gi suffix.
+	Memory string
+	// OpenFiles is the maximum number of open file descriptors.
+	OpenFiles int
+}
+
+// Sandbox isolates a builtin driver process from the daemon, configured in daemon.drivers.<name>.sandbox.
+type Sandbox struct {
+	// Env is the allow-list of the environment variables passed to the driver, a trailing * matches
+	// a prefix. All the variables are passed if not specified.
+	Env []string
+	// WorkDir is the working directory of the driver.
+	WorkDir string
+	// User and Group are the names or the ids to run the driver as.
+	User  string
+	Group string
+	// Limits are the resource limits.
+	Limits Limits
+	// Namespaces are the linux namespaces to unshare.
+	Namespaces []string
+	// Seccomp blocks the privileged syscalls, such as ptrace, mount and loading kernel modules.
+	Seccomp bool
+}
+
+// Validate checks the sandbox config.
+func (s Sandbox) Validate() error {
+	if s.Limits.CPU < 0 || s.Limits.OpenFiles < 0 {
+		return fmt.Errorf("%w: negative resource limit in sandbox", ErrDriverError)
+	}
+	if _, err := parseSize(s.Limits.Memory); err != nil {
+		return fmt.Errorf("%w: invalid memory limit in sandbox: %w", ErrDriverError, err)
+	}
+
+	userNS := false
+	for _, ns := range s.Namespaces {
+		if !slices.Contains(sandboxNamespaces, ns) {
+			return fmt.Errorf("%w: unknown namespace in sandbox: %s", ErrDriverError, ns)
+		}
+		userNS = userNS || ns == "user"
+	}
+	if userNS && (s.User != "" || s.Group != "") {
+		return fmt.Errorf("%w: user namespace can not be combined with user or group in sandbox", ErrDriverError)
+	}
+
+	return nil
+}
+
+// IsEmpty checks if the driver runs without a sandbox.
+func (s Sandbox) IsEmpty() bool {
+	return s.Env == nil && s.WorkDir == "" && s.User == "" && s.Group == "" &&
+		len(s.Namespaces) == 0 && !s.needsShim()
+}
+
+// linuxOnly checks if the sandbox uses the settings only supported on linux, i.e. the resource limits,
+// the credential, the namespaces and the seccomp filter.
+func (s Sandbox) linuxOnly() bool {
+	return s.User != "" || s.Group != "" || len(s.Namespaces) > 0 || s.needsShim()
+}
+
+// needsShim checks if the sandbox has to be applied from inside the driver process.
+func (s Sandbox) needsShim() bool {
+	return s.Limits != Limits{} || s.Seccomp
+}
+
+// Command creates the command for running the driver executable in the sandbox.
+func (s Sandbox) Command(executable string, args ...string) (*exec.Cmd, error) {
+	if s.IsEmpty() {
+		return execCommand(executable, args...), nil
+	}
+
+	var cmd *exec.Cmd
+	env := filterEnv(os.Environ(), s.Env)
+	if s.needsShim() {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to locate the daemon executable for sandbox: %w", ErrDriverError, err)
+		}
+		cfg, err := json.Marshal(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to encode sandbox config: %w", ErrDriverError, err)
+		}
+		cmd = execCommand(self, append([]string{SandboxCommand, executable}, args...)...)
+		env = append(env, SandboxEnv+"="+string(cfg))
+	} else {
+		cmd = execCommand(executable, args...)
+	}
+	cmd.Env = env
+	cmd.Dir = s.WorkDir
+
+	if err := s.apply(cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// filterEnv returns the environment variables in the allow-list.
+func filterEnv(environ []string, allowed []string) []string {
+	if allowed == nil {
+		return append([]string{}, environ...)
+	}
+
+	ret := []string{}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range allowed {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); (ok && strings.HasPrefix(name, prefix)) || name == pattern {
+				ret = append(ret, kv)
+
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+// parseSize parses a size in bytes, with an optional K, M, G, Ki, Mi or Gi suffix.
+func parseSize(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		factor uint64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	}
+	factor := uint64(1)
+	num := s
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			num, factor = n, u.factor
+
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n * factor, nil
+}
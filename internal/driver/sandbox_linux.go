@@ -0,0 +1,201 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build linux
+// +build linux
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var namespaceFlags = map[string]uintptr{
+	"ipc":   syscall.CLONE_NEWIPC,
+	"mount": syscall.CLONE_NEWNS,
+	"net":   syscall.CLONE_NEWNET,
+	"pid":   syscall.CLONE_NEWPID,
+	"user":  syscall.CLONE_NEWUSER,
+	"uts":   syscall.CLONE_NEWUTS,
+}
+
+// seccompMaxSyscall is the largest syscall number allowed by the seccomp filter, larger numbers are
+// from other ABIs, e.g. x32 on amd64.
+const seccompMaxSyscall = 1023
+
+// apply sets up the credential and the namespaces for the command.
+func (s Sandbox) apply(cmd *exec.Cmd) error {
+	attr := &syscall.SysProcAttr{}
+
+	for _, ns := range s.Namespaces {
+		attr.Cloneflags |= namespaceFlags[ns]
+		if ns == "user" {
+			attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+			attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		}
+	}
+
+	if s.User != "" || s.Group != "" {
+		cred, err := s.credential()
+		if err != nil {
+			return err
+		}
+		attr.Credential = cred
+	}
+
+	cmd.SysProcAttr = attr
+
+	return nil
+}
+
+// credential resolves the user and the group to run the driver as.
+func (s Sandbox) credential() (*syscall.Credential, error) {
+	uid, gid := os.Getuid(), os.Getgid()
+
+	if s.User != "" {
+		u, err := user.Lookup(s.User)
+		if err != nil {
+			u, err = user.LookupId(s.User)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown user in sandbox: %s", ErrDriverError, s.User)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	if s.Group != "" {
+		g, err := user.LookupGroup(s.Group)
+		if err != nil {
+			g, err = user.LookupGroupId(s.Group)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown group in sandbox: %s", ErrDriverError, s.Group)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	//nolint:gosec
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}, nil
+}
+
+// RunSandbox is the entry point of the sandbox shim. It applies the resource limits and the seccomp
+// filter passed in the environment, then replaces itself with the driver executable in args.
+func RunSandbox(args []string) {
+	if err := runSandbox(args); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runSandbox(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing driver executable", ErrDriverError)
+	}
+
+	var s Sandbox
+	if err := json.Unmarshal([]byte(os.Getenv(SandboxEnv)), &s); err != nil {
+		return fmt.Errorf("%w: invalid sandbox config: %w", ErrDriverError, err)
+	}
+	os.Unsetenv(SandboxEnv)
+
+	if err := setLimits(s.Limits); err != nil {
+		return err
+	}
+
+	// seccomp filter and no_new_privs only apply to the calling thread, which executes the driver.
+	runtime.LockOSThread()
+	if s.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return err
+		}
+	}
+
+	//nolint:gosec
+	return syscall.Exec(args[0], args, os.Environ())
+}
+
+func setLimits(l Limits) error {
+	memory, err := parseSize(l.Memory)
+	if err != nil {
+		return fmt.Errorf("%w: invalid memory limit: %w", ErrDriverError, err)
+	}
+
+	for resource, limit := range map[int]uint64{
+		syscall.RLIMIT_CPU:    uint64(l.CPU), //nolint:gosec
+		syscall.RLIMIT_AS:     memory,
+		syscall.RLIMIT_NOFILE: uint64(l.OpenFiles), //nolint:gosec
+	} {
+		if limit == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("%w: unable to set resource limit %d: %w", ErrDriverError, resource, err)
+		}
+	}
+
+	return nil
+}
+
+// seccompFilter builds a BPF program that denies the syscalls with EPERM, and kills the process
+// on syscalls from a foreign architecture.
+func seccompFilter(arch uint32, maxSyscall uint32, denied []uint32) []unix.SockFilter {
+	const (
+		offsetNr   = 0
+		offsetArch = 4
+	)
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+		jump(unix.BPF_JMP|unix.BPF_JGT|unix.BPF_K, maxSyscall, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+	}
+	for i, nr := range denied {
+		//nolint:gosec
+		prog = append(prog, jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, uint8(len(denied)-i), 0))
+	}
+
+	return append(prog,
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+	)
+}
+
+func installSeccomp() error {
+	if seccompArch == 0 {
+		return fmt.Errorf("%w: seccomp is not supported on %s", ErrDriverError, runtime.GOARCH)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("%w: unable to set no_new_privs: %w", ErrDriverError, err)
+	}
+
+	filter := seccompFilter(seccompArch, seccompMaxSyscall, seccompDenied)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]} //nolint:gosec
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("%w: unable to install seccomp filter: %w", ErrDriverError, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration && linux
+// +build !integration,linux
+
+package driver
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestSandboxApply(t *testing.T) {
+	execCommand = exec.Command
+
+	cmd, err := Sandbox{Namespaces: []string{"pid", "uts"}, User: "0", Group: "0"}.Command("/bin/driver", "operator")
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(syscall.CLONE_NEWPID|syscall.CLONE_NEWUTS), cmd.SysProcAttr.Cloneflags)
+	assert.Equal(t, &syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{}}, cmd.SysProcAttr.Credential)
+
+	cmd, err = Sandbox{Namespaces: []string{"user"}}.Command("/bin/driver", "operator")
+	assert.NoError(t, err)
+	assert.Len(t, cmd.SysProcAttr.UidMappings, 1, "should map the daemon user in user namespace")
+
+	_, err = Sandbox{User: "no-such-user-for-honeydipper"}.Command("/bin/driver", "operator")
+	assert.ErrorIs(t, err, ErrDriverError)
+}
+
+func TestSandboxSeccompFilter(t *testing.T) {
+	filter := seccompFilter(0xc000003e, seccompMaxSyscall, []uint32{1, 2})
+	assert.Len(t, filter, 10)
+	assert.Equal(t, uint32(0xc000003e), filter[1].K, "should check the architecture")
+	assert.Equal(t, uint8(2), filter[6].Jt, "first denied syscall should jump to errno")
+	assert.Equal(t, uint8(1), filter[7].Jt, "last denied syscall should jump to errno")
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), filter[8].K)
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)), filter[9].K)
+}
+
+func TestSandboxShim(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell available")
+	}
+
+	// use the test binary as the shim
+	cmd := generateFakeExecCommand("TestSandboxShimHelper")("/bin/sh", "-c", "ulimit -n; grep ^Seccomp: /proc/self/status")
+	cmd.Env = append(cmd.Env, SandboxEnv+`={"Limits":{"OpenFiles":42},"Seccomp":true}`)
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	assert.Equal(t, "42", lines[0], "should apply the open files limit")
+	if seccompArch != 0 {
+		assert.Equal(t, "Seccomp:\t2", lines[len(lines)-1], "should run driver with seccomp filter")
+	}
+}
+
+func TestSandboxShimHelper(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			RunSandbox(os.Args[i+1:])
+		}
+	}
+	os.Exit(2)
+}
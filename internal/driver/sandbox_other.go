@@ -0,0 +1,33 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !linux
+// +build !linux
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// apply fails if the sandbox uses the settings only supported on linux, the environment and the
+// working directory are set up by Command on all platforms.
+func (s Sandbox) apply(_ *exec.Cmd) error {
+	if s.linuxOnly() {
+		return fmt.Errorf("%w: sandbox limits, user, group, namespaces and seccomp are not supported on %s", ErrDriverError, runtime.GOOS)
+	}
+
+	return nil
+}
+
+// RunSandbox is the entry point of the sandbox shim, not supported on this platform.
+func RunSandbox(_ []string) {
+	fmt.Fprintf(os.Stderr, "sandbox: not supported on %s\n", runtime.GOOS)
+	os.Exit(1)
+}
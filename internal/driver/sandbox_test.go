@@ -0,0 +1,75 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxValidate(t *testing.T) {
+	assert.NoError(t, Sandbox{}.Validate(), "empty sandbox should be valid")
+	assert.NoError(t, Sandbox{Limits: Limits{CPU: 10, Memory: "512Mi", OpenFiles: 64}, Namespaces: []string{"pid", "ipc"}}.Validate())
+	assert.ErrorIs(t, Sandbox{Limits: Limits{Memory: "lots"}}.Validate(), ErrDriverError, "invalid memory should be rejected")
+	assert.ErrorIs(t, Sandbox{Limits: Limits{OpenFiles: -1}}.Validate(), ErrDriverError, "negative limit should be rejected")
+	assert.ErrorIs(t, Sandbox{Namespaces: []string{"cgroup2"}}.Validate(), ErrDriverError, "unknown namespace should be rejected")
+	assert.ErrorIs(t, Sandbox{Namespaces: []string{"user"}, User: "nobody"}.Validate(), ErrDriverError, "user namespace with user should be rejected")
+}
+
+func TestSandboxLinuxOnly(t *testing.T) {
+	assert.False(t, Sandbox{Env: []string{"PATH"}, WorkDir: "/tmp"}.linuxOnly(), "env and workdir should work on all platforms")
+	assert.True(t, Sandbox{Limits: Limits{CPU: 10}}.linuxOnly())
+	assert.True(t, Sandbox{User: "nobody"}.linuxOnly())
+	assert.True(t, Sandbox{Group: "nogroup"}.linuxOnly())
+	assert.True(t, Sandbox{Namespaces: []string{"pid"}}.linuxOnly())
+	assert.True(t, Sandbox{Seccomp: true}.linuxOnly())
+}
+
+func TestSandboxFilterEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/root", "GOOGLE_APPLICATION_CREDENTIALS=/secret", "DRIVER_A=1", "DRIVER_B=2"}
+	assert.Equal(t, environ, filterEnv(environ, nil), "should pass all without allow-list")
+	assert.Equal(t, []string{}, filterEnv(environ, []string{}), "should pass nothing with empty allow-list")
+	assert.Equal(t, []string{"PATH=/bin", "DRIVER_A=1", "DRIVER_B=2"}, filterEnv(environ, []string{"PATH", "DRIVER_*"}))
+}
+
+func TestSandboxParseSize(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"":      0,
+		"1024":  1024,
+		"2K":    2000,
+		"2Ki":   2048,
+		"512Mi": 512 << 20,
+		"1G":    1000 * 1000 * 1000,
+	} {
+		n, err := parseSize(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n, "parsing %s", s)
+	}
+	_, err := parseSize("1Ti")
+	assert.Error(t, err)
+}
+
+func TestSandboxCommand(t *testing.T) {
+	execCommand = exec.Command
+
+	cmd, err := Sandbox{}.Command("/bin/driver", "operator")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/driver", "operator"}, cmd.Args)
+	assert.Nil(t, cmd.Env, "should inherit environment without sandbox")
+
+	t.Setenv("HONEYDIPPER_TEST_ALLOWED", "yes")
+	cmd, err = Sandbox{Env: []string{"HONEYDIPPER_TEST_*"}, WorkDir: "/tmp"}.Command("/bin/driver", "operator")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/driver", "operator"}, cmd.Args, "should not use shim without limits")
+	assert.Equal(t, []string{"HONEYDIPPER_TEST_ALLOWED=yes"}, cmd.Env)
+	assert.Equal(t, "/tmp", cmd.Dir)
+}
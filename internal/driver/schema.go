@@ -8,11 +8,19 @@ package driver
 
 // Meta holds the meta information about the driver itself.
 type Meta struct {
-	Name        string
-	Type        string
-	Executable  string
-	Arguments   []string
-	HandlerData map[string]interface{}
+	Name         string
+	Type         string
+	Executable   string
+	Arguments    []string
+	HandlerData  map[string]interface{}
+	Framing      string
+	Codec        string
+	Restart      RestartPolicy
+	Sandbox      Sandbox
+	HealthCheck  HealthCheck
+	Instances    int
+	Balance      string
+	DrainTimeout string
 }
 
 // DriverStates represents driver states.
@@ -22,4 +30,5 @@ const (
 	DriverAlive
 	DriverFailed
 	DriverStopped
+	DriverDraining
 )
@@ -0,0 +1,16 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package driver
+
+// seccompArch is 0 on the architectures without seccomp support.
+const seccompArch uint32 = 0
+
+// seccompDenied is empty on the architectures without seccomp support.
+var seccompDenied []uint32
@@ -0,0 +1,344 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// DefaultWasmTimeout is the default time limit in seconds for a wasm module to handle a message.
+const DefaultWasmTimeout time.Duration = 30
+
+// DefaultWasmMaxOutput is the default limit in bytes of the output of a wasm module handling a message.
+const DefaultWasmMaxOutput = 16 * 1024 * 1024
+
+// wasmPageSize is the size of a wasm memory page.
+const wasmPageSize = 64 * 1024
+
+// ErrWasmOutputLimit indicates the wasm module writes more than the output limit.
+var ErrWasmOutputLimit = errors.New("wasm output limit exceeded")
+
+// WasmDriver runs a WASI module inside the daemon process.  The module has no access to the file
+// system, the network or the environment.  For every message from the daemon, a fresh instance of
+// the module is started with the driver options and the message written to its stdin, and the
+// messages it writes to stdout are passed back to the daemon.  The lifecycle messages, such as
+// options, start and ping, are handled by the handler itself.
+type WasmDriver struct {
+	meta      *Meta
+	service   string
+	args      []string
+	timeout   time.Duration
+	maxOutput int
+
+	lock    sync.Mutex
+	stream  chan<- *dipper.Message
+	options interface{}
+	cancels map[string]context.CancelFunc
+	done    chan struct{}
+
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+// NewWasmDriver creates a handler for the wasm driver specified in the meta info.
+func NewWasmDriver(m *Meta) *WasmDriver {
+	return &WasmDriver{meta: m}
+}
+
+// Meta function exposes the metadata used for this driver handler.
+func (d *WasmDriver) Meta() *Meta {
+	return d.meta
+}
+
+// Acquire function loads and verifies the module, then compiles it with the memory limit.
+func (d *WasmDriver) Acquire() {
+	file, ok := d.meta.HandlerData["module"].(string)
+	if !ok || file == "" {
+		panic(fmt.Errorf("%w: module is missing for wasm driver: %s", ErrDriverError, d.meta.Name))
+	}
+	if checksum, ok := dipper.GetMapDataStr(d.meta.HandlerData, "sha256"); ok {
+		if err := verifyChecksum(file, checksum); err != nil {
+			panic(fmt.Errorf("%w: wasm driver %s: %w", ErrDriverError, d.meta.Name, err))
+		}
+	}
+	code, err := os.ReadFile(file)
+	if err != nil {
+		panic(fmt.Errorf("%w: unable to read module for wasm driver %s: %w", ErrDriverError, d.meta.Name, err))
+	}
+
+	d.timeout = DefaultWasmTimeout * time.Second
+	if timeout, ok := dipper.GetMapDataStr(d.meta.HandlerData, "timeout"); ok {
+		d.timeout = dipper.Must(time.ParseDuration(timeout)).(time.Duration)
+	}
+
+	d.maxOutput = DefaultWasmMaxOutput
+	if maxOutput, ok := dipper.GetMapDataStr(d.meta.HandlerData, "maxOutput"); ok {
+		size, err := parseSize(maxOutput)
+		if err != nil || size <= 0 {
+			panic(fmt.Errorf("%w: invalid output limit %s for wasm driver: %s", ErrDriverError, maxOutput, d.meta.Name))
+		}
+		d.maxOutput = int(size)
+	}
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if memory, ok := dipper.GetMapDataStr(d.meta.HandlerData, "memory"); ok {
+		size, err := parseSize(memory)
+		if err != nil || size < wasmPageSize || size/wasmPageSize > 65536 {
+			panic(fmt.Errorf("%w: invalid memory limit %s for wasm driver: %s", ErrDriverError, memory, d.meta.Name))
+		}
+		config = config.WithMemoryLimitPages(uint32(size / wasmPageSize)) //nolint:gosec
+	}
+
+	ctx := context.Background()
+	d.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+	wasi_snapshot_preview1.MustInstantiate(ctx, d.runtime)
+	d.module, err = d.runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = d.runtime.Close(ctx)
+		panic(fmt.Errorf("%w: unable to compile module for wasm driver %s: %w", ErrDriverError, d.meta.Name, err))
+	}
+}
+
+// Prepare function is used for preparing the arguments and the stream for receiving messages from the driver.
+func (d *WasmDriver) Prepare(stream chan<- *dipper.Message) {
+	d.stream = stream
+	d.cancels = map[string]context.CancelFunc{}
+
+	d.args = []string{}
+	if args, ok := d.meta.HandlerData["arguments"]; ok {
+		argsList, ok := args.([]interface{})
+		if !ok {
+			panic(fmt.Errorf("%w: arguments in driver %s should be a list of strings", ErrDriverError, d.meta.Name))
+		}
+		for _, arg := range argsList {
+			d.args = append(d.args, fmt.Sprint(arg))
+		}
+	}
+}
+
+// Start marks the driver as running.  The "service" indicates which service this driver belongs to.
+func (d *WasmDriver) Start(service string) {
+	d.service = service
+	d.done = make(chan struct{})
+}
+
+// SendMessage handles the lifecycle messages, and runs the module for the other messages.
+func (d *WasmDriver) SendMessage(msg *dipper.Message) {
+	switch msg.Channel + ":" + msg.Subject {
+	case "command:options":
+		d.lock.Lock()
+		d.options = msg.Payload
+		d.lock.Unlock()
+	case "command:start":
+		// advertise the protocol version, so the daemon expects pongs for the pings
+		caps := dipper.Must(json.Marshal(&dipper.Capabilities{
+			ProtocolVersion: dipper.ProtocolVersion,
+			Name:            d.meta.Name,
+		})).([]byte)
+		d.send(&dipper.Message{Channel: dipper.ChannelState, Subject: "alive", IsRaw: true, Payload: caps})
+	case "command:ping":
+		d.send(&dipper.Message{Channel: dipper.ChannelState, Subject: dipper.StatePong, Labels: map[string]string{"state": "alive"}})
+	case "command:stop":
+		d.send(&dipper.Message{Channel: dipper.ChannelState, Subject: "stopped"})
+	case "rpc:cancel", "eventbus:cancel":
+		d.lock.Lock()
+		cancel, ok := d.cancels[wasmCallID(msg)]
+		d.lock.Unlock()
+		if ok {
+			cancel()
+		}
+	default:
+		go d.run(msg)
+	}
+}
+
+// wasmCallID returns the id for cancelling the call, the caller and the rpc id for the rpc calls, or
+// the session for the commands.
+func wasmCallID(msg *dipper.Message) string {
+	if msg.Channel == dipper.ChannelRPC {
+		return msg.Labels["caller"] + ":" + msg.Labels["rpcID"]
+	}
+
+	return msg.Labels["sessionID"]
+}
+
+// run instantiates the module to handle the message within the time limit.
+func (d *WasmDriver) run(msg *dipper.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	callID := ""
+	if (msg.Channel == "rpc" && msg.Subject == "call") || (msg.Channel == dipper.ChannelEventbus && msg.Subject == dipper.EventbusCommand) {
+		callID = wasmCallID(msg)
+	}
+
+	d.lock.Lock()
+	options := d.options
+	if callID != "" {
+		d.cancels[callID] = cancel
+	}
+	d.lock.Unlock()
+	if callID != "" {
+		defer func() {
+			d.lock.Lock()
+			delete(d.cancels, callID)
+			d.lock.Unlock()
+		}()
+	}
+
+	stdin := &bytes.Buffer{}
+	in := dipper.NewConn(nil, stdin)
+	if options != nil {
+		in.SendMessage(&dipper.Message{Channel: "command", Subject: "options", Payload: options})
+	}
+	in.SendMessage(msg)
+	stdout := &limitedBuffer{max: d.maxOutput}
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(append([]string{d.meta.Name, d.service}, d.args...)...).
+		WithStdin(stdin).
+		WithStdout(stdout).
+		WithStderr(os.Stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+
+	mod, err := d.runtime.InstantiateModule(ctx, d.module, config)
+	if mod != nil {
+		_ = mod.Close(ctx)
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if stdout.exceeded {
+		err = fmt.Errorf("%w: %d bytes", ErrWasmOutputLimit, d.maxOutput)
+	}
+	if err != nil {
+		dipper.Logger.Warningf("[%s] wasm driver %s failed to handle %s:%s: %v", d.service, d.meta.Name, msg.Channel, msg.Subject, err)
+		d.returnError(msg, err)
+
+		return
+	}
+
+	for {
+		ret, err := dipper.ReadMessage(&stdout.Buffer)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				dipper.Logger.Warningf("[%s] wasm driver %s sent a malformed message: %v", d.service, d.meta.Name, err)
+			}
+
+			return
+		}
+		d.send(ret)
+	}
+}
+
+// limitedBuffer keeps up to max bytes written to it, the writes beyond the limit fail.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		b.exceeded = true
+
+		return 0, ErrWasmOutputLimit
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// returnError reports the failure to the caller of the message, if any.
+func (d *WasmDriver) returnError(msg *dipper.Message, err error) {
+	switch {
+	case msg.Channel == "rpc" && msg.Subject == "call":
+		d.send(&dipper.Message{
+			Channel: "rpc",
+			Subject: "return",
+			Labels: map[string]string{
+				"rpcID":  msg.Labels["rpcID"],
+				"caller": msg.Labels["caller"],
+				"error":  err.Error(),
+			},
+		})
+	case msg.Channel == "eventbus" && msg.Subject == "command" && msg.Labels["sessionID"] != "":
+		labels := map[string]string{}
+		for k, v := range msg.Labels {
+			labels[k] = v
+		}
+		labels["status"] = dipper.ERROR
+		labels["reason"] = err.Error()
+		d.send(&dipper.Message{
+			Channel: "eventbus",
+			Subject: "return",
+			Labels:  labels,
+		})
+	}
+}
+
+// send passes a message to the daemon unless the driver is closed.
+func (d *WasmDriver) send(msg *dipper.Message) {
+	d.lock.Lock()
+	stream := d.stream
+	d.lock.Unlock()
+
+	if stream != nil {
+		// the stream may be closed while waiting for the daemon to receive
+		defer dipper.SafeExitOnError("[%s] wasm driver %s closed", d.service, d.meta.Name)
+		stream <- msg
+	}
+}
+
+// Close stops the running instances and releases the module.
+func (d *WasmDriver) Close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.stream != nil {
+		close(d.stream)
+		d.stream = nil
+	}
+	for _, cancel := range d.cancels {
+		cancel()
+	}
+	if d.runtime != nil {
+		_ = d.runtime.Close(context.Background())
+	}
+	if d.done != nil {
+		close(d.done)
+		d.done = nil
+	}
+}
+
+// Wait waits for the driver to be closed.
+func (d *WasmDriver) Wait() {
+	d.lock.Lock()
+	done := d.done
+	d.lock.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+// wasmCat copies stdin to stdout, so the messages sent to the module are echoed back.
+var wasmCat = []byte{
+	0x02, 0x40, // block
+	0x03, 0x40, // loop
+	// iovec{buf: 16, len: 1024} at 0
+	0x41, 0x00, 0x41, 0x10, 0x36, 0x02, 0x00,
+	0x41, 0x04, 0x41, 0x80, 0x08, 0x36, 0x02, 0x00,
+	// fd_read(0, 0, 1, 8)
+	0x41, 0x00, 0x41, 0x00, 0x41, 0x01, 0x41, 0x08, 0x10, 0x00, 0x1a,
+	// break on eof
+	0x41, 0x08, 0x28, 0x02, 0x00, 0x45, 0x0d, 0x01,
+	// iovec.len = nread
+	0x41, 0x04, 0x41, 0x08, 0x28, 0x02, 0x00, 0x36, 0x02, 0x00,
+	// fd_write(1, 0, 1, 12)
+	0x41, 0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x0c, 0x10, 0x01, 0x1a,
+	0x0c, 0x00, // br loop
+	0x0b, 0x0b, // end loop, end block
+}
+
+// wasmExit exits with code 3.
+var wasmExit = []byte{0x41, 0x03, 0x10, 0x02}
+
+// wasmSpin never returns.
+var wasmSpin = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b}
+
+// wasmModule writes a WASI module with the body as its _start function, and returns the path.
+func wasmModule(t *testing.T, body []byte) string {
+	section := func(id byte, content ...byte) []byte {
+		return append([]byte{id, byte(len(content))}, content...)
+	}
+	wasi := append([]byte{22}, "wasi_snapshot_preview1"...)
+	imp := func(name string, typeIdx byte) []byte {
+		ret := append(append([]byte{}, wasi...), byte(len(name)))
+		ret = append(ret, name...)
+
+		return append(ret, 0x00, typeIdx)
+	}
+
+	imports := []byte{3}
+	imports = append(imports, imp("fd_read", 0)...)
+	imports = append(imports, imp("fd_write", 0)...)
+	imports = append(imports, imp("proc_exit", 2)...)
+
+	fn := append([]byte{0x00}, body...)
+	fn = append(fn, 0x0b)
+	code := append([]byte{1, byte(len(fn))}, fn...)
+
+	mod := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	mod = append(mod, section(1,
+		3,
+		0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f, // (i32, i32, i32, i32) -> i32
+		0x60, 0, 0, // () -> ()
+		0x60, 1, 0x7f, 0, // (i32) -> ()
+	)...)
+	mod = append(mod, section(2, imports...)...)
+	mod = append(mod, section(3, 1, 1)...)
+	mod = append(mod, section(5, 1, 0x00, 1)...)
+	mod = append(mod, section(7,
+		2,
+		6, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0,
+		6, '_', 's', 't', 'a', 'r', 't', 0x00, 3,
+	)...)
+	mod = append(mod, section(10, code...)...)
+
+	file := filepath.Join(t.TempDir(), "driver.wasm")
+	assert.NoError(t, os.WriteFile(file, mod, 0o600), "should write the module")
+
+	return file
+}
+
+func startWasmDriver(t *testing.T, handlerData map[string]interface{}) (*WasmDriver, chan *dipper.Message) {
+	d := NewWasmDriver(&Meta{Name: "test", HandlerData: handlerData})
+	stream := make(chan *dipper.Message, DriverMessageBuffer)
+	assert.NotPanics(t, d.Acquire, "should load the module")
+	d.Prepare(stream)
+	d.Start("test")
+	t.Cleanup(d.Close)
+
+	return d, stream
+}
+
+func receive(t *testing.T, stream <-chan *dipper.Message) *dipper.Message {
+	select {
+	case msg := <-stream:
+		return msg
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "should receive a message from the driver")
+
+		return nil
+	}
+}
+
+func TestWasmAcquire(t *testing.T) {
+	module := wasmModule(t, wasmCat)
+	sum, _ := os.ReadFile(module)
+	checksum := sha256.Sum256(sum)
+
+	testCases := map[string]struct {
+		handlerData map[string]interface{}
+		fails       bool
+	}{
+		"missing module":   {map[string]interface{}{}, true},
+		"module not found": {map[string]interface{}{"module": module + ".missing"}, true},
+		"sha256 mismatch":  {map[string]interface{}{"module": module, "sha256": hex.EncodeToString(make([]byte, 32))}, true},
+		"small memory":     {map[string]interface{}{"module": module, "memory": "1Ki"}, true},
+		"invalid timeout":  {map[string]interface{}{"module": module, "timeout": "soon"}, true},
+		"verified module":  {map[string]interface{}{"module": module, "sha256": hex.EncodeToString(checksum[:])}, false},
+		"with limits":      {map[string]interface{}{"module": module, "memory": "1Mi", "timeout": "1s", "maxOutput": "1Ki"}, false},
+		"bad output limit": {map[string]interface{}{"module": module, "maxOutput": "lots"}, true},
+	}
+
+	for msg, tc := range testCases {
+		d := NewWasmDriver(&Meta{Name: "test", HandlerData: tc.handlerData})
+		if tc.fails {
+			assert.Panics(t, d.Acquire, "should panic with %s", msg)
+		} else {
+			assert.NotPanics(t, d.Acquire, "should accept %s", msg)
+			d.Close()
+		}
+	}
+}
+
+func TestWasmDriverLifecycle(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmCat)})
+
+	d.SendMessage(&dipper.Message{Channel: "command", Subject: "start"})
+	msg := receive(t, stream)
+	assert.Equal(t, "state", msg.Channel)
+	assert.Equal(t, "alive", msg.Subject, "should report alive on start")
+	caps := dipper.Capabilities{}
+	assert.NoError(t, json.Unmarshal(msg.Payload.([]byte), &caps), "should advertise capabilities on start")
+	assert.Equal(t, dipper.ProtocolVersion, caps.ProtocolVersion)
+
+	d.SendMessage(&dipper.Message{Channel: "command", Subject: "ping"})
+	pong := receive(t, stream)
+	assert.Equal(t, dipper.StatePong, pong.Subject, "should answer ping with pong")
+	assert.Equal(t, "alive", pong.Labels["state"])
+
+	done := make(chan struct{})
+	go func() {
+		d.Wait()
+		close(done)
+	}()
+	d.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "should stop waiting when closed")
+	}
+	d.SendMessage(&dipper.Message{Channel: "command", Subject: "ping"})
+}
+
+func TestWasmDriverCall(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmCat)})
+
+	d.SendMessage(&dipper.Message{
+		Channel: "command",
+		Subject: "options",
+		Payload: map[string]interface{}{"data": map[string]interface{}{"key": "value"}},
+	})
+	d.SendMessage(&dipper.Message{
+		Channel: "eventbus",
+		Subject: "command",
+		Labels:  map[string]string{"sessionID": "1"},
+		Payload: map[string]interface{}{"text": "hello"},
+	})
+
+	options := dipper.DeserializePayload(receive(t, stream))
+	assert.Equal(t, "options", options.Subject, "should pass the options to the module")
+	assert.Equal(t, "value", dipper.MustGetMapDataStr(options.Payload, "data.key"))
+
+	call := dipper.DeserializePayload(receive(t, stream))
+	assert.Equal(t, "eventbus", call.Channel)
+	assert.Equal(t, "1", call.Labels["sessionID"])
+	assert.Equal(t, "hello", dipper.MustGetMapDataStr(call.Payload, "text"), "should pass the message to the module")
+}
+
+func TestWasmDriverErrors(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmExit)})
+
+	d.SendMessage(&dipper.Message{
+		Channel: "rpc",
+		Subject: "call",
+		Labels:  map[string]string{"rpcID": "1", "caller": "operator"},
+	})
+	ret := receive(t, stream)
+	assert.Equal(t, "return", ret.Subject)
+	assert.Equal(t, "operator", ret.Labels["caller"])
+	assert.Contains(t, ret.Labels["error"], "exit_code(3)", "should return the exit code as error")
+
+	d.SendMessage(&dipper.Message{
+		Channel: "eventbus",
+		Subject: "command",
+		Labels:  map[string]string{"sessionID": "1"},
+	})
+	ret = receive(t, stream)
+	assert.Equal(t, dipper.ERROR, ret.Labels["status"])
+	assert.Equal(t, "1", ret.Labels["sessionID"])
+}
+
+func TestWasmDriverTimeout(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmSpin), "timeout": "100ms"})
+
+	d.SendMessage(&dipper.Message{
+		Channel: "rpc",
+		Subject: "call",
+		Labels:  map[string]string{"rpcID": "1", "caller": "operator"},
+	})
+	ret := receive(t, stream)
+	assert.NotEmpty(t, ret.Labels["error"], "should stop the module after the timeout")
+}
+
+func TestWasmDriverOutputLimit(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmCat), "maxOutput": "64"})
+
+	d.SendMessage(&dipper.Message{
+		Channel: "rpc",
+		Subject: "call",
+		Labels:  map[string]string{"rpcID": "1", "caller": "operator"},
+		Payload: map[string]interface{}{"text": strings.Repeat("x", 128)},
+	})
+	ret := receive(t, stream)
+	assert.Equal(t, "return", ret.Subject)
+	assert.Contains(t, ret.Labels["error"], ErrWasmOutputLimit.Error(), "should stop the module writing over the limit")
+}
+
+func TestWasmDriverCancel(t *testing.T) {
+	d, stream := startWasmDriver(t, map[string]interface{}{"module": wasmModule(t, wasmSpin)})
+
+	d.SendMessage(&dipper.Message{
+		Channel: "rpc",
+		Subject: "call",
+		Labels:  map[string]string{"rpcID": "2", "caller": "operator"},
+	})
+	time.Sleep(10 * time.Millisecond)
+	d.SendMessage(&dipper.Message{
+		Channel: "rpc",
+		Subject: "cancel",
+		Labels:  map[string]string{"rpcID": "2", "caller": "operator"},
+	})
+	ret := receive(t, stream)
+	assert.Equal(t, "2", ret.Labels["rpcID"])
+	assert.NotEmpty(t, ret.Labels["error"], "should stop the module when cancelled")
+}
@@ -46,6 +46,7 @@ func StartAPI(cfg *config.Config) {
 	API.DiscoverFeatures = APIFeatures
 	API.addResponder("eventbus:api", handleAPIMessage)
 	APIRequestStore = api.NewStore(API)
+	setupAPIAPIs()
 	API.start()
 }
 
@@ -78,7 +79,7 @@ func startAPIListener() {
 	mux := http.NewServeMux()
 	mux.Handle(prefix, APIRequestStore.GetAPIHandler(prefix, APICfg))
 	mux.HandleFunc(healthcheckPrefix, func(w http.ResponseWriter, r *http.Request) {
-		if API.CheckHealth() {
+		if CheckHealth() {
 			w.WriteHeader(http.StatusOK)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,35 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package service
+
+import (
+	"sort"
+
+	"github.com/honeydipper/honeydipper/v3/internal/api"
+)
+
+func setupAPIAPIs() {
+	API.APIs["driverList"] = handleDriverList
+}
+
+// handleDriverList reports the drivers of all the services in this daemon along with their restarts.
+func handleDriverList(resp *api.Response) {
+	names := make([]string, 0, len(Services))
+	for name := range Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drivers := []interface{}{}
+	for _, name := range names {
+		drivers = append(drivers, Services[name].DriverStatus()...)
+	}
+	resp.Return(map[string]interface{}{
+		"daemonID": API.daemonID,
+		"drivers":  drivers,
+	})
+}
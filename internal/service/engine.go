@@ -7,7 +7,9 @@
 package service
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -54,27 +56,115 @@ func (h *WorkflowHelper) GetDaemonID() string {
 	return h.engine.daemonID
 }
 
+// lockHeldReason is the error reason returned by the locker feature when the lock is held, i.e. the
+// ErrFailToLock of the redislock driver.
+const lockHeldReason = "reason: fail to lock"
+
+// lockNotOwnedReason is the error reason returned by the locker feature when the lock is no longer
+// held by the owner, i.e. the ErrNotOwner of the redislock driver.
+const lockNotOwnedReason = "reason: not the lock owner"
+
+// ConcurrencyCoordinator coordinates the concurrency slots of the workflow sessions across the
+// engines through the locker feature, and cancels the previous sessions through broadcast.
+type ConcurrencyCoordinator struct {
+	engine *Service
+}
+
+// Lock acquires the lock through the locker feature, the errors other than the lock being held
+// are returned.
+func (c *ConcurrencyCoordinator) Lock(name string, owner string, expire time.Duration) (bool, error) {
+	_, err := c.engine.Call("locker", "lock", map[string]interface{}{
+		"name":   name,
+		"owner":  owner,
+		"expire": expire.String(),
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case strings.HasSuffix(err.Error(), lockHeldReason):
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Refresh extends the lock held by the owner through the locker feature, the errors other than the
+// lock not being held by the owner are returned.
+func (c *ConcurrencyCoordinator) Refresh(name string, owner string, expire time.Duration) (bool, error) {
+	_, err := c.engine.Call("locker", "refresh", map[string]interface{}{
+		"name":   name,
+		"owner":  owner,
+		"expire": expire.String(),
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case strings.HasSuffix(err.Error(), lockNotOwnedReason):
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Unlock releases the lock through the locker feature, the lock taken by others after it expires is
+// kept.
+func (c *ConcurrencyCoordinator) Unlock(name string, owner string) {
+	if _, err := c.engine.Call("locker", "unlock", map[string]interface{}{"name": name, "owner": owner}); err != nil {
+		dipper.Logger.Warningf("[engine] unable to unlock %s: %v", name, err)
+	}
+}
+
+// CancelHolders broadcasts to all the engines to cancel the sessions holding the slots of the key
+// since before the time.
+func (c *ConcurrencyCoordinator) CancelHolders(key string, before time.Time) {
+	dipper.Must(c.engine.CallNoWait("driver:redispubsub", "send", map[string]interface{}{
+		"broadcastSubject": "cancel_concurrency",
+		"data": map[string]interface{}{
+			"key":    key,
+			"before": before.Format(time.RFC3339Nano),
+		},
+	}))
+}
+
 // StartEngine Starts the engine service.
 func StartEngine(cfg *config.Config) {
 	engine = NewService(cfg, "engine")
 	helper := &WorkflowHelper{engine: engine}
 	sessionStore = workflow.NewSessionStore(helper)
+	if _, ok := engine.getFeatureList()["locker"]; ok {
+		sessionStore.Coordinator = &ConcurrencyCoordinator{engine: engine}
+	} else {
+		dipper.Logger.Infof("[engine] locker feature not configured, concurrency limits are kept in this engine only")
+	}
 
 	engine.ServiceReload = buildRuleMap
 	engine.EmitMetrics = engineMetrics
 	engine.addResponder("broadcast:resume_session", resumeSession)
+	engine.addResponder("broadcast:cancel_concurrency", cancelConcurrency)
 	engine.addResponder("eventbus:message", createSessions)
 	engine.addResponder("eventbus:return", continueSession)
 	setupEngineAPIs()
 
 	engine.start()
-	if cfg.IsJobMode {
+	if limit, ok := dipper.GetMapData(cfg.DataSet.Drivers, "daemon.services.engine.history_limit"); ok && limit != nil {
+		sessionStore.History = workflow.NewEventHistory(dipper.Must(strconv.Atoi(fmt.Sprint(limit))).(int))
+	}
+	if backend := newSessionBackend(cfg, "dead_letters", workflow.DefaultDeadLetterKey); backend != nil {
+		sessionStore.DeadLetters = backend
+	} else if limit, ok := dipper.GetMapData(cfg.DataSet.Drivers, "daemon.services.engine.dead_letter_limit"); ok && limit != nil {
+		sessionStore.DeadLetters = workflow.NewMemoryBackend(dipper.Must(strconv.Atoi(fmt.Sprint(limit))).(int))
+	}
+	if backend := newSessionBackend(cfg, "persistence", workflow.DefaultSessionKey); backend != nil {
 		go func() {
-			cfg.StageWG[config.StageDiscovering].Wait()
-			for cfg.Stage != config.StageServing {
-				dipper.Logger.Info("Waiting for serving stage ...")
-				time.Sleep(time.Second)
+			waitForServing(cfg)
+			if err := sessionStore.Recover(backend); err != nil {
+				dipper.Logger.Errorf("[engine] unable to recover sessions, persistence disabled: %v", err)
 			}
+		}()
+	}
+	if cfg.IsJobMode {
+		go func() {
+			waitForServing(cfg)
 			msg := &dipper.Message{
 				Labels: map[string]string{
 					"eventID": "main",
@@ -92,6 +182,45 @@ func StartEngine(cfg *config.Config) {
 	}
 }
 
+// waitForServing waits until the config is fully loaded and all the services are serving.
+func waitForServing(cfg *config.Config) {
+	cfg.StageWG[config.StageDiscovering].Wait()
+	for cfg.Stage != config.StageServing {
+		dipper.Logger.Info("Waiting for serving stage ...")
+		time.Sleep(time.Second)
+	}
+}
+
+// newSessionBackend creates the backend for persisting the workflow sessions or the dead letters, as
+// configured in daemon.services.engine.<name>, nil if not configured.
+func newSessionBackend(cfg *config.Config, name string, defaultKey string) workflow.SessionBackend {
+	persistence, ok := dipper.GetMapData(cfg.DataSet.Drivers, "daemon.services.engine."+name)
+	if !ok || persistence == nil {
+		return nil
+	}
+
+	switch backendType, _ := dipper.GetMapDataStr(persistence, "type"); backendType {
+	case "file":
+		path, ok := dipper.GetMapDataStr(persistence, "path")
+		if !ok || path == "" {
+			dipper.Logger.Panicf("[engine] path is required for file %s", name)
+		}
+
+		return dipper.Must(workflow.NewFileBackend(path)).(*workflow.FileBackend)
+	case "redis":
+		key, _ := dipper.GetMapDataStr(persistence, "key")
+		if key == "" {
+			key = defaultKey
+		}
+
+		return workflow.NewCacheBackend(engine, key)
+	default:
+		dipper.Logger.Panicf("[engine] unknown %s type %s", name, backendType)
+	}
+
+	return nil
+}
+
 func createSessions(d *driver.Runtime, msg *dipper.Message) {
 	defer dipper.SafeExitOnError("[engine] continue processing rules")
 	msg = dipper.DeserializePayload(msg)
@@ -184,6 +313,14 @@ func resumeSession(d *driver.Runtime, m *dipper.Message) {
 	go sessionStore.ResumeSession(key, m)
 }
 
+func cancelConcurrency(d *driver.Runtime, m *dipper.Message) {
+	defer dipper.SafeExitOnError("[engine] continue processing rules")
+	m = dipper.DeserializePayload(m)
+	key := dipper.MustGetMapDataStr(m.Payload, "key")
+	before := dipper.Must(time.Parse(time.RFC3339Nano, dipper.MustGetMapDataStr(m.Payload, "before"))).(time.Time)
+	go sessionStore.CancelConcurrency(key, before)
+}
+
 func (h *WorkflowHelper) EmitResult(eventID string, result map[string]interface{}) {
 	dipper.Must(engine.CallNoWait("cache", "rpush", map[string]any{
 		"key":   "honeydipper/result/" + eventID,
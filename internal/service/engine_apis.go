@@ -7,15 +7,27 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/honeydipper/honeydipper/v3/internal/api"
+	"github.com/honeydipper/honeydipper/v3/internal/workflow"
 	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
 )
 
 func setupEngineAPIs() {
 	engine.APIs["eventWait"] = handleEventWait
 	engine.APIs["eventList"] = handleEventList
+	engine.APIs["eventCancel"] = handleEventCancel
+	engine.APIs["eventHistory"] = handleEventHistory
+	engine.APIs["eventSessionHistory"] = handleEventSessionHistory
+	engine.APIs["approvalList"] = handleApprovalList
+	engine.APIs["approvalApprove"] = handleApprovalDecision(true)
+	engine.APIs["approvalReject"] = handleApprovalDecision(false)
+	engine.APIs["deadLetterList"] = handleDeadLetterList
+	engine.APIs["deadLetterGet"] = handleDeadLetterGet
+	engine.APIs["deadLetterReplay"] = handleDeadLetterReplay
 }
 
 func handleEventWait(resp *api.Response) {
@@ -71,3 +83,154 @@ func handleEventList(resp *api.Response) {
 		"sessions": ret,
 	})
 }
+
+func handleEventCancel(resp *api.Response) {
+	resp.Request = dipper.DeserializePayload(resp.Request)
+	eventID := dipper.MustGetMapDataStr(resp.Request.Payload, "eventID")
+	sessions := sessionStore.CancelEvent(eventID, workflow.ReasonCancelled)
+	if len(sessions) == 0 {
+		return
+	}
+
+	resp.Ack()
+	ret := make([]interface{}, len(sessions))
+	for i, session := range sessions {
+		ret[i] = map[string]interface{}{
+			"name":        session.GetName(),
+			"description": session.GetDescription(),
+			"eventID":     session.GetEventID(),
+			"event":       session.GetEventName(),
+		}
+	}
+	resp.Return(map[string]interface{}{
+		"sessions": ret,
+	})
+}
+
+func handleEventHistory(resp *api.Response) {
+	resp.Request = dipper.DeserializePayload(resp.Request)
+	eventID := dipper.MustGetMapDataStr(resp.Request.Payload, "eventID")
+	history := sessionStore.GetHistory(eventID)
+	if history == nil {
+		return
+	}
+
+	resp.Ack()
+	resp.Return(map[string]interface{}{
+		"sessions": history,
+	})
+}
+
+func handleEventSessionHistory(resp *api.Response) {
+	resp.Request = dipper.DeserializePayload(resp.Request)
+	eventID := dipper.MustGetMapDataStr(resp.Request.Payload, "eventID")
+	sessionID := dipper.MustGetMapDataStr(resp.Request.Payload, "sessionID")
+	session := workflow.FindHistory(sessionStore.GetHistory(eventID), sessionID)
+	if session == nil {
+		return
+	}
+
+	resp.Ack()
+	resp.Return(map[string]interface{}{
+		"session": session,
+	})
+}
+
+func handleApprovalList(resp *api.Response) {
+	resp.Return(map[string]interface{}{
+		"approvals": sessionStore.ListApprovals(),
+	})
+}
+
+func handleApprovalDecision(approved bool) func(*api.Response) {
+	return func(resp *api.Response) {
+		resp.Request = dipper.DeserializePayload(resp.Request)
+		approvalID := dipper.MustGetMapDataStr(resp.Request.Payload, "approvalID")
+		subject, _ := dipper.GetMapDataStr(resp.Request.Payload, "subject")
+		roles := []string{}
+		if list, ok := dipper.GetMapData(resp.Request.Payload, "roles"); ok && list != nil {
+			for _, role := range list.([]interface{}) {
+				roles = append(roles, role.(string))
+			}
+		}
+		body := struct{ Comment string }{}
+		if data, _ := dipper.GetMapDataStr(resp.Request.Payload, "body"); data != "" {
+			if err := json.Unmarshal([]byte(data), &body); err != nil {
+				// plain text comment
+				body.Comment = data
+			}
+		}
+
+		decision, err := sessionStore.DecideApproval(approvalID, approved, subject, roles, body.Comment)
+		if errors.Is(err, workflow.ErrApprovalNotFound) {
+			// the approval is pending in another engine
+			return
+		}
+
+		resp.Ack()
+		if err != nil {
+			resp.ReturnError(err)
+
+			return
+		}
+		resp.Return(map[string]interface{}{
+			"approval": decision,
+		})
+	}
+}
+
+func handleDeadLetterList(resp *api.Response) {
+	deadLetters, err := sessionStore.ListDeadLetters()
+	if err != nil {
+		resp.ReturnError(err)
+
+		return
+	}
+	ret := make([]interface{}, len(deadLetters))
+	for i, d := range deadLetters {
+		ret[i] = d.Summary()
+	}
+	resp.Return(map[string]interface{}{
+		"deadletters": ret,
+	})
+}
+
+func handleDeadLetterGet(resp *api.Response) {
+	resp.Request = dipper.DeserializePayload(resp.Request)
+	id := dipper.MustGetMapDataStr(resp.Request.Payload, "deadLetterID")
+	d, err := sessionStore.GetDeadLetter(id)
+	if errors.Is(err, workflow.ErrDeadLetterNotFound) {
+		// the dead letter is kept in another engine
+		return
+	}
+
+	resp.Ack()
+	if err != nil {
+		resp.ReturnError(err)
+
+		return
+	}
+	resp.Return(map[string]interface{}{
+		"deadletter": d.Sanitized(),
+	})
+}
+
+func handleDeadLetterReplay(resp *api.Response) {
+	resp.Request = dipper.DeserializePayload(resp.Request)
+	id := dipper.MustGetMapDataStr(resp.Request.Payload, "deadLetterID")
+	eventID, err := sessionStore.ReplayDeadLetter(id)
+	if errors.Is(err, workflow.ErrDeadLetterNotFound) {
+		// the dead letter is kept in another engine
+		return
+	}
+
+	resp.Ack()
+	if err != nil {
+		resp.ReturnError(err)
+
+		return
+	}
+	resp.Return(map[string]interface{}{
+		"eventID": eventID,
+	})
+}
@@ -76,11 +76,12 @@ func handleEventbusCommand(msg *dipper.Message) []RoutedMessage {
 	if strings.HasPrefix(driver, "feature:") {
 		feature = driver[8:]
 	}
-	worker := operator.getDriverRuntime(feature)
+	worker := operator.pickDriverRuntime(feature, msg.Labels["sessionID"])
 
 	if worker == nil {
 		panic(fmt.Errorf("%w: not defined: %s", ErrOperatorError, driver))
 	}
+	operator.trackCall(msg.Labels["sessionID"], worker)
 	finalParams := params
 	if params != nil {
 		// interpolate twice for giving an chance for using sysData in ctx
@@ -145,10 +146,37 @@ func handleEventbusCommand(msg *dipper.Message) []RoutedMessage {
 	}
 }
 
+// handleEventbusCancel forwards the cancellation of a command to the driver running it.  The
+// cancellation is ignored if the command has returned or is running with another operator.
+func handleEventbusCancel(msg *dipper.Message) []RoutedMessage {
+	sessionID := msg.Labels["sessionID"]
+	worker := operator.pendingCall(sessionID)
+	if worker == nil {
+		dipper.Logger.Debugf("[operator] no command in flight to cancel for session %s", sessionID)
+
+		return nil
+	}
+	dipper.Logger.Infof("[operator] cancelling command for session %s with %s", sessionID, worker.Name())
+
+	return []RoutedMessage{
+		{
+			driverRuntime: worker,
+			message: &dipper.Message{
+				Channel: dipper.ChannelEventbus,
+				Subject: dipper.EventbusCancel,
+				Labels:  map[string]string{"sessionID": sessionID},
+			},
+		},
+	}
+}
+
 func operatorRoute(msg *dipper.Message) (ret []RoutedMessage) {
 	dipper.Logger.Infof("[operator] routing message %s.%s", msg.Channel, msg.Subject)
 	defer dipper.SafeExitOnError("[operator] continue on processing messages")
 	switch {
+	case msg.Channel == dipper.ChannelEventbus && msg.Subject == dipper.EventbusCommand && msg.Labels[dipper.EventbusCancel] != "":
+		// cancellations travel with the commands through the eventbus
+		ret = handleEventbusCancel(msg)
 	case msg.Channel == dipper.ChannelEventbus && msg.Subject == dipper.EventbusCommand:
 		ret = handleEventbusCommand(msg)
 	case msg.Channel == dipper.ChannelEventbus && (msg.Subject == dipper.EventbusReturn || msg.Subject == dipper.EventbusMessage):
@@ -0,0 +1,180 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package service
+
+import (
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// poolReceiver sends the rpc calls from the daemon to the instances of the driver for a feature, and
+// tracks the calls for draining.
+type poolReceiver struct {
+	service *Service
+	feature string
+}
+
+// SendMessage sends the message to an instance of the driver pool.
+func (r *poolReceiver) SendMessage(m *dipper.Message) {
+	r.service.sendToPool(r.feature, m)
+}
+
+// getDriverPool returns the running instances of the driver for the feature.
+func (s *Service) getDriverPool(feature string) []*driver.Runtime {
+	s.driverLock.Lock()
+	defer s.driverLock.Unlock()
+
+	primary, ok := s.driverRuntimes[feature]
+	if !ok || primary == nil {
+		return nil
+	}
+	instances := []*driver.Runtime{primary}
+	for i := 1; i < primary.Handler.Meta().PoolSize(); i++ {
+		if runtime, ok := s.driverRuntimes[driver.InstanceKey(feature, i)]; ok && runtime != nil {
+			instances = append(instances, runtime)
+		}
+	}
+
+	return instances
+}
+
+// shrinkPool stops the instances of the driver for the feature beyond the pool size.
+func (s *Service) shrinkPool(feature string, size int) {
+	for i := size; ; i++ {
+		runtime := s.getDriverRuntime(driver.InstanceKey(feature, i))
+		if runtime == nil {
+			return
+		}
+		dipper.Logger.Warningf("[%s] stopping driver %s", s.name, runtime.Name())
+		s.checkDeleteDriverRuntime(runtime.Key(), runtime)
+		s.drainDriverRuntime(runtime)
+	}
+}
+
+// pickDriverRuntime chooses an instance of the driver for the feature to make the call with the id.
+func (s *Service) pickDriverRuntime(feature string, id string) *driver.Runtime {
+	instances := s.getDriverPool(feature)
+	if len(instances) == 0 {
+		return nil
+	}
+
+	s.driverLock.Lock()
+	if s.pools == nil {
+		s.pools = map[string]*driver.Pool{}
+	}
+	pool, ok := s.pools[feature]
+	if !ok {
+		pool = &driver.Pool{}
+		s.pools[feature] = pool
+	}
+	s.driverLock.Unlock()
+
+	return pool.Pick(instances, id)
+}
+
+// sendToPool sends the message to the driver for the feature. Rpc calls are balanced across the
+// instances, the cancellations follow the calls, and other messages go to the first instance.
+func (s *Service) sendToPool(feature string, m *dipper.Message) {
+	key := callID(m)
+
+	var runtime *driver.Runtime
+	switch {
+	case m.Channel == dipper.ChannelRPC && m.Subject == "call":
+		runtime = s.pickDriverRuntime(feature, key)
+		s.trackCall(key, runtime)
+	case m.Channel == dipper.ChannelRPC && m.Subject == dipper.RPCCancel:
+		runtime = s.pendingCall(key)
+	}
+	if runtime == nil {
+		runtime = s.getDriverRuntime(feature)
+	}
+	runtime.SendMessage(m)
+}
+
+// trackCall records the runtime making the call, so the cancellation can follow the call.
+func (s *Service) trackCall(key string, runtime *driver.Runtime) {
+	if runtime == nil || key == "" {
+		return
+	}
+
+	s.callLock.Lock()
+	defer s.callLock.Unlock()
+	if s.pendingCalls == nil {
+		s.pendingCalls = map[string]*driver.Runtime{}
+	}
+	s.pendingCalls[key] = runtime
+}
+
+// pendingCall returns the runtime making the call, nil if the call has returned.
+func (s *Service) pendingCall(key string) *driver.Runtime {
+	s.callLock.Lock()
+	defer s.callLock.Unlock()
+
+	return s.pendingCalls[key]
+}
+
+// isCallReturn checks if the message returns a call made to the driver.
+func isCallReturn(m *dipper.Message) bool {
+	return (m.Channel == dipper.ChannelRPC && m.Subject == "return") ||
+		(m.Channel == dipper.ChannelEventbus && m.Subject == dipper.EventbusReturn)
+}
+
+// callID returns the id for tracking the call, the session for the commands, or the caller and the
+// rpc id for the rpc calls, empty if the call does not expect a return.
+func callID(m *dipper.Message) string {
+	if m.Channel == dipper.ChannelRPC {
+		if m.Labels["rpcID"] == "" {
+			return ""
+		}
+
+		return m.Labels["caller"] + ":" + m.Labels["rpcID"]
+	}
+
+	return m.Labels["sessionID"]
+}
+
+// callFinished records the return of a call from the driver.
+func (s *Service) callFinished(runtime *driver.Runtime, m *dipper.Message) {
+	key := callID(m)
+	runtime.CallFinished(key)
+
+	s.callLock.Lock()
+	defer s.callLock.Unlock()
+	if s.pendingCalls[key] == runtime {
+		delete(s.pendingCalls, key)
+	}
+}
+
+// drainDriverRuntime stops routing new messages to the runtime being replaced or removed, and closes
+// it after the calls in flight return or the drain timeout.
+func (s *Service) drainDriverRuntime(runtime *driver.Runtime) {
+	s.driverLock.Lock()
+	runtime.State = driver.DriverDraining
+	if s.draining == nil {
+		s.draining = map[*driver.Runtime]bool{}
+	}
+	s.draining[runtime] = true
+	s.driverLock.Unlock()
+
+	go func() {
+		defer dipper.SafeExitOnError("[%s] runtime %s being drained output is already closed", s.name, runtime.Name())
+		defer func() {
+			s.driverLock.Lock()
+			defer s.driverLock.Unlock()
+			delete(s.draining, runtime)
+		}()
+
+		if busy := runtime.Drain(runtime.Handler.Meta().DrainPeriod()); busy > 0 {
+			dipper.Logger.Warningf("[%s] stopping driver %s with %d calls in flight", s.name, runtime.Name(), busy)
+		}
+		// allow 50 millisecond for the data to drain
+		time.Sleep(DriverGracefulTimeout * time.Millisecond)
+		runtime.Handler.Close()
+	}()
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceDriverPool(t *testing.T) {
+	meta := &driver.Meta{Name: "web", Type: "null", Instances: 2}
+	received := map[int][]string{}
+	svc := &Service{
+		name:           "testpool",
+		driverRuntimes: map[string]*driver.Runtime{},
+	}
+	for i := 0; i < 3; i++ {
+		i := i
+		h := driver.NewNullDriver(meta)
+		h.SendMessageFunc = func(m *dipper.Message) {
+			received[i] = append(received[i], m.Subject)
+		}
+		runtime := &driver.Runtime{Feature: "driver:web", Instance: i, Handler: h, State: driver.DriverAlive}
+		svc.driverRuntimes[runtime.Key()] = runtime
+	}
+
+	assert.Len(t, svc.getDriverPool("driver:web"), 2, "should only include instances within the pool size")
+	assert.Equal(t, svc.driverRuntimes["driver:web"], svc.getDriverRuntime("driver:web"), "should keep the first instance as the feature runtime")
+
+	call := func(id string) *dipper.Message {
+		return &dipper.Message{Channel: dipper.ChannelRPC, Subject: "call", Labels: map[string]string{"caller": "-", "rpcID": id}}
+	}
+	svc.sendToPool("driver:web", call("1"))
+	svc.sendToPool("driver:web", call("2"))
+	assert.Equal(t, []string{"call"}, received[0])
+	assert.Equal(t, []string{"call"}, received[1], "calls should be balanced across instances")
+
+	svc.sendToPool("driver:web", &dipper.Message{Channel: dipper.ChannelRPC, Subject: dipper.RPCCancel, Labels: map[string]string{"caller": "-", "rpcID": "2"}})
+	assert.Equal(t, []string{"call", "cancel"}, received[1], "cancel should follow the call")
+
+	second := svc.driverRuntimes["driver:web#1"]
+	assert.Equal(t, 1, second.Busy())
+	svc.callFinished(second, &dipper.Message{Channel: dipper.ChannelRPC, Subject: "return", Labels: map[string]string{"caller": "-", "rpcID": "2"}})
+	assert.Equal(t, 0, second.Busy())
+	assert.NotContains(t, svc.pendingCalls, "-:2", "return should clear the pending call")
+
+	assert.IsType(t, &poolReceiver{}, svc.GetReceiver("driver:web"), "should balance daemon calls in pools")
+
+	svc.shrinkPool("driver:web", 1)
+	assert.Nil(t, svc.getDriverRuntime("driver:web#1"), "should stop instances beyond the pool size")
+	assert.Equal(t, driver.DriverDraining, second.State)
+}
+
+func TestServiceDrainDriverRuntime(t *testing.T) {
+	closed := make(chan struct{})
+	h := driver.NewNullDriver(&driver.Meta{Name: "web", Type: "null", DrainTimeout: "1s"})
+	h.CloseFunc = func() { close(closed) }
+	runtime := &driver.Runtime{Feature: "driver:web", Handler: h, State: driver.DriverAlive}
+	svc := &Service{name: "testdrain", driverRuntimes: map[string]*driver.Runtime{}}
+
+	runtime.CallStarted("session1")
+	svc.drainDriverRuntime(runtime)
+	assert.Equal(t, driver.DriverDraining, runtime.State)
+	svc.driverLock.Lock()
+	assert.True(t, svc.draining[runtime], "draining runtime should still receive messages")
+	svc.driverLock.Unlock()
+
+	select {
+	case <-closed:
+		assert.Fail(t, "should not close the runtime with calls in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	svc.callFinished(runtime, &dipper.Message{Channel: dipper.ChannelEventbus, Subject: dipper.EventbusReturn, Labels: map[string]string{"sessionID": "session1"}})
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "should close the runtime after the calls return")
+	}
+	time.Sleep(10 * time.Millisecond)
+	svc.driverLock.Lock()
+	assert.NotContains(t, svc.draining, runtime)
+	svc.driverLock.Unlock()
+}
+
+func TestOperatorCancelCommand(t *testing.T) {
+	runtime := &driver.Runtime{Feature: "driver:web", Handler: driver.NewNullDriver(&driver.Meta{Name: "web", Type: "null"}), State: driver.DriverAlive}
+	svc := &Service{name: "testcancel", driverRuntimes: map[string]*driver.Runtime{}}
+	saved := operator
+	operator = svc
+	defer func() { operator = saved }()
+
+	cancel := &dipper.Message{Channel: dipper.ChannelEventbus, Subject: dipper.EventbusCommand, Labels: map[string]string{"sessionID": "session1", "cancel": "true"}}
+	assert.Empty(t, operatorRoute(cancel), "should ignore the cancellation without command in flight")
+
+	svc.trackCall("session1", runtime)
+	ret := operatorRoute(cancel)
+	assert.Len(t, ret, 1, "should forward the cancellation")
+	assert.Equal(t, runtime, ret[0].driverRuntime, "should cancel with the driver running the command")
+	assert.Equal(t, dipper.EventbusCancel, ret[0].message.Subject)
+	assert.Equal(t, "session1", ret[0].message.Labels["sessionID"])
+
+	svc.callFinished(runtime, &dipper.Message{Channel: dipper.ChannelEventbus, Subject: dipper.EventbusReturn, Labels: map[string]string{"sessionID": "session1"}})
+	assert.Empty(t, operatorRoute(cancel), "should ignore the cancellation after the command returns")
+}
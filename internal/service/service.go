@@ -33,12 +33,6 @@ const (
 
 	// DriverReadyTimeout is the timeout in seconds for the driver to be ready.
 	DriverReadyTimeout time.Duration = 10
-
-	// DriverRetryBackoff is the interval in seconds before retry loading a driver.
-	DriverRetryBackoff time.Duration = 30
-
-	// DriverRetryCount is the number of attempts to load a driver.
-	DriverRetryCount = 3
 )
 
 // MessageResponder is a function type that respond to messages.
@@ -75,6 +69,16 @@ type Service struct {
 	healthy            bool
 	drainingGroup      *sync.WaitGroup
 	daemonID           string
+	streams            map[string]*dipper.StreamAssembler
+	streamLock         sync.Mutex
+	restarts           map[string]*driver.RestartStatus
+	restarting         map[string]bool
+	restartLock        sync.Mutex
+	requiredFeatures   map[string]bool
+	draining           map[*driver.Runtime]bool
+	pools              map[string]*driver.Pool
+	pendingCalls       map[string]*driver.Runtime
+	callLock           sync.Mutex
 }
 
 var (
@@ -104,6 +108,7 @@ func NewService(cfg *config.Config, name string) *Service {
 	svc.responders["state:stopped"] = []MessageResponder{handleDriverStop}
 	svc.responders["rpc:call"] = []MessageResponder{handleRPCCall}
 	svc.responders["rpc:return"] = []MessageResponder{handleRPCReturn}
+	svc.responders["rpc:cancel"] = []MessageResponder{handleRPCCall}
 	svc.responders["broadcast:reload"] = []MessageResponder{handleReload}
 	svc.responders["api:call"] = []MessageResponder{handleAPI}
 
@@ -118,9 +123,9 @@ func NewService(cfg *config.Config, name string) *Service {
 	return svc
 }
 
-// CheckHealth is a http handler for healthcheck.
+// CheckHealth checks if the service is serving, and the drivers for the required features are healthy.
 func (s *Service) CheckHealth() bool {
-	return s.healthy
+	return s.healthy && s.driversHealthy()
 }
 
 // GetName returns the name of the service.
@@ -136,7 +141,7 @@ func (s *Service) GetReceiver(feature string) interface{} {
 	}
 	receiver.Ready(DriverReadyTimeout * time.Second)
 
-	return receiver
+	return &poolReceiver{service: s, feature: feature}
 }
 
 func (s *Service) loadFeature(feature string) (affected bool, driverName string, rerr error) {
@@ -163,6 +168,30 @@ func (s *Service) loadFeature(feature string) (affected bool, driverName string,
 		dipper.Logger.Warningf("[%s] reloading feature %s", s.name, feature)
 	}
 
+	var driverRuntime *driver.Runtime
+	driverRuntime, driverName = s.newFeatureRuntime(feature, 0)
+	dipper.Logger.Infof("[%s] mapping feature %s to driver %s", s.name, feature, driverName)
+	dipper.Logger.Debugf("[%s] driver %s meta %v", s.name, driverName, driverRuntime.Handler.Meta())
+
+	size := driverRuntime.Handler.Meta().PoolSize()
+	for i := 0; i < size; i++ {
+		instance := driverRuntime
+		if i > 0 {
+			instance, _ = s.newFeatureRuntime(feature, i)
+		}
+		if s.loadInstance(instance) {
+			affected = true
+		}
+	}
+	s.shrinkPool(feature, size)
+
+	return affected, driverName, nil
+}
+
+// newFeatureRuntime creates the runtime for the instance of the driver mapped to the feature in the
+// staged config, panics if the driver is not defined.
+func (s *Service) newFeatureRuntime(feature string, instance int) (*driver.Runtime, string) {
+	var driverName string
 	var ok bool
 	if strings.HasPrefix(feature, "driver:") {
 		driverName = feature[7:]
@@ -175,7 +204,6 @@ func (s *Service) loadFeature(feature string) (affected bool, driverName string,
 			panic("driver not defined for the feature")
 		}
 	}
-	dipper.Logger.Infof("[%s] mapping feature %s to driver %s", s.name, feature, driverName)
 
 	driverData, _ := s.config.GetStagedDriverData(driverName)
 	var dynamicData interface{}
@@ -189,26 +217,62 @@ func (s *Service) loadFeature(feature string) (affected bool, driverName string,
 	}
 
 	driverRuntime := driver.NewDriver(feature, driverMeta.(map[string]interface{}), driverData, dynamicData)
-	dipper.Logger.Debugf("[%s] driver %s meta %v", s.name, driverName, driverRuntime.Handler.Meta())
+	driverRuntime.Instance = instance
+
+	return driverRuntime, driverName
+}
+
+// reloadInstance reloads the instance of the driver for the feature, leaving the other instances in
+// the pool running.  Returns the reloaded runtime, or nil if the instance is no longer in the pool or
+// is not affected.
+func (s *Service) reloadInstance(feature string, instance int) (reloaded *driver.Runtime, rerr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			dipper.Logger.Warningf("[%s] failed to reload instance %d of feature %s: %v", s.name, instance, feature, r)
+			if err, ok := r.(error); ok {
+				rerr = err
+			} else {
+				rerr = fmt.Errorf("%w: %+v", ErrServiceError, r)
+			}
+		}
+	}()
+
+	driverRuntime, _ := s.newFeatureRuntime(feature, instance)
+	if instance >= driverRuntime.Handler.Meta().PoolSize() {
+		dipper.Logger.Warningf("[%s] instance %d of feature %s is no longer in the pool", s.name, instance, feature)
+
+		return nil, nil
+	}
+	if !s.loadInstance(driverRuntime) {
+		return nil, nil
+	}
+
+	return s.getDriverRuntime(driverRuntime.Key()), nil
+}
 
+// loadInstance replaces the running instance of the driver with the loaded one, returns true if
+// the instance is reloaded.
+func (s *Service) loadInstance(driverRuntime *driver.Runtime) bool {
+	oldRuntime := s.getDriverRuntime(driverRuntime.Key())
 	driverMetaUnchanged := oldRuntime != nil && reflect.DeepEqual(*oldRuntime.Handler.Meta(), *driverRuntime.Handler.Meta())
 	driverRunning := oldRuntime != nil && oldRuntime.State != driver.DriverFailed
 
 	if driverRunning && driverMetaUnchanged {
 		if reflect.DeepEqual(oldRuntime.Data, driverRuntime.Data) && reflect.DeepEqual(oldRuntime.DynamicData, driverRuntime.DynamicData) {
-			dipper.Logger.Infof("[%s] driver not affected: %s", s.name, driverName)
-		} else {
-			// hot reload
-			affected = true
-			s.hotReload(driverRuntime, oldRuntime)
+			dipper.Logger.Infof("[%s] driver not affected: %s", s.name, driverRuntime.Name())
+
+			return false
 		}
-	} else {
-		// cold reload
-		affected = true
-		s.coldReload(driverRuntime, oldRuntime)
+		// hot reload
+		s.hotReload(driverRuntime, oldRuntime)
+
+		return true
 	}
 
-	return affected, driverName, nil
+	// cold reload
+	s.coldReload(driverRuntime, oldRuntime)
+
+	return true
 }
 
 func (s *Service) hotReload(driverRuntime *driver.Runtime, oldRuntime *driver.Runtime) {
@@ -218,30 +282,55 @@ func (s *Service) hotReload(driverRuntime *driver.Runtime, oldRuntime *driver.Ru
 	oldRuntime.SendOptions()
 }
 
+// expectAlive expects the instances of the driver being loaded for the feature to report alive, the
+// failed function is called for each instance failing to start.
+func (s *Service) expectAlive(feature string, failed func(*driver.Runtime)) {
+	for _, runtime := range s.getDriverPool(feature) {
+		if runtime.State != driver.DriverLoading && runtime.State != driver.DriverReloading {
+			continue
+		}
+		func(runtime *driver.Runtime) {
+			s.expectInstanceAlive(runtime, nil, func() {
+				failed(runtime)
+			})
+		}(runtime)
+	}
+}
+
+// expectInstanceAlive expects the instance of the driver to report alive, calling the alive function
+// once it is marked alive, or the failed function if it fails to start.
+func (s *Service) expectInstanceAlive(runtime *driver.Runtime, alive func(), failed func()) {
+	s.addExpect(
+		"state:alive:"+runtime.Name(),
+		func(msg *dipper.Message) {
+			if s.markDriverAlive(runtime.Key(), msg, failed) && alive != nil {
+				alive()
+			}
+		},
+		DriverReadyTimeout*time.Second,
+		failed,
+	)
+}
+
 func (s *Service) coldReload(driverRuntime *driver.Runtime, oldRuntime *driver.Runtime) {
 	driverRuntime.Start(s.name)
 
-	s.setDriverRuntime(driverRuntime.Feature, driverRuntime)
+	s.setDriverRuntime(driverRuntime.Key(), driverRuntime)
 	go func(s *Service, runtime *driver.Runtime) {
-		defer dipper.SafeExitOnError("[%s] driver runtime %s crash", s.name, runtime.Handler.Meta().Name)
-		defer s.checkDeleteDriverRuntime(runtime.Feature, runtime)
+		defer dipper.SafeExitOnError("[%s] driver runtime %s crash", s.name, runtime.Name())
+		defer s.checkDeleteDriverRuntime(runtime.Key(), runtime)
 		defer runtime.Handler.Close()
 
-		runtime.Handler.Wait()
+		runtime.Wait()
 	}(s, driverRuntime)
 
 	if oldRuntime != nil {
-		s.checkDeleteDriverRuntime(driverRuntime.Feature, nil)
-		if driverRuntime.Feature == FeatureEmitter {
+		s.checkDeleteDriverRuntime(driverRuntime.Key(), nil)
+		if driverRuntime.Key() == FeatureEmitter {
 			// emitter is being replaced
 			delete(daemon.Emitters, s.name)
 		}
-		go func(runtime *driver.Runtime) {
-			defer dipper.SafeExitOnError("[%s] runtime %s being replaced output is already closed", s.name, runtime.Handler.Meta().Name)
-			// allow 50 millisecond for the data to drain
-			time.Sleep(DriverGracefulTimeout * time.Millisecond)
-			runtime.Handler.Close()
-		}(oldRuntime)
+		s.drainDriverRuntime(oldRuntime)
 	}
 }
 
@@ -259,6 +348,7 @@ func (s *Service) start() {
 		if s.ServiceReload != nil {
 			s.ServiceReload(s.config)
 		}
+		s.setRequiredFeatures(featureList)
 		s.healthy = true
 		if !s.config.IsJobMode {
 			go s.metricsLoop()
@@ -290,6 +380,7 @@ func (s *Service) Reload() {
 	if s.ServiceReload != nil {
 		s.ServiceReload(s.config)
 	}
+	s.setRequiredFeatures(featureList)
 	s.healthy = true
 	s.removeUnusedFeatures(featureList)
 }
@@ -314,19 +405,19 @@ func (s *Service) getFeatureList() map[string]bool {
 }
 
 func (s *Service) removeUnusedFeatures(featureList map[string]bool) {
-	for feature, runtime := range s.driverRuntimes {
+	for key, runtime := range s.driverRuntimes {
+		feature := key
+		if runtime.Instance > 0 {
+			// additional instances in the driver pool
+			feature = runtime.Feature
+		}
 		if _, ok := featureList[feature]; !ok {
-			if feature == FeatureEmitter {
+			if key == FeatureEmitter {
 				// emitter is removed
 				delete(daemon.Emitters, s.name)
 			}
-			s.checkDeleteDriverRuntime(feature, nil)
-			go func(runtime *driver.Runtime) {
-				defer dipper.SafeExitOnError("[%s] unused runtime %s output is already closed", s.name, runtime.Handler.Meta().Name)
-				// allow 50 millisecond for the data to drain
-				time.Sleep(DriverGracefulTimeout * time.Millisecond)
-				runtime.Handler.Close()
-			}(runtime)
+			s.checkDeleteDriverRuntime(key, nil)
+			s.drainDriverRuntime(runtime)
 		}
 	}
 }
@@ -349,28 +440,16 @@ func (s *Service) loadRequiredFeatures(featureList map[string]bool, boot bool) {
 		}
 
 		// expecting the driver to ping back then send options
-		func(feature string, driverName string) {
-			s.addExpect(
-				"state:alive:"+driverName,
-				func(*dipper.Message) {
-					s.driverRuntimes[feature].State = driver.DriverAlive
-					if feature == FeatureEmitter {
-						// emitter is loaded
-						daemon.Emitters[s.name] = s
-					}
-				},
-				DriverReadyTimeout*time.Second,
-				func() {
-					if boot {
-						dipper.Logger.Fatalf("failed to start driver %s.%s", s.name, driverName)
-					} else {
-						dipper.Logger.Warningf("failed to reload driver %s.%s", s.name, driverName)
-						s.driverRuntimes[feature].State = driver.DriverFailed
-						s.config.RollBack()
-					}
-				},
-			)
-		}(feature, driverName)
+		rollback := sync.OnceFunc(s.config.RollBack)
+		s.expectAlive(feature, func(runtime *driver.Runtime) {
+			if boot {
+				dipper.Logger.Fatalf("failed to start driver %s.%s", s.name, driverName)
+			} else {
+				dipper.Logger.Warningf("failed to reload driver %s.%s", s.name, runtime.Name())
+				runtime.State = driver.DriverFailed
+				rollback()
+			}
+		})
 	}
 }
 
@@ -385,33 +464,46 @@ func (s *Service) loadAdditionalFeatures(featureList map[string]bool) {
 
 	for feature, required := range featureList {
 		if !required {
-			affected, driverName, err := s.loadFeature(feature)
+			affected, _, err := s.loadFeature(feature)
 			if err != nil {
 				dipper.Logger.Warningf("[%s] skip feature %s error %v", s.name, feature, err)
 			}
 			if affected {
-				func(feature string, driverName string) {
-					s.addExpect(
-						"state:alive:"+driverName,
-						func(*dipper.Message) {
-							s.driverRuntimes[feature].State = driver.DriverAlive
-							if feature == FeatureEmitter {
-								// emitter is loaded
-								daemon.Emitters[s.name] = s
-							}
-						},
-						DriverReadyTimeout*time.Second,
-						func() {
-							dipper.Logger.Warningf("[%s] failed to start or reload driver %s", s.name, driverName)
-							s.driverRuntimes[feature].State = driver.DriverFailed
-						},
-					)
-				}(feature, driverName)
+				s.expectAlive(feature, func(runtime *driver.Runtime) {
+					dipper.Logger.Warningf("[%s] failed to start or reload driver %s", s.name, runtime.Name())
+					runtime.State = driver.DriverFailed
+				})
 			}
 		}
 	}
 }
 
+// markDriverAlive records the capabilities advertised by the driver when it becomes alive, drivers
+// speaking an unsupported protocol are closed and handled as failed to start.  Returns true if the
+// driver is marked alive.
+func (s *Service) markDriverAlive(feature string, msg *dipper.Message, failed func()) bool {
+	runtime := s.getDriverRuntime(feature)
+	if err := runtime.SetCapabilities(msg); err != nil {
+		dipper.Logger.Warningf("[%s] refusing driver for feature %s: %v", s.name, feature, err)
+		runtime.State = driver.DriverFailed
+		runtime.Handler.Close()
+		failed()
+
+		return false
+	}
+
+	runtime.State = driver.DriverAlive
+	if feature == FeatureEmitter {
+		// emitter is loaded
+		daemon.Emitters[s.name] = s
+	}
+	runtime.Heartbeat(func() {
+		s.killHungDriver(runtime)
+	})
+
+	return true
+}
+
 func (s *Service) serviceLoop() {
 	daemon.Children.Add(1)
 	defer daemon.Children.Done()
@@ -433,6 +525,14 @@ func (s *Service) serviceLoop() {
 					orderedRuntimes = append(orderedRuntimes, runtime)
 				}
 			}
+			// keep receiving the returns from the runtimes being drained
+			for runtime := range s.draining {
+				cases = append(cases, reflect.SelectCase{
+					Dir:  reflect.SelectRecv,
+					Chan: reflect.ValueOf(runtime.Stream),
+				})
+				orderedRuntimes = append(orderedRuntimes, runtime)
+			}
 		}()
 		tick := time.NewTimer(time.Second)
 		defer tick.Stop()
@@ -470,6 +570,13 @@ func (s *Service) serviceLoop() {
 					}
 				}
 
+				if dipper.IsStream(msg) {
+					// keep the stream messages in order
+					s.handleStream(runtime, msg)
+
+					return
+				}
+
 				s.driverLock.Lock()
 				defer s.driverLock.Unlock()
 				go s.process(*msg, runtime)
@@ -478,13 +585,13 @@ func (s *Service) serviceLoop() {
 		case !ok && chosen < len(orderedRuntimes):
 			// selected driver crashed
 
-			if orderedRuntimes[chosen].Feature == FeatureEmitter {
+			if orderedRuntimes[chosen].Key() == FeatureEmitter {
 				// emitter has crashed
 				delete(daemon.Emitters, s.name)
 			}
 			if d := orderedRuntimes[chosen]; d.State == driver.DriverAlive {
-				// only reload drivers that used to be in DriveAlive state
-				go loadFailedDriverRuntime(orderedRuntimes[chosen], 0)
+				// only restart drivers that used to be in DriveAlive state
+				go restartDriverRuntime(orderedRuntimes[chosen])
 			}
 		}
 	}
@@ -502,7 +609,21 @@ func (s *Service) serviceLoop() {
 
 func (s *Service) process(msg dipper.Message, runtime *driver.Runtime) {
 	defer dipper.SafeExitOnError("[%s] continue  message loop", s.name)
-	expectKey := fmt.Sprintf("%s:%s:%s", msg.Channel, msg.Subject, runtime.Handler.Meta().Name)
+	if msg.Channel == dipper.ChannelState {
+		if msg.Subject == dipper.StatePong {
+			runtime.Pong()
+
+			return
+		}
+		if runtime.LegacyPong() {
+			// older drivers reply to ping with their state
+			runtime.Pong()
+		}
+	}
+	if isCallReturn(&msg) {
+		s.callFinished(runtime, &msg)
+	}
+	expectKey := fmt.Sprintf("%s:%s:%s", msg.Channel, msg.Subject, runtime.Name())
 	if expects, ok := s.deleteExpect(expectKey); ok {
 		for _, f := range expects {
 			go func(f ExpectHandler) {
@@ -549,6 +670,56 @@ func (s *Service) process(msg dipper.Message, runtime *driver.Runtime) {
 	}(&msg)
 }
 
+// handleStream passes the RPC return streams through to the callers, other streams are
+// assembled into complete messages before being processed.
+func (s *Service) handleStream(runtime *driver.Runtime, msg *dipper.Message) {
+	if msg.Channel == dipper.ChannelRPC && msg.Subject == "return" {
+		if msg.Labels["stream"] == dipper.StreamClose {
+			s.callFinished(runtime, msg)
+		}
+		caller := msg.Labels["caller"]
+		if caller == "-" {
+			s.HandleReturn(msg)
+		} else if callerRuntime := s.getDriverRuntime(caller); callerRuntime != nil {
+			callerRuntime.SendMessage(msg)
+		}
+
+		return
+	}
+
+	if assembled := s.getStreamAssembler(runtime).Add(msg); assembled != nil {
+		go s.process(*assembled, runtime)
+	}
+}
+
+// getStreamAssembler returns the assembler for the streams from the driver instance.  The dropped
+// return streams are processed as returns with error, so the sessions waiting for them can complete.
+func (s *Service) getStreamAssembler(runtime *driver.Runtime) *dipper.StreamAssembler {
+	key := runtime.Key()
+
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+	if s.streams == nil {
+		s.streams = map[string]*dipper.StreamAssembler{}
+	}
+	assembler, ok := s.streams[key]
+	if !ok {
+		assembler = &dipper.StreamAssembler{
+			OnDrop: func(msg *dipper.Message) {
+				if msg.Channel != dipper.ChannelEventbus || msg.Subject != dipper.EventbusReturn {
+					return
+				}
+				if current := s.getDriverRuntime(key); current != nil {
+					go s.process(*msg, current)
+				}
+			},
+		}
+		s.streams[key] = assembler
+	}
+
+	return assembler
+}
+
 func (s *Service) addResponder(channelSubject string, f MessageResponder) {
 	s.responders[channelSubject] = append(s.responders[channelSubject], f)
 }
@@ -624,56 +795,16 @@ func (s *Service) checkDeleteDriverRuntime(feature string, check *driver.Runtime
 
 func coldReloadDriverRuntime(d *driver.Runtime, m *dipper.Message) {
 	s := Services[d.Service]
-	s.checkDeleteDriverRuntime(d.Feature, d)
-	d.Handler.Close()
+	s.checkDeleteDriverRuntime(d.Key(), d)
+	s.drainDriverRuntime(d)
 	dipper.Must(s.loadFeature(d.Feature))
 }
 
-func loadFailedDriverRuntime(d *driver.Runtime, count int) {
-	s := Services[d.Service]
-	d.State = driver.DriverFailed
-	driverName := d.Handler.Meta().Name
-	if emitter, ok := daemon.Emitters[s.name]; ok {
-		emitter.CounterIncr("honey.honeydipper.driver.recovery_attempt", []string{
-			"service:" + s.name,
-			"driver:" + driverName,
-		})
-	}
-
-	dipper.Logger.Warningf("[%s] start loading/reloading driver %s", s.name, driverName)
-	retry := func() {
-		dipper.Logger.Warningf("[%s] failed to load/reload driver %s attempt %d", s.name, driverName, count)
-		if count < DriverRetryCount {
-			time.Sleep(DriverRetryBackoff * time.Second)
-			go loadFailedDriverRuntime(d, count+1)
-		} else {
-			dipper.Logger.Fatalf("[%s] quiting after failed to reload crashed driver %s", s.name, driverName)
-		}
-	}
-	_, _, err := s.loadFeature(d.Feature)
-	if err != nil {
-		retry()
-	} else {
-		s.addExpect(
-			"state:alive:"+driverName,
-			func(*dipper.Message) {
-				s.driverRuntimes[d.Feature].State = driver.DriverAlive
-				if d.Feature == FeatureEmitter {
-					// emitter is loaded
-					daemon.Emitters[s.name] = s
-				}
-			},
-			DriverReadyTimeout*time.Second,
-			retry,
-		)
-	}
-}
-
 func handleRPCCall(from *driver.Runtime, m *dipper.Message) {
 	feature := m.Labels["feature"]
-	m.Labels["caller"] = from.Feature
+	m.Labels["caller"] = from.Key()
 	s := Services[from.Service]
-	s.getDriverRuntime(feature).SendMessage(m)
+	s.sendToPool(feature, m)
 }
 
 func handleRPCReturn(from *driver.Runtime, m *dipper.Message) {
@@ -791,6 +922,7 @@ func (s *Service) metricsLoop() {
 					"service:" + s.name,
 					"state:failed",
 				})
+				s.emitRestartMetrics()
 			}
 			if s.EmitMetrics != nil {
 				s.EmitMetrics()
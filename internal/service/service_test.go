@@ -412,3 +412,47 @@ func TestServiceReplaceEmitter(t *testing.T) {
 
 	daemon.ShutDown()
 }
+
+func TestServiceStreamDropped(t *testing.T) {
+	runtime := &driver.Runtime{
+		Feature: "d1",
+		Handler: driver.NewNullDriver(&driver.Meta{Name: "d1"}),
+		State:   driver.DriverAlive,
+	}
+	returns := make(chan *dipper.Message, 2)
+	svc := &Service{
+		name:           "testsvc",
+		driverRuntimes: map[string]*driver.Runtime{"d1": runtime},
+		expects:        map[string][]ExpectHandler{},
+		responders: map[string][]MessageResponder{
+			"eventbus:return":  {func(d *driver.Runtime, m *dipper.Message) { returns <- m }},
+			"eventbus:message": {func(d *driver.Runtime, m *dipper.Message) { returns <- m }},
+		},
+	}
+	svc.getStreamAssembler(runtime).MaxSize = 4
+
+	for _, subject := range []string{"message", "return"} {
+		svc.handleStream(runtime, &dipper.Message{
+			Channel: "eventbus",
+			Subject: subject,
+			Labels:  map[string]string{"stream": dipper.StreamOpen, "streamID": subject, "sessionID": "s1"},
+		})
+		svc.handleStream(runtime, &dipper.Message{
+			Channel: "eventbus",
+			Subject: subject,
+			Labels:  map[string]string{"stream": dipper.StreamChunk, "streamID": subject},
+			Payload: []byte("hello"),
+		})
+	}
+
+	select {
+	case ret := <-returns:
+		assert.Equal(t, "return", ret.Subject, "should only return errors for the dropped returns")
+		assert.Equal(t, "s1", ret.Labels["sessionID"], "should return to the session")
+		assert.Equal(t, dipper.ERROR, ret.Labels["status"])
+	case <-time.After(time.Second):
+		assert.Fail(t, "should return error for the dropped stream")
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, returns, "should not process the dropped messages")
+}
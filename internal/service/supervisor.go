@@ -0,0 +1,244 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// restartStatus returns the restart status of the driver for the feature.
+func (s *Service) restartStatus(feature string) *driver.RestartStatus {
+	s.restartLock.Lock()
+	defer s.restartLock.Unlock()
+
+	if s.restarts == nil {
+		s.restarts = map[string]*driver.RestartStatus{}
+	}
+	status, ok := s.restarts[feature]
+	if !ok {
+		status = &driver.RestartStatus{}
+		s.restarts[feature] = status
+	}
+
+	return status
+}
+
+// beginRestart marks the driver instance as restarting, returns false if the instance is already
+// being restarted.
+func (s *Service) beginRestart(key string) bool {
+	s.restartLock.Lock()
+	defer s.restartLock.Unlock()
+
+	if s.restarting == nil {
+		s.restarting = map[string]bool{}
+	}
+	if s.restarting[key] {
+		return false
+	}
+	s.restarting[key] = true
+
+	return true
+}
+
+// endRestart marks the restart of the driver instance as finished.
+func (s *Service) endRestart(key string) {
+	s.restartLock.Lock()
+	defer s.restartLock.Unlock()
+
+	delete(s.restarting, key)
+}
+
+// restartDriverRuntime handles a crashed driver according to the restart policy in the driver meta.
+func restartDriverRuntime(d *driver.Runtime) {
+	s := Services[d.Service]
+	d.State = driver.DriverFailed
+	meta := d.Handler.Meta()
+
+	s.restartStatus(d.Key()).Exited(d.ExitCode, time.Now())
+	if emitter, ok := daemon.Emitters[s.name]; ok {
+		emitter.CounterIncr("honey.honeydipper.driver.exit", []string{
+			"service:" + s.name,
+			"driver:" + meta.Name,
+			"exit_code:" + strconv.Itoa(d.ExitCode),
+		})
+	}
+
+	if !meta.Restart.ShouldRestart(d.ExitCode) {
+		dipper.Logger.Warningf("[%s] driver %s exited with %d, not restarting with policy %s", s.name, meta.Name, d.ExitCode, meta.Restart.Policy)
+
+		return
+	}
+	if !s.beginRestart(d.Key()) {
+		dipper.Logger.Infof("[%s] driver %s is already being restarted", s.name, d.Name())
+
+		return
+	}
+	s.scheduleRestart(d)
+}
+
+// scheduleRestart restarts the driver instance after the backoff delay, and keeps retrying until the
+// instance is alive or the restarts allowed in the reset window are exhausted.  Only the crashed
+// instance is restarted, the other instances in the pool keep running.
+func (s *Service) scheduleRestart(d *driver.Runtime) {
+	meta := d.Handler.Meta()
+	status := s.restartStatus(d.Key())
+
+	delay, ok := status.Next(meta.Restart, time.Now())
+	if !ok {
+		if emitter, ok := daemon.Emitters[s.name]; ok {
+			emitter.CounterIncr("honey.honeydipper.driver.gave_up", []string{
+				"service:" + s.name,
+				"driver:" + meta.Name,
+			})
+		}
+		s.endRestart(d.Key())
+		if s.getFeatureList()[d.Feature] {
+			dipper.Logger.Fatalf("[%s] quiting after failed to restart required driver %s", s.name, meta.Name)
+		}
+		dipper.Logger.Errorf("[%s] giving up restarting driver %s, restarted too many times", s.name, meta.Name)
+
+		return
+	}
+
+	if emitter, ok := daemon.Emitters[s.name]; ok {
+		emitter.CounterIncr("honey.honeydipper.driver.recovery_attempt", []string{
+			"service:" + s.name,
+			"driver:" + meta.Name,
+		})
+	}
+	dipper.Logger.Warningf("[%s] restarting driver %s in %s", s.name, meta.Name, delay)
+	time.Sleep(delay)
+
+	retry := func() {
+		dipper.Logger.Warningf("[%s] failed to restart driver %s", s.name, d.Name())
+		go s.scheduleRestart(d)
+	}
+	runtime, err := s.reloadInstance(d.Feature, d.Instance)
+	if err != nil {
+		retry()
+
+		return
+	}
+	if runtime == nil || (runtime.State != driver.DriverLoading && runtime.State != driver.DriverReloading) {
+		s.endRestart(d.Key())
+
+		return
+	}
+	s.expectInstanceAlive(runtime, func() {
+		s.endRestart(d.Key())
+	}, retry)
+}
+
+// killHungDriver kills the driver not answering the pings, to be restarted by the restart policy.
+func (s *Service) killHungDriver(d *driver.Runtime) {
+	meta := d.Handler.Meta()
+	if emitter, ok := daemon.Emitters[s.name]; ok {
+		emitter.CounterIncr("honey.honeydipper.driver.hung", []string{
+			"service:" + s.name,
+			"driver:" + meta.Name,
+		})
+	}
+	dipper.Logger.Warningf("[%s] killing hung driver %s", s.name, meta.Name)
+	d.Kill()
+}
+
+// CheckHealth checks the health of all the services in the daemon.
+func CheckHealth() bool {
+	for _, s := range Services {
+		if !s.CheckHealth() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setRequiredFeatures records the features checked for the health of the service.
+func (s *Service) setRequiredFeatures(featureList map[string]bool) {
+	s.driverLock.Lock()
+	defer s.driverLock.Unlock()
+	s.requiredFeatures = featureList
+}
+
+// driversHealthy checks at least one instance of the driver for each required feature is alive and
+// answering the pings.
+func (s *Service) driversHealthy() bool {
+	s.driverLock.Lock()
+	required := make([]string, 0, len(s.requiredFeatures))
+	for feature, isRequired := range s.requiredFeatures {
+		if isRequired {
+			required = append(required, feature)
+		}
+	}
+	s.driverLock.Unlock()
+
+	for _, feature := range required {
+		healthy := false
+		for _, runtime := range s.getDriverPool(feature) {
+			healthy = healthy || runtime.Healthy()
+		}
+		if !healthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// emitRestartMetrics sets the gauges for the restarts of the drivers in the reset window.
+func (s *Service) emitRestartMetrics() {
+	s.restartLock.Lock()
+	defer s.restartLock.Unlock()
+
+	for feature, status := range s.restarts {
+		s.GaugeSet("honey.honeydipper.driver.restarts", strconv.Itoa(status.Snapshot()["restarts"].(int)), []string{
+			"service:" + s.name,
+			"feature:" + feature,
+		})
+	}
+}
+
+// DriverStatus returns the state and the restart status of the drivers in the service.
+func (s *Service) DriverStatus() []interface{} {
+	s.driverLock.Lock()
+	runtimes := make(map[string]*driver.Runtime, len(s.driverRuntimes))
+	for key, runtime := range s.driverRuntimes {
+		runtimes[key] = runtime
+	}
+	s.driverLock.Unlock()
+
+	ret := []interface{}{}
+	for key, runtime := range runtimes {
+		status := s.restartStatus(key).Snapshot()
+		status["service"] = s.name
+		status["feature"] = runtime.Feature
+		status["instance"] = runtime.Instance
+		status["busy"] = runtime.Busy()
+		status["driver"] = runtime.Handler.Meta().Name
+		status["state"] = driverStateNames[runtime.State]
+		for k, v := range runtime.HealthStatus() {
+			status[k] = v
+		}
+		ret = append(ret, status)
+	}
+
+	return ret
+}
+
+var driverStateNames = map[int]string{
+	driver.DriverLoading:   "loading",
+	driver.DriverReloading: "reloading",
+	driver.DriverAlive:     "alive",
+	driver.DriverFailed:    "failed",
+	driver.DriverStopped:   "stopped",
+	driver.DriverDraining:  "draining",
+}
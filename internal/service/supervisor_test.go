@@ -0,0 +1,146 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/driver"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartDriverRuntimeNever(t *testing.T) {
+	if dipper.Logger == nil {
+		f, _ := os.OpenFile(os.DevNull, os.O_APPEND, 0o777)
+		defer f.Close()
+		dipper.GetLogger("test service", "DEBUG", f, f)
+	}
+
+	runtime := &driver.Runtime{
+		Feature: "driver:d1",
+		Service: "testsupervisor",
+		Handler: driver.NewNullDriver(&driver.Meta{
+			Name:    "d1",
+			Type:    "null",
+			Restart: driver.RestartPolicy{Policy: driver.RestartNever},
+		}),
+		State:    driver.DriverAlive,
+		ExitCode: 2,
+	}
+	svc := &Service{
+		name: "testsupervisor",
+		driverRuntimes: map[string]*driver.Runtime{
+			"driver:d1": runtime,
+		},
+	}
+	Services["testsupervisor"] = svc
+	defer delete(Services, "testsupervisor")
+
+	assert.NotPanics(t, func() { restartDriverRuntime(runtime) }, "should not restart with never policy")
+	assert.Equal(t, driver.DriverFailed, runtime.State)
+
+	status := svc.DriverStatus()
+	assert.Len(t, status, 1)
+	assert.Equal(t, "failed", status[0].(map[string]interface{})["state"])
+	assert.Equal(t, "d1", status[0].(map[string]interface{})["driver"])
+	assert.Equal(t, 2, status[0].(map[string]interface{})["lastExitCode"])
+	assert.Equal(t, 0, status[0].(map[string]interface{})["totalRestarts"])
+}
+
+func TestRestartDriverInstance(t *testing.T) {
+	if dipper.Logger == nil {
+		f, _ := os.OpenFile(os.DevNull, os.O_APPEND, 0o777)
+		defer f.Close()
+		dipper.GetLogger("test service", "DEBUG", f, f)
+	}
+
+	meta := &driver.Meta{
+		Name:      "d1",
+		Type:      "null",
+		Instances: 3,
+		Restart:   driver.RestartPolicy{Policy: driver.RestartAlways},
+	}
+	svc := &Service{
+		name:           "testrestartinstance",
+		driverRuntimes: map[string]*driver.Runtime{},
+		expects:        map[string][]ExpectHandler{},
+		config: &config.Config{
+			Staged: &config.DataSet{
+				Drivers: map[string]interface{}{
+					"daemon": map[string]interface{}{
+						"drivers": map[string]interface{}{
+							"d1": map[string]interface{}{"name": "d1", "type": "null", "instances": 3},
+						},
+					},
+				},
+			},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		runtime := &driver.Runtime{Feature: "driver:d1", Service: svc.name, Instance: i, Handler: driver.NewNullDriver(meta), State: driver.DriverAlive}
+		svc.driverRuntimes[runtime.Key()] = runtime
+	}
+	Services[svc.name] = svc
+	defer delete(Services, svc.name)
+
+	crashed := svc.driverRuntimes["driver:d1#1"]
+	assert.True(t, svc.beginRestart(crashed.Key()))
+	restartDriverRuntime(crashed)
+	assert.Equal(t, 0, svc.restartStatus(crashed.Key()).TotalRestarts, "should not start another restart for the instance being restarted")
+	assert.False(t, svc.beginRestart(crashed.Key()))
+	assert.True(t, svc.beginRestart("driver:d1#2"), "should restart other instances independently")
+	svc.endRestart("driver:d1#2")
+
+	first := svc.driverRuntimes["driver:d1"]
+	third := svc.driverRuntimes["driver:d1#2"]
+	assert.Equal(t, driver.DriverFailed, crashed.State)
+	reloaded, err := svc.reloadInstance("driver:d1", 1)
+	assert.NoError(t, err)
+	assert.NotNil(t, reloaded, "should reload the crashed instance")
+	assert.NotSame(t, crashed, reloaded)
+	assert.Equal(t, 1, reloaded.Instance)
+	assert.Same(t, first, svc.getDriverRuntime("driver:d1"), "should keep the other instances running")
+	assert.Same(t, third, svc.getDriverRuntime("driver:d1#2"), "should keep the other instances running")
+	assert.Equal(t, driver.DriverAlive, first.State)
+
+	reloaded, err = svc.reloadInstance("driver:d1", 3)
+	assert.NoError(t, err)
+	assert.Nil(t, reloaded, "should not restart instances no longer in the pool")
+}
+
+func TestServiceCheckHealth(t *testing.T) {
+	required := &driver.Runtime{
+		Feature: "eventbus",
+		Handler: driver.NewNullDriver(&driver.Meta{Name: "redisqueue", Type: "null"}),
+		State:   driver.DriverAlive,
+	}
+	optional := &driver.Runtime{
+		Feature: "driver:d1",
+		Handler: driver.NewNullDriver(&driver.Meta{Name: "d1", Type: "null"}),
+		State:   driver.DriverFailed,
+	}
+	svc := &Service{
+		name: "testhealth",
+		driverRuntimes: map[string]*driver.Runtime{
+			"eventbus":  required,
+			"driver:d1": optional,
+		},
+	}
+	svc.setRequiredFeatures(map[string]bool{"eventbus": true, "driver:d1": false})
+
+	assert.False(t, svc.CheckHealth(), "service should be unhealthy before serving")
+	svc.healthy = true
+	assert.True(t, svc.CheckHealth(), "failed optional driver should not affect health")
+	required.State = driver.DriverFailed
+	assert.False(t, svc.CheckHealth(), "failed required driver should make service unhealthy")
+}
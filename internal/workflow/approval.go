@@ -0,0 +1,154 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"golang.org/x/exp/slices"
+)
+
+var (
+	// ErrApprovalNotFound means the approval is not pending in this engine.
+	ErrApprovalNotFound = errors.New("approval not found")
+	// ErrNotApprover means the subject is not allowed to decide the approval.
+	ErrNotApprover = errors.New("not an approver")
+)
+
+// decisions of the approvals.
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// startApproval suspends the session until the approval is decided through the API.
+func (w *Session) startApproval() {
+	approvalID := dipper.NewUUID()
+	w.store.suspend(approvalID, w.ID)
+	w.resumeToken = approvalID
+	w.checkpoint(nil)
+	dipper.Logger.Infof("[workflow] session [%s] waiting for approval %s", w.ID, approvalID)
+}
+
+// interpolateApproval creates a copy of the approval interpolated with envData.
+func interpolateApproval(a *config.Approval, envData map[string]interface{}) *config.Approval {
+	if a == nil {
+		return nil
+	}
+
+	return &config.Approval{
+		Approvers:   dipper.Interpolate(a.Approvers, envData).([]string),
+		Groups:      dipper.Interpolate(a.Groups, envData).([]string),
+		Description: dipper.InterpolateStr(a.Description, envData),
+	}
+}
+
+// getApproval returns the session waiting for the approval.
+func (s *SessionStore) getApproval(approvalID string) (*Session, error) {
+	if sessionID, ok := s.suspended(approvalID); ok {
+		if w, ok := dipper.IDMapGet(&s.sessions, sessionID).(*Session); ok && w.workflow.Approval != nil {
+			return w, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrApprovalNotFound, approvalID)
+}
+
+// ListApprovals returns the pending approvals.
+func (s *SessionStore) ListApprovals() []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	for _, approvalID := range s.suspendedKeys() {
+		w, err := s.getApproval(approvalID)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, map[string]interface{}{
+			"approvalID":  approvalID,
+			"approvers":   w.workflow.Approval.Approvers,
+			"groups":      w.workflow.Approval.Groups,
+			"description": w.workflow.Approval.Description,
+			"eventID":     w.EventID,
+			"event":       w.GetEventName(),
+			"requestTime": w.startTime.Format(time.RFC3339),
+		})
+	}
+
+	return ret
+}
+
+// DecideApproval approves or rejects the approval on behalf of the subject with the roles, the
+// session resumes with the decision in the `approval` context data.  A rejected approval fails the
+// session.  The approval is taken under the lock of the session, so it is only decided once.
+func (s *SessionStore) DecideApproval(approvalID string, approved bool, subject string, roles []string, comment string) (map[string]interface{}, error) {
+	w, err := s.getApproval(approvalID)
+	if err != nil {
+		return nil, err
+	}
+	if !canApprove(w.workflow.Approval, subject, roles) {
+		return nil, fmt.Errorf("%w: %s for %s", ErrNotApprover, subject, approvalID)
+	}
+
+	decision := map[string]interface{}{
+		"approvalID": approvalID,
+		"decision":   ApprovalApproved,
+		"by":         subject,
+		"comment":    comment,
+	}
+	labels := map[string]string{"status": SessionStatusSuccess}
+	if !approved {
+		decision["decision"] = ApprovalRejected
+		labels = map[string]string{
+			"status": SessionStatusFailure,
+			"reason": fmt.Sprintf("rejected by %s: %s", subject, comment),
+		}
+	}
+
+	w.execLock.Lock()
+	if !s.unsuspend(approvalID, w.ID) {
+		w.execLock.Unlock()
+
+		return nil, fmt.Errorf("%w: %s", ErrApprovalNotFound, approvalID)
+	}
+	w.mergeContext([]map[string]interface{}{{"approval": decision}})
+	w.execLock.Unlock()
+	dipper.Logger.Infof("[workflow] approval %s %s by %s", approvalID, decision["decision"], subject)
+
+	daemon.Children.Add(1)
+	go func() {
+		defer daemon.Children.Done()
+		s.ContinueSession(w.ID, &dipper.Message{
+			Channel: dipper.ChannelEventbus,
+			Subject: dipper.EventbusReturn,
+			Labels:  labels,
+			Payload: decision,
+		}, nil)
+	}()
+
+	return decision, nil
+}
+
+// canApprove checks if the subject is one of the approvers, or has a role in the groups.
+func canApprove(a *config.Approval, subject string, roles []string) bool {
+	if len(a.Approvers) == 0 && len(a.Groups) == 0 {
+		return true
+	}
+	if slices.Contains(a.Approvers, subject) {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(a.Groups, role) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanApprove(t *testing.T) {
+	a := &config.Approval{Approvers: []string{"alice"}, Groups: []string{"sre"}}
+	assert.True(t, canApprove(a, "alice", nil), "should allow the approvers")
+	assert.True(t, canApprove(a, "bob", []string{"dev", "sre"}), "should allow the members of the groups")
+	assert.False(t, canApprove(a, "bob", []string{"dev"}), "should deny others")
+	assert.True(t, canApprove(&config.Approval{}, "bob", nil), "should allow anyone without approvers or groups")
+}
+
+func startApprovalTest(t *testing.T, s *SessionStore) string {
+	t.Helper()
+
+	s.StartSession(&config.Workflow{
+		OnFailure: "exit",
+		Steps: []config.Workflow{
+			{Approval: &config.Approval{Approvers: []string{"alice"}, Groups: []string{"$ctx.team"}, Description: "deploy to prod"}},
+			{CallDriver: "foo.bar"},
+		},
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event1"}}, map[string]interface{}{"team": "sre"})
+	waitChildren(t)
+
+	approvals := s.ListApprovals()
+	assert.Len(t, approvals, 1, "should list the pending approval")
+	assert.Equal(t, []string{"sre"}, approvals[0]["groups"], "should interpolate the approval")
+	assert.Equal(t, "deploy to prod", approvals[0]["description"])
+	assert.Equal(t, "event1", approvals[0]["eventID"])
+
+	return approvals[0]["approvalID"].(string)
+}
+
+func TestWorkflowApprove(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	approvalID := startApprovalTest(t, s)
+
+	s.ResumeSession(approvalID, &dipper.Message{Payload: map[string]interface{}{}})
+	waitChildren(t)
+	assert.Len(t, s.ListApprovals(), 1, "should not resume the approval without deciding")
+
+	_, err := s.DecideApproval(approvalID, true, "bob", []string{"dev"}, "")
+	assert.ErrorIs(t, err, ErrNotApprover, "should deny approval from others")
+	_, err = s.DecideApproval("unknown", true, "alice", nil, "")
+	assert.ErrorIs(t, err, ErrApprovalNotFound)
+
+	var sent *dipper.Message
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1).Do(func(msg *dipper.Message) { sent = msg })
+	decision, err := s.DecideApproval(approvalID, true, "bob", []string{"sre"}, "lgtm")
+	assert.Nil(t, err, "should approve as a member of the groups")
+	assert.Equal(t, ApprovalApproved, decision["decision"])
+	waitChildren(t)
+
+	assert.Empty(t, s.ListApprovals(), "should not be pending any more")
+	assert.NotNil(t, sent, "should continue to the next step")
+	assert.Equal(t, map[string]interface{}{
+		"approvalID": approvalID,
+		"decision":   ApprovalApproved,
+		"by":         "bob",
+		"comment":    "lgtm",
+	}, dipper.MustGetMapData(sent.Payload, "ctx.approval"), "should export the decision")
+}
+
+func TestWorkflowReject(t *testing.T) {
+	s, _ := newPersistTestStore(t, nil)
+	approvalID := startApprovalTest(t, s)
+	root := s.ByEventID("event1")[0].(*Session)
+
+	_, err := s.DecideApproval(approvalID, false, "alice", nil, "not now")
+	assert.Nil(t, err, "should reject as an approver")
+	waitChildren(t)
+
+	assert.Zero(t, s.Len(), "should not continue to the next step")
+	assert.Equal(t, SessionStatusFailure, root.savedMsg.Labels["status"], "should fail the workflow")
+	assert.Equal(t, "rejected by alice: not now", root.savedMsg.Labels["reason"])
+	assert.Equal(t, ApprovalRejected, dipper.MustGetMapData(root.ctx, "approval.decision"))
+}
+
+func TestWorkflowApproveConcurrent(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	approvalID := startApprovalTest(t, s)
+	helper.EXPECT().SendMessage(gomock.Any()).MaxTimes(1)
+
+	var wg sync.WaitGroup
+	decided := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(approved bool) {
+			defer wg.Done()
+			_ = s.ListApprovals()
+			_, err := s.DecideApproval(approvalID, approved, "alice", nil, "")
+			decided <- err == nil
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	waitChildren(t)
+	close(decided)
+
+	count := 0
+	for ok := range decided {
+		if ok {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "should decide the approval only once")
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+const (
+	// ReasonTimeout is the reason of the error when a session runs beyond its timeout.
+	ReasonTimeout = "timeout"
+	// ReasonCancelled is the reason of the error when a session is cancelled through the API.
+	ReasonCancelled = "cancelled"
+)
+
+// CancelSession cancels the session and all its descendants, the session completes with error
+// status and the given reason, returns false if the session is not found.
+func (s *SessionStore) CancelSession(sessionID string, reason string) bool {
+	w, ok := dipper.IDMapGet(&s.sessions, sessionID).(*Session)
+	if !ok {
+		return false
+	}
+	w.cancel(reason)
+
+	return true
+}
+
+// CancelEvent cancels all the sessions triggered by the event, returns the sessions being cancelled.
+func (s *SessionStore) CancelEvent(eventID string, reason string) []SessionHandler {
+	sessions := s.ByEventID(eventID)
+	for _, sh := range sessions {
+		if w, ok := sh.(*Session); ok {
+			w.cancel(reason)
+		}
+	}
+
+	return sessions
+}
+
+// childSessions returns the running sessions by their parents.
+func (s *SessionStore) childSessions() map[string][]*Session {
+	children := map[string][]*Session{}
+	meta := dipper.IDMapMetadata[&s.sessions]
+	meta.Lock.Lock()
+	defer meta.Lock.Unlock()
+	for _, sh := range s.sessions {
+		if w, ok := sh.(*Session); ok && w.parent != "" {
+			children[w.parent] = append(children[w.parent], w)
+		}
+	}
+
+	return children
+}
+
+// descendants returns all the sessions created directly or indirectly by the session.
+func (s *SessionStore) descendants(sessionID string) []*Session {
+	children := s.childSessions()
+	ret := []*Session{}
+	for queue := children[sessionID]; len(queue) > 0; queue = queue[1:] {
+		ret = append(ret, queue[0])
+		queue = append(queue, children[queue[0].ID]...)
+	}
+
+	return ret
+}
+
+// startTimeout starts the timer for cancelling the session when it runs beyond the timeout, the
+// time is counted from the start of the session.
+func (w *Session) startTimeout() {
+	if w.workflow.Timeout == "" || w.timeoutTimer != nil {
+		return
+	}
+	d, err := time.ParseDuration(w.workflow.Timeout)
+	if err != nil {
+		panic(fmt.Errorf("%w: invalid timeout %q: %w", ErrWorkflowError, w.workflow.Timeout, err))
+	}
+
+	sessionID := w.ID
+	w.timeoutTimer = time.AfterFunc(time.Until(w.startTime.Add(d)), func() {
+		defer dipper.SafeExitOnError("[workflow] session [%s] error when timing out", sessionID)
+		w.cancel(ReasonTimeout)
+	})
+}
+
+// cancel stops all the descendants of the session and completes the session with error status and
+// the given reason, firing the on_error and on_exit hooks.  The session is locked while cancelling,
+// so it can be called from the timers and the APIs.
+func (w *Session) cancel(reason string) {
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	w.cancelLocked(reason)
+}
+
+// cancelLocked cancels the session already locked by the caller, see cancel.
+func (w *Session) cancelLocked(reason string) {
+	if w.ID == "" || dipper.IDMapGet(&w.store.sessions, w.ID) != w {
+		// completed already
+		return
+	}
+	dipper.Logger.Warningf("[workflow] session [%s] cancelled: %s", w.ID, reason)
+
+	// the deepest descendants are stopped first, so they are recorded in the history of their parents
+	descendants := w.store.descendants(w.ID)
+	for i := len(descendants) - 1; i >= 0; i-- {
+		descendants[i].stop(reason)
+	}
+	w.stopAction()
+
+	// the session continues under a new ID, so the late returns to the old ID are dropped
+	dipper.IDMapDel(&w.store.sessions, w.ID)
+	w.store.deleteCheckpoint(w.ID)
+	w.ID = ""
+	w.save()
+
+	msg := &dipper.Message{
+		Channel: dipper.ChannelEventbus,
+		Subject: dipper.EventbusReturn,
+		Labels: map[string]string{
+			"status": SessionStatusError,
+			"reason": reason,
+		},
+		Payload: map[string]interface{}{},
+	}
+	if w.isInCompleteHooks() {
+		w.continueAfterHook(msg)
+
+		return
+	}
+	w.currentHook = ""
+	w.complete(msg)
+}
+
+// stop stops the descendant session being cancelled along with its ancestor, and records it in the
+// history of its parent with error status and the given reason.
+func (w *Session) stop(reason string) {
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	if dipper.IDMapGet(&w.store.sessions, w.ID) != w {
+		// completed already
+		return
+	}
+	w.stopAction()
+	w.recordHistory(&dipper.Message{
+		Labels: map[string]string{
+			"status": SessionStatusError,
+			"reason": reason,
+		},
+	})
+	w.releaseSlot()
+	dipper.IDMapDel(&w.store.sessions, w.ID)
+	w.store.deleteCheckpoint(w.ID)
+	if w.cancelFunc != nil {
+		w.cancelFunc()
+	}
+}
+
+// stopAction stops the action in progress, the waiting, the pending retry and the command in flight.
+func (w *Session) stopAction() {
+	w.cancelled = true
+	if w.timeoutTimer != nil {
+		w.timeoutTimer.Stop()
+	}
+	if w.retryTimer != nil {
+		w.retryTimer.Stop()
+		w.retryTimer = nil
+	}
+	if w.resumeToken != "" {
+		w.store.unsuspend(w.resumeToken, w.ID)
+		delete(w.ctx, "_wait_timer")
+		w.resumeToken, w.waitUntil = "", time.Time{}
+	}
+	if w.inFlyFunction != nil {
+		// the cancellation travels through the eventbus along with the commands
+		w.store.Helper.SendMessage(&dipper.Message{
+			Channel: dipper.ChannelEventbus,
+			Subject: dipper.EventbusCommand,
+			Labels: map[string]string{
+				"sessionID":           w.ID,
+				dipper.EventbusCancel: "true",
+			},
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkflowTimeout(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := make(chan *dipper.Message, 10)
+	helper.EXPECT().SendMessage(gomock.Any()).AnyTimes().Do(func(msg *dipper.Message) { sent <- msg })
+	result := make(chan map[string]interface{}, 1)
+	helper.EXPECT().EmitResult(gomock.Any(), gomock.Any()).Times(1).Do(func(_ string, r map[string]interface{}) { result <- r })
+
+	s.StartSession(&config.Workflow{
+		Timeout: "50ms",
+		Steps:   []config.Workflow{{CallDriver: "foo.bar"}},
+	}, &dipper.Message{}, map[string]interface{}{"_output": map[string]interface{}{}})
+	waitChildren(t)
+	cmd := <-sent
+
+	select {
+	case r := <-result:
+		assert.Equal(t, SessionStatusError, r["status"], "should complete with error")
+		assert.Contains(t, r["error"], ReasonTimeout, "should complete with timeout reason")
+	case <-time.After(time.Second):
+		assert.Fail(t, "should time out")
+	}
+	assert.Zero(t, s.Len(), "should remove all the sessions")
+	assert.Len(t, sent, 1, "should cancel the command in flight")
+	cancel := <-sent
+	assert.Equal(t, map[string]string{"sessionID": cmd.Labels["sessionID"], "cancel": "true"}, cancel.Labels)
+
+	s.ContinueSession(cmd.Labels["sessionID"], Success(), nil)
+	waitChildren(t)
+	assert.Empty(t, sent, "should drop the late return")
+}
+
+func TestWorkflowTimeoutInvalid(t *testing.T) {
+	w := &Session{workflow: &config.Workflow{Timeout: "soon"}}
+	assert.Panics(t, w.startTimeout, "should panic with invalid timeout")
+}
+
+func TestCancelEvent(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		Steps: []config.Workflow{{Wait: "infinite"}},
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event1"}}, map[string]interface{}{
+		"hooks": map[string]interface{}{"on_error": "test_steps"},
+	})
+	waitChildren(t)
+	assert.Len(t, s.suspendedSessions, 1, "should suspend the step")
+
+	assert.Empty(t, s.CancelEvent("event2", ReasonCancelled), "should not cancel other events")
+	cancelled := s.CancelEvent("event1", ReasonCancelled)
+	waitChildren(t)
+	assert.Len(t, cancelled, 1, "should cancel the session of the event")
+	assert.Empty(t, s.suspendedSessions, "should stop waiting")
+	assert.Equal(t, ReasonCancelled, cancelled[0].(*Session).savedMsg.Labels["reason"], "should complete with cancelled reason")
+	assert.Len(t, sent, 1, "should fire the on_error hook")
+	assert.Equal(t, "foo_sys", sent[0].Payload.(map[string]interface{})["function"].(config.Function).Target.System, "should run the hook workflow")
+	assert.False(t, s.CancelSession("nonexist", ReasonCancelled), "should not cancel missing session")
+}
+
+func TestCancelHistory(t *testing.T) {
+	s, _ := newPersistTestStore(t, nil)
+	s.StartSession(&config.Workflow{
+		Name: "outer",
+		Steps: []config.Workflow{{
+			Name:  "inner",
+			Steps: []config.Workflow{{Name: "waiting", Wait: "infinite"}},
+		}},
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event1"}}, map[string]interface{}{})
+	waitChildren(t)
+
+	s.CancelEvent("event1", ReasonCancelled)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the sessions")
+
+	history := s.GetHistory("event1")
+	assert.Len(t, history, 1, "should record the cancelled session")
+	assert.Equal(t, SessionStatusError, history[0].Status)
+	assert.Equal(t, ReasonCancelled, history[0].Reason)
+	if assert.Len(t, history[0].Children, 1, "should record the cancelled child session") {
+		inner := history[0].Children[0]
+		assert.Equal(t, "inner", inner.Name)
+		assert.Equal(t, SessionStatusError, inner.Status)
+		assert.Equal(t, ReasonCancelled, inner.Reason)
+		assert.NotNil(t, inner.CompletionTime)
+		assert.Len(t, inner.Children, 1, "should record the cancelled step in its parent")
+		assert.Equal(t, "waiting", inner.Children[0].Name)
+		assert.Equal(t, SessionStatusError, inner.Children[0].Status)
+		assert.Equal(t, ReasonCancelled, inner.Children[0].Reason)
+	}
+}
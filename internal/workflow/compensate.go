@@ -0,0 +1,88 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"fmt"
+
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/mitchellh/mapstructure"
+)
+
+// recordCompensation records the step just completed successfully if it can be compensated.
+func (w *Session) recordCompensation(msg *dipper.Message) {
+	if len(w.workflow.Steps) == 0 || w.elseBranch != nil || msg.Labels["status"] != SessionStatusSuccess {
+		return
+	}
+	if w.workflow.Steps[w.current].Compensate != nil {
+		w.compensations = append(w.compensations, int(w.current))
+	}
+}
+
+// compensate starts compensating the completed steps in reverse order when the steps end without
+// success, returns false if there is nothing to compensate.
+func (w *Session) compensate(msg *dipper.Message) bool {
+	if len(w.compensations) == 0 || msg.Labels["status"] == SessionStatusSuccess {
+		return false
+	}
+
+	dipper.Logger.Infof("[workflow] session [%s] compensating %d steps after %s: %s", w.ID, len(w.compensations), msg.Labels["status"], msg.Labels["reason"])
+	w.compensating = msg
+	w.compensated = nil
+	w.executeCompensation()
+
+	return true
+}
+
+// executeCompensation runs the compensation of the last completed step.
+func (w *Session) executeCompensation() {
+	i := w.compensations[len(w.compensations)-1]
+	wf := &config.Workflow{}
+	err := mapstructure.Decode(w.workflow.Steps[i].Compensate, wf)
+	if err != nil {
+		panic(fmt.Errorf("%w: invalid compensate in step %d: %w", ErrWorkflowError, i, err))
+	}
+
+	w.performing = fmt.Sprintf("compensating step %d", i)
+	child := w.createChildSession(wf, w.compensating)
+	child.ctx["step_number"] = i
+	child.execute(w.compensating)
+}
+
+// continueCompensation records the result of the compensation and moves on to the next one, the
+// results are exported as `compensation` after all the steps are compensated, then the session
+// continues with the original failure.
+func (w *Session) continueCompensation(msg *dipper.Message) {
+	i := w.compensations[len(w.compensations)-1]
+	w.compensations = w.compensations[:len(w.compensations)-1]
+	result := map[string]interface{}{
+		"step":   i,
+		"status": msg.Labels["status"],
+	}
+	if msg.Labels["status"] != SessionStatusSuccess {
+		dipper.Logger.Warningf("[workflow] session [%s] failed to compensate step %d: %s", w.ID, i, msg.Labels["reason"])
+		result["reason"] = msg.Labels["reason"]
+	}
+	w.compensated = append(w.compensated, result)
+
+	if len(w.compensations) > 0 {
+		w.executeCompensation()
+
+		return
+	}
+
+	failure := w.compensating
+	w.compensating = nil
+	delta := map[string]interface{}{"compensation": w.compensated}
+	w.ctx = dipper.MergeMap(w.ctx, delta)
+	w.processNoExport(delta)
+	if len(delta) > 0 {
+		w.exported = append(w.exported, delta)
+	}
+	w.continueExec(failure, nil)
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func compensateTestWorkflow() *config.Workflow {
+	return &config.Workflow{
+		Steps: []config.Workflow{
+			{CallDriver: "foo.scale", Compensate: map[string]interface{}{"call_driver": "foo.unscale"}},
+			{CallDriver: "foo.notify"},
+			{CallDriver: "foo.backup", Compensate: map[string]interface{}{"call_driver": "foo.restore"}},
+			{CallDriver: "foo.dns", Compensate: map[string]interface{}{"call_driver": "foo.undns"}},
+		},
+	}
+}
+
+func TestWorkflowCompensate(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(6).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+	action := func(i int) string {
+		return sent[i].Payload.(map[string]interface{})["function"].(config.Function).RawAction
+	}
+
+	w := s.StartSession(compensateTestWorkflow(), &dipper.Message{}, map[string]interface{}{}).(*Session)
+	waitChildren(t)
+	for i := 0; i < 3; i++ {
+		s.ContinueSession(sent[i].Labels["sessionID"], Success(), nil)
+		waitChildren(t)
+	}
+	assert.Equal(t, "dns", action(3), "should run all the steps")
+
+	s.ContinueSession(sent[3].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusError, "reason": "dns failed"},
+	}, nil)
+	waitChildren(t)
+	assert.Equal(t, "restore", action(4), "should compensate the last completed step first")
+
+	s.ContinueSession(sent[4].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusFailure, "reason": "no backup"},
+	}, nil)
+	waitChildren(t)
+	assert.Equal(t, "unscale", action(5), "should continue compensating after a failed compensation")
+
+	s.ContinueSession(sent[5].Labels["sessionID"], Success(), nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the session")
+	assert.Equal(t, SessionStatusError, w.savedMsg.Labels["status"], "should keep the original status")
+	assert.Equal(t, "dns failed", w.savedMsg.Labels["reason"], "should keep the original reason")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"step": 2, "status": SessionStatusFailure, "reason": "no backup"},
+		map[string]interface{}{"step": 0, "status": SessionStatusSuccess},
+	}, w.ctx["compensation"], "should export the compensation results")
+}
+
+func TestWorkflowCompensateSuccess(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(4).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	w := s.StartSession(compensateTestWorkflow(), &dipper.Message{}, map[string]interface{}{}).(*Session)
+	waitChildren(t)
+	for i := 0; i < 4; i++ {
+		s.ContinueSession(sent[i].Labels["sessionID"], Success(), nil)
+		waitChildren(t)
+	}
+	assert.Zero(t, s.Len(), "should complete without compensation")
+	assert.NotContains(t, w.ctx, "compensation")
+}
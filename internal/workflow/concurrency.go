@@ -0,0 +1,463 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// policies when the concurrency limit is reached.
+const (
+	ConcurrencyQueue          = "queue"
+	ConcurrencySkip           = "skip"
+	ConcurrencyCancelPrevious = "cancel_previous"
+)
+
+const (
+	// DefaultConcurrencyExpire is how long a session can hold a concurrency slot if the workflow has no timeout.
+	DefaultConcurrencyExpire = time.Hour
+	// ConcurrencyRefreshRatio is how many times a held slot is refreshed within its expire duration.
+	ConcurrencyRefreshRatio = 3
+	// ConcurrencyRetryInterval is how often the queued sessions try to acquire the slots released by
+	// other engines.
+	ConcurrencyRetryInterval = 5 * time.Second
+
+	// ReasonPreempted is the reason of the error when a session is cancelled by a newer session with
+	// the cancel_previous concurrency policy.
+	ReasonPreempted = "preempted"
+	// ReasonSkipped is the reason of the failure when a session is skipped with the skip concurrency policy.
+	ReasonSkipped = "skipped due to concurrency limit"
+)
+
+// ConcurrencyCoordinator coordinates the concurrency slots of the sessions, e.g. through the locker
+// feature shared by the engines.
+type ConcurrencyCoordinator interface {
+	// Lock acquires the lock with the name for the owner if it is not held, the lock is released after
+	// expire.  An error is returned if not able to tell whether the lock is held.
+	Lock(name string, owner string, expire time.Duration) (bool, error)
+	// Refresh extends the lock held by the owner for another expire, returns false if the lock is no
+	// longer held by the owner.
+	Refresh(name string, owner string, expire time.Duration) (bool, error)
+	// Unlock releases the lock with the name if it is still held by the owner.
+	Unlock(name string, owner string)
+	// CancelHolders cancels the sessions holding the slots of the key since before the time, see
+	// CancelConcurrency.
+	CancelHolders(key string, before time.Time)
+}
+
+// localCoordinator keeps the concurrency slots in memory, used when there is only one engine.
+type localCoordinator struct {
+	store *SessionStore
+	locks map[string]localLock
+	lock  sync.Mutex
+}
+
+// localLock is a concurrency slot held in memory.
+type localLock struct {
+	owner  string
+	expiry time.Time
+}
+
+// Lock acquires the lock if it is not held or expired.
+func (c *localCoordinator) Lock(name string, owner string, expire time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if l, ok := c.locks[name]; ok && time.Now().Before(l.expiry) {
+		return false, nil
+	}
+	c.locks[name] = localLock{owner: owner, expiry: time.Now().Add(expire)}
+
+	return true, nil
+}
+
+// Refresh extends the lock if it is still held by the owner.
+func (c *localCoordinator) Refresh(name string, owner string, expire time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if l, ok := c.locks[name]; !ok || l.owner != owner || !time.Now().Before(l.expiry) {
+		return false, nil
+	}
+	c.locks[name] = localLock{owner: owner, expiry: time.Now().Add(expire)}
+
+	return true, nil
+}
+
+// Unlock releases the lock if it is still held by the owner.
+func (c *localCoordinator) Unlock(name string, owner string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if l, ok := c.locks[name]; ok && l.owner == owner {
+		delete(c.locks, name)
+	}
+}
+
+// CancelHolders cancels the sessions holding the slots asynchronously, like a broadcast.
+func (c *localCoordinator) CancelHolders(key string, before time.Time) {
+	daemon.Children.Add(1)
+	go func() {
+		defer daemon.Children.Done()
+		c.store.CancelConcurrency(key, before)
+	}()
+}
+
+// queuedSession is a session waiting for a concurrency slot.
+type queuedSession struct {
+	session *Session
+	msg     *dipper.Message
+}
+
+// interpolateConcurrency creates a copy of the concurrency settings with the key interpolated.
+func interpolateConcurrency(c *config.Concurrency, envData map[string]interface{}) *config.Concurrency {
+	if c == nil {
+		return nil
+	}
+	ret := *c
+	ret.Key = dipper.InterpolateStr(c.Key, envData)
+
+	return &ret
+}
+
+// concurrencyKey returns the key of the concurrency slots of the session.
+func (w *Session) concurrencyKey() string {
+	key := w.workflow.Concurrency.Key
+	if key == "" {
+		key = w.workflow.Name
+	}
+	if key == "" {
+		panic(fmt.Errorf("%w: concurrency requires a key or a workflow name", ErrWorkflowError))
+	}
+
+	return key
+}
+
+// concurrencyExpire returns how long the session can hold the slot.
+func (w *Session) concurrencyExpire() time.Duration {
+	expire := w.workflow.Concurrency.Expire
+	if expire == "" {
+		expire = w.workflow.Timeout
+	}
+	if expire == "" {
+		return DefaultConcurrencyExpire
+	}
+	d, err := time.ParseDuration(expire)
+	if err != nil {
+		panic(fmt.Errorf("%w: invalid concurrency expire %q: %w", ErrWorkflowError, expire, err))
+	}
+
+	return d
+}
+
+// acquireSlot acquires a concurrency slot for the session, returns false if the session is queued or
+// skipped as the concurrency limit is reached.
+func (w *Session) acquireSlot(msg *dipper.Message) bool {
+	c := w.workflow.Concurrency
+	if c == nil || w.slot != "" {
+		return true
+	}
+	key := w.concurrencyKey()
+	slot, owner, err := w.lockSlot(key)
+	if err != nil {
+		dipper.Logger.Warningf("[workflow] session [%s] failed: %v", w.ID, err)
+		w.abandon(SessionStatusError, err.Error())
+
+		return false
+	}
+	if slot != "" {
+		w.holdSlot(slot, owner)
+
+		return true
+	}
+
+	switch c.Policy {
+	case ConcurrencySkip:
+		dipper.Logger.Infof("[workflow] session [%s] skipped, concurrency limit of %s reached", w.ID, key)
+		w.abandon(SessionStatusFailure, ReasonSkipped)
+
+		return false
+	case ConcurrencyCancelPrevious:
+		dipper.Logger.Infof("[workflow] session [%s] cancelling the previous sessions of %s", w.ID, key)
+		w.store.Coordinator.CancelHolders(key, time.Now())
+	case "", ConcurrencyQueue:
+	default:
+		panic(fmt.Errorf("%w: unknown concurrency policy %s", ErrWorkflowError, c.Policy))
+	}
+
+	w.enqueue(key, msg)
+
+	return false
+}
+
+// lockSlot tries to lock any of the slots of the key, returns the name of the slot locked with the
+// owner token identifying the holder, or empty strings if all the slots are held.
+func (w *Session) lockSlot(key string) (slot string, owner string, err error) {
+	limit := w.workflow.Concurrency.Max
+	if limit <= 0 {
+		limit = 1
+	}
+	expire := w.concurrencyExpire()
+	owner = uuid.NewString()
+	for i := 0; i < limit; i++ {
+		name := fmt.Sprintf("concurrency:%s:%d", key, i)
+		ok, err := w.store.Coordinator.Lock(name, owner, expire)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: unable to acquire concurrency slot %s: %w", ErrWorkflowError, name, err)
+		}
+		if ok {
+			return name, owner, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+// holdSlot marks the slot being held by the session, and keeps the slot from expiring.
+func (w *Session) holdSlot(slot string, owner string) {
+	w.slot, w.slotOwner, w.slotTime = slot, owner, time.Now()
+	dipper.Logger.Debugf("[workflow] session [%s] acquired concurrency slot %s", w.ID, slot)
+	w.keepSlot()
+}
+
+// keepSlot refreshes the slot held by the session before it expires, so the session runs longer than
+// the expire duration without losing the slot.
+func (w *Session) keepSlot() {
+	if w.slotOwner == "" {
+		// held before the owner tokens, left to expire
+		return
+	}
+	expire := w.concurrencyExpire()
+	slot := w.slot
+	w.slotTimer = time.AfterFunc(expire/ConcurrencyRefreshRatio, func() {
+		w.refreshSlot(slot, expire)
+	})
+}
+
+// refreshSlot extends the slot if it is still held by the session, and schedules the next refresh.
+func (w *Session) refreshSlot(slot string, expire time.Duration) {
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	if w.slot != slot {
+		// released
+		return
+	}
+
+	ok, err := w.store.Coordinator.Refresh(slot, w.slotOwner, expire)
+	switch {
+	case err != nil:
+		dipper.Logger.Warningf("[workflow] session [%s] unable to refresh concurrency slot %s: %v", w.ID, slot, err)
+	case !ok:
+		dipper.Logger.Warningf("[workflow] session [%s] lost concurrency slot %s", w.ID, slot)
+		w.slotTimer = nil
+
+		return
+	}
+	w.keepSlot()
+}
+
+// abandon completes the session with the status and the reason without running it.
+func (w *Session) abandon(status string, reason string) {
+	msg := &dipper.Message{
+		Channel: dipper.ChannelEventbus,
+		Subject: dipper.EventbusReturn,
+		Labels: map[string]string{
+			"status": status,
+			"reason": reason,
+		},
+		Payload: map[string]interface{}{},
+	}
+	w.save()
+	w.complete(msg)
+}
+
+// enqueue saves the session and puts it in the queue of the key, waiting for a slot.
+func (w *Session) enqueue(key string, msg *dipper.Message) {
+	w.save()
+	w.performing = "queued for " + key
+	w.startTimeout()
+
+	s := w.store
+	s.concurrencyLock.Lock()
+	_, waiting := s.queues[key]
+	s.queues[key] = append(s.queues[key], &queuedSession{session: w, msg: msg})
+	s.concurrencyLock.Unlock()
+	dipper.Logger.Infof("[workflow] session [%s] queued, concurrency limit of %s reached", w.ID, key)
+
+	if !waiting {
+		s.scheduleDequeue(key)
+	}
+}
+
+// scheduleDequeue retries acquiring the slots periodically for the slots released by other engines,
+// until the queue of the key is empty.
+func (s *SessionStore) scheduleDequeue(key string) {
+	time.AfterFunc(ConcurrencyRetryInterval, func() {
+		if s.dequeue(key) {
+			s.scheduleDequeue(key)
+		}
+	})
+}
+
+// dequeue starts the first session in the queue of the key if a slot is available, the next
+// session in the queue is tried right after.  Returns true if the queue is not empty.  The slots
+// are locked without holding the queues, as locking may take a round trip to the locker.
+func (s *SessionStore) dequeue(key string) bool {
+	for {
+		q := s.firstQueued(key)
+		if q == nil {
+			return false
+		}
+
+		slot, owner, err := q.session.lockSlot(key)
+		if slot == "" && err == nil {
+			return true
+		}
+		if !s.popQueued(key, q) {
+			// taken by another dequeue
+			if slot != "" {
+				s.Coordinator.Unlock(slot, owner)
+			}
+
+			continue
+		}
+
+		dipper.Logger.Infof("[workflow] session [%s] dequeued", q.session.ID)
+		daemon.Children.Add(1)
+		go s.runDequeued(key, q, slot, owner, err)
+		if err != nil {
+			// the rest of the queue retries later
+			return true
+		}
+	}
+}
+
+// firstQueued returns the first session in the queue of the key, nil if the queue is empty.
+func (s *SessionStore) firstQueued(key string) *queuedSession {
+	s.concurrencyLock.Lock()
+	defer s.concurrencyLock.Unlock()
+	if len(s.queues[key]) == 0 {
+		delete(s.queues, key)
+
+		return nil
+	}
+
+	return s.queues[key][0]
+}
+
+// popQueued removes the session from the head of the queue of the key, returns false if the
+// session is not at the head.
+func (s *SessionStore) popQueued(key string, q *queuedSession) bool {
+	s.concurrencyLock.Lock()
+	defer s.concurrencyLock.Unlock()
+	if len(s.queues[key]) == 0 || s.queues[key][0] != q {
+		return false
+	}
+	s.queues[key] = s.queues[key][1:]
+
+	return true
+}
+
+// removeQueued removes the session from the queue of the key wherever it is.
+func (s *SessionStore) removeQueued(key string, w *Session) {
+	s.concurrencyLock.Lock()
+	defer s.concurrencyLock.Unlock()
+	for i, q := range s.queues[key] {
+		if q.session == w {
+			s.queues[key] = append(s.queues[key][:i:i], s.queues[key][i+1:]...)
+
+			return
+		}
+	}
+}
+
+// runDequeued runs the session with the slot, or fails it with the error from locking the slot.
+func (s *SessionStore) runDequeued(key string, q *queuedSession, slot string, owner string, err error) {
+	defer daemon.Children.Done()
+	w := q.session
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	defer dipper.SafeExitOnError("[workflow] error when running dequeued session %s", w.ID)
+
+	if dipper.IDMapGet(&s.sessions, w.ID) != w {
+		// cancelled after being dequeued
+		if slot != "" {
+			s.Coordinator.Unlock(slot, owner)
+			daemon.Children.Add(1)
+			go func() {
+				defer daemon.Children.Done()
+				s.dequeue(key)
+			}()
+		}
+
+		return
+	}
+
+	defer w.onError()
+	if err != nil {
+		panic(err)
+	}
+	w.holdSlot(slot, owner)
+	w.run(q.msg)
+}
+
+// releaseSlot releases the concurrency slot held by the session, or removes the session from the
+// queue, so the queued sessions can run.
+func (w *Session) releaseSlot() {
+	if w.workflow.Concurrency == nil {
+		return
+	}
+	key := w.concurrencyKey()
+	if w.slot == "" {
+		w.store.removeQueued(key, w)
+
+		return
+	}
+	if w.slotTimer != nil {
+		w.slotTimer.Stop()
+		w.slotTimer = nil
+	}
+	w.store.Coordinator.Unlock(w.slot, w.slotOwner)
+	dipper.Logger.Debugf("[workflow] session [%s] released concurrency slot %s", w.ID, w.slot)
+	w.slot, w.slotOwner = "", ""
+
+	daemon.Children.Add(1)
+	go func() {
+		defer daemon.Children.Done()
+		w.store.dequeue(key)
+	}()
+}
+
+// CancelConcurrency cancels the sessions in this engine holding the concurrency slots of the key
+// since before the given time, so the sessions acquiring the slots later are not cancelled.
+func (s *SessionStore) CancelConcurrency(key string, before time.Time) {
+	candidates := []*Session{}
+	func() {
+		meta := dipper.IDMapMetadata[&s.sessions]
+		meta.Lock.Lock()
+		defer meta.Lock.Unlock()
+		for _, sh := range s.sessions {
+			if w, ok := sh.(*Session); ok && w.workflow.Concurrency != nil {
+				candidates = append(candidates, w)
+			}
+		}
+	}()
+
+	for _, w := range candidates {
+		func() {
+			w.execLock.Lock()
+			defer w.execLock.Unlock()
+			if w.slot != "" && w.slotTime.Before(before) && w.concurrencyKey() == key {
+				w.cancelLocked(ReasonPreempted)
+			}
+		}()
+	}
+}
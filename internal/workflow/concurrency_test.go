@@ -0,0 +1,192 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func concurrencyTestStore(t *testing.T) (*SessionStore, *[]*dipper.Message) {
+	t.Helper()
+
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).AnyTimes().Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	return s, &sent
+}
+
+func startConcurrencyTest(t *testing.T, s *SessionStore, eventID string, c *config.Concurrency, ctx map[string]interface{}) *Session {
+	t.Helper()
+
+	w := s.StartSession(&config.Workflow{
+		Name:        "remediate",
+		Concurrency: c,
+		CallDriver:  "foo.bar",
+	}, &dipper.Message{Labels: map[string]string{"eventID": eventID}}, ctx).(*Session)
+	waitChildren(t)
+
+	return w
+}
+
+var errLockerDown = errors.New("locker down")
+
+type brokenCoordinator struct{ localCoordinator }
+
+func (c *brokenCoordinator) Lock(name string, owner string, expire time.Duration) (bool, error) {
+	return false, errLockerDown
+}
+
+func TestLocalCoordinator(t *testing.T) {
+	c := &localCoordinator{locks: map[string]localLock{}}
+	lock := func(owner string, expire time.Duration) bool {
+		ok, err := c.Lock("a", owner, expire)
+		assert.NoError(t, err)
+
+		return ok
+	}
+	refresh := func(owner string) bool {
+		ok, err := c.Refresh("a", owner, time.Minute)
+		assert.NoError(t, err)
+
+		return ok
+	}
+	assert.True(t, lock("1", time.Minute), "should lock")
+	assert.False(t, lock("2", time.Minute), "should not lock twice")
+	assert.True(t, refresh("1"), "should refresh the lock held by the owner")
+	assert.False(t, refresh("2"), "should not refresh the lock held by others")
+	c.Unlock("a", "1")
+	assert.True(t, lock("1", -time.Second), "should lock after unlocked")
+	assert.False(t, refresh("1"), "should not refresh the expired lock")
+	assert.True(t, lock("2", time.Minute), "should lock after expired")
+	c.Unlock("a", "1")
+	assert.False(t, lock("3", time.Minute), "should not unlock the lock taken by others after expired")
+}
+
+func TestConcurrencyRefresh(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+	c := &config.Concurrency{Expire: "30ms"}
+
+	first := startConcurrencyTest(t, s, "event1", c, map[string]interface{}{})
+	assert.Len(t, *sent, 1)
+	first.execLock.Lock()
+	slot, owner := first.slot, first.slotOwner
+	first.execLock.Unlock()
+	assert.NotEmpty(t, owner, "should lock the slot with an owner token")
+
+	time.Sleep(100 * time.Millisecond)
+	ok, err := s.Coordinator.Lock(slot, "other", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "should keep the slot while the session runs longer than the expire")
+
+	s.ContinueSession(first.ID, Success(), nil)
+	waitChildren(t)
+	ok, err = s.Coordinator.Refresh(slot, owner, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "should release the slot when the session completes")
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+
+	first := startConcurrencyTest(t, s, "event1", &config.Concurrency{}, map[string]interface{}{})
+	second := startConcurrencyTest(t, s, "event2", &config.Concurrency{}, map[string]interface{}{})
+	third := startConcurrencyTest(t, s, "event3", &config.Concurrency{}, map[string]interface{}{})
+	assert.Len(t, *sent, 1, "should run one session at a time")
+	assert.Equal(t, "queued for remediate", second.performing, "should queue the session")
+	assert.Equal(t, 3, s.Len(), "should keep the queued sessions")
+
+	s.CancelEvent("event3", ReasonCancelled)
+	waitChildren(t)
+
+	s.ContinueSession(first.ID, Success(), nil)
+	waitChildren(t)
+	assert.Len(t, *sent, 2, "should run the queued session after the slot is released")
+	assert.Equal(t, "driver foo.bar", second.performing)
+
+	s.ContinueSession(second.ID, Success(), nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should not run the cancelled session")
+	assert.Equal(t, ReasonCancelled, third.savedMsg.Labels["reason"])
+	assert.Empty(t, s.queues, "should empty the queue")
+}
+
+func TestConcurrencyKey(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+	c := &config.Concurrency{Key: "$ctx.cluster", Max: 2}
+
+	startConcurrencyTest(t, s, "event1", c, map[string]interface{}{"cluster": "a"})
+	startConcurrencyTest(t, s, "event2", c, map[string]interface{}{"cluster": "a"})
+	startConcurrencyTest(t, s, "event3", c, map[string]interface{}{"cluster": "a"})
+	startConcurrencyTest(t, s, "event4", c, map[string]interface{}{"cluster": "b"})
+	assert.Len(t, *sent, 3, "should limit the sessions by the key")
+	assert.Len(t, s.queues["a"], 1, "should queue the session over the limit")
+}
+
+func TestConcurrencySkip(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+	c := &config.Concurrency{Key: "k", Policy: ConcurrencySkip}
+
+	startConcurrencyTest(t, s, "event1", c, map[string]interface{}{})
+	skipped := startConcurrencyTest(t, s, "event2", c, map[string]interface{}{})
+	assert.Len(t, *sent, 1, "should skip the session over the limit")
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, SessionStatusFailure, skipped.savedMsg.Labels["status"], "should complete the skipped session")
+	assert.Equal(t, ReasonSkipped, skipped.savedMsg.Labels["reason"])
+
+	s.StartSession(&config.Workflow{
+		Steps: []config.Workflow{{Concurrency: c, CallDriver: "foo.bar"}},
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event3"}}, map[string]interface{}{})
+	waitChildren(t)
+	history := s.GetHistory("event3")
+	assert.Len(t, history, 1, "should complete the parent session")
+	assert.Equal(t, SessionStatusFailure, history[0].Children[0].Status, "should fail the skipped step")
+	assert.Equal(t, ReasonSkipped, history[0].Children[0].Reason)
+}
+
+func TestConcurrencyCancelPrevious(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+	c := &config.Concurrency{Policy: ConcurrencyCancelPrevious}
+
+	first := startConcurrencyTest(t, s, "event1", c, map[string]interface{}{})
+	second := startConcurrencyTest(t, s, "event2", c, map[string]interface{}{})
+	waitChildren(t)
+
+	assert.Len(t, *sent, 3, "should cancel the command of the previous session and run the new one")
+	assert.Equal(t, "true", (*sent)[1].Labels[dipper.EventbusCancel])
+	assert.Equal(t, ReasonPreempted, first.savedMsg.Labels["reason"], "should preempt the previous session")
+	assert.Equal(t, "driver foo.bar", second.performing, "should run the new session")
+	assert.Equal(t, 1, s.Len())
+	list, _ := s.ListDeadLetters()
+	assert.Empty(t, list, "should not record the preempted session as dead letter")
+
+	s.CancelConcurrency("remediate", first.startTime)
+	waitChildren(t)
+	assert.Equal(t, "driver foo.bar", second.performing, "should not cancel the session acquiring the slot later")
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestConcurrencyLockError(t *testing.T) {
+	s, sent := concurrencyTestStore(t)
+	s.Coordinator = &brokenCoordinator{}
+
+	w := startConcurrencyTest(t, s, "event1", &config.Concurrency{}, map[string]interface{}{})
+	assert.Empty(t, *sent, "should not run the session without a slot")
+	assert.Zero(t, s.Len(), "should not queue the session")
+	assert.Equal(t, SessionStatusError, w.savedMsg.Labels["status"], "should fail the session")
+	assert.Contains(t, w.savedMsg.Labels["reason"], errLockerDown.Error())
+}
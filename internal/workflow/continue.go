@@ -249,22 +249,31 @@ func (w *Session) complete(msg *dipper.Message) {
 		}
 
 		w.completionTime = time.Now()
+		if w.timeoutTimer != nil {
+			w.timeoutTimer.Stop()
+		}
 		dipper.IDMapDel(&w.store.sessions, w.ID)
+		w.store.deleteCheckpoint(w.ID)
+		w.releaseSlot()
+		w.recordHistory(msg)
 		if w.parent != "" {
 			daemon.Children.Add(1)
 			go func() {
 				defer daemon.Children.Done()
 				w.store.ContinueSession(w.parent, msg, w.exported)
 			}()
-		} else if output, ok := w.ctx["_output"]; ok {
-			result := map[string]interface{}{
-				"status": msg.Labels["status"],
-				"output": output,
-			}
-			if msg.Labels["status"] != SessionStatusSuccess {
-				result["error"] = fmt.Sprintf("[%s]: %s", msg.Labels["performing"], msg.Labels["reason"])
+		} else {
+			w.saveDeadLetter(msg)
+			if output, ok := w.ctx["_output"]; ok {
+				result := map[string]interface{}{
+					"status": msg.Labels["status"],
+					"output": output,
+				}
+				if msg.Labels["status"] != SessionStatusSuccess {
+					result["error"] = fmt.Sprintf("[%s]: %s", msg.Labels["performing"], msg.Labels["reason"])
+				}
+				w.store.EmitResult(w.EventID, result)
 			}
-			w.store.EmitResult(w.EventID, result)
 		}
 	}
 
@@ -341,14 +350,31 @@ func (w *Session) continueAfterHook(msg *dipper.Message) {
 // continueExec resume a session with given dipper message.
 func (w *Session) continueExec(msg *dipper.Message, exports []map[string]interface{}) {
 	delete(w.ctx, "_wait_timer")
+	w.resumeToken, w.waitUntil = "", time.Time{}
 	w.mergeContext(exports)
 	if w.currentHook != "" {
 		w.continueAfterHook(msg)
 
 		return
 	}
+	if w.compensating != nil {
+		w.continueCompensation(msg)
+
+		return
+	}
+	w.recordCompensation(msg)
 	route := w.routeNext(msg)
 	dipper.Logger.Debugf("[workflow] session [%s] routing with '%s'", w.ID, WorkflowNextStrings[route])
+	if route == WorkflowNextComplete && w.compensate(msg) {
+		return
+	}
+	switch route {
+	case WorkflowNextComplete, WorkflowNextIteration, WorkflowNextRound:
+		// the action for the item or the round is done
+		if w.retryAction(msg) {
+			return
+		}
+	}
 	switch route {
 	case WorkflowNextStep:
 		w.current++
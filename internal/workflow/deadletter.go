@@ -0,0 +1,269 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// DefaultDeadLetterKey is the default key of the redis hash storing the dead letters.
+const DefaultDeadLetterKey = "honeydipper/deadletters"
+
+// DefaultDeadLetterLimit is the default number of the dead letters kept in the memory.
+const DefaultDeadLetterLimit = 1000
+
+// ErrDeadLetterNotFound means the dead letter is not kept in this engine.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// SessionSource is what a top-level session is started with, kept for replaying the session.
+type SessionSource struct {
+	Workflow *config.Workflow       `json:"workflow"`
+	Labels   map[string]string      `json:"labels,omitempty"`
+	Payload  interface{}            `json:"payload,omitempty"`
+	Ctx      map[string]interface{} `json:"ctx,omitempty"`
+}
+
+// DeadLetter records a top-level session that completed with error.
+type DeadLetter struct {
+	ID          string                 `json:"deadLetterID"`
+	EventID     string                 `json:"eventID"`
+	Event       string                 `json:"event"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Performing  string                 `json:"performing"`
+	Reason      string                 `json:"reason"`
+	Time        time.Time              `json:"time"`
+	Data        map[string]interface{} `json:"data"`
+	Ctx         map[string]interface{} `json:"ctx"`
+	Source      *SessionSource         `json:"source"`
+}
+
+// Summary returns the dead letter without the event data, the context data and the source.
+func (d *DeadLetter) Summary() map[string]interface{} {
+	return map[string]interface{}{
+		"deadLetterID": d.ID,
+		"eventID":      d.EventID,
+		"event":        d.Event,
+		"name":         d.Name,
+		"description":  d.Description,
+		"performing":   d.Performing,
+		"reason":       d.Reason,
+		"time":         d.Time.Format(time.RFC3339),
+	}
+}
+
+// Sanitized returns a copy of the dead letter with the sensitive data redacted, for serving through
+// the API.
+func (d *DeadLetter) Sanitized() *DeadLetter {
+	ret := *d
+	ret.Data, _ = dipper.SanitizedData(d.Data).(map[string]interface{})
+	ret.Ctx, _ = dipper.SanitizedData(d.Ctx).(map[string]interface{})
+	if d.Source != nil {
+		source := *d.Source
+		source.Payload = dipper.SanitizedData(d.Source.Payload)
+		source.Ctx, _ = dipper.SanitizedData(d.Source.Ctx).(map[string]interface{})
+		ret.Source = &source
+	}
+
+	return &ret
+}
+
+// MemoryBackend keeps the records in memory, used for the dead letters when no backend is configured.
+// The oldest records are dropped when exceeding the limit, zero means no limit.
+type MemoryBackend struct {
+	limit   int
+	records map[string][]byte
+	order   []string
+	lock    sync.Mutex
+}
+
+// NewMemoryBackend creates an empty memory backend keeping up to limit records.
+func NewMemoryBackend(limit int) *MemoryBackend {
+	return &MemoryBackend{
+		limit:   limit,
+		records: map[string][]byte{},
+	}
+}
+
+// Save keeps the record.
+func (b *MemoryBackend) Save(id string, data []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.records[id]; !ok {
+		b.order = append(b.order, id)
+		for b.limit > 0 && len(b.order) > b.limit {
+			delete(b.records, b.order[0])
+			b.order = b.order[1:]
+		}
+	}
+	b.records[id] = data
+
+	return nil
+}
+
+// Delete removes the record.
+func (b *MemoryBackend) Delete(id string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.records[id]; ok {
+		delete(b.records, id)
+		for i, key := range b.order {
+			if key == id {
+				b.order = append(b.order[:i], b.order[i+1:]...)
+
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Load returns all the records.
+func (b *MemoryBackend) Load() (map[string][]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ret := make(map[string][]byte, len(b.records))
+	for id, data := range b.records {
+		ret[id] = data
+	}
+
+	return ret, nil
+}
+
+// setSource keeps what the top-level session is started with, so it can be replayed.
+func (w *Session) setSource(msg *dipper.Message, ctx map[string]interface{}) {
+	w.source = &SessionSource{
+		Workflow: w.workflow,
+		Labels:   msg.Labels,
+		Payload:  msg.Payload,
+		Ctx:      dipper.MustDeepCopyMap(ctx),
+	}
+}
+
+// saveDeadLetter records the top-level session completing with error in the dead letters.  The
+// sessions cancelled through the API or preempted by newer sessions are not recorded.
+func (w *Session) saveDeadLetter(msg *dipper.Message) {
+	if w.source == nil || msg.Labels["status"] != SessionStatusError {
+		return
+	}
+	if reason := msg.Labels["reason"]; reason == ReasonCancelled || reason == ReasonPreempted {
+		return
+	}
+
+	d := &DeadLetter{
+		ID:          dipper.NewUUID(),
+		EventID:     w.EventID,
+		Event:       w.GetEventName(),
+		Name:        w.workflow.Name,
+		Description: w.workflow.Description,
+		Performing:  msg.Labels["performing"],
+		Reason:      msg.Labels["reason"],
+		Time:        time.Now(),
+		Data:        w.event,
+		Ctx:         w.record().Ctx,
+		Source:      w.source,
+	}
+	data, err := json.Marshal(d)
+	if err == nil {
+		err = w.store.DeadLetters.Save(d.ID, data)
+	}
+	if err != nil {
+		dipper.Logger.Warningf("[workflow] unable to save dead letter for session [%s]: %v", w.ID, err)
+
+		return
+	}
+	dipper.Logger.Infof("[workflow] session [%s] saved as dead letter %s", w.ID, d.ID)
+}
+
+// ListDeadLetters returns the dead letters, the most recent first.
+func (s *SessionStore) ListDeadLetters() ([]*DeadLetter, error) {
+	records, err := s.DeadLetters.Load()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPersistence, err)
+	}
+
+	ret := make([]*DeadLetter, 0, len(records))
+	for id, data := range records {
+		d := &DeadLetter{}
+		if err := json.Unmarshal(data, d); err != nil {
+			dipper.Logger.Warningf("[workflow] skipping invalid dead letter %s: %v", id, err)
+
+			continue
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Time.After(ret[j].Time) })
+
+	return ret, nil
+}
+
+// GetDeadLetter returns the dead letter with the ID.
+func (s *SessionStore) GetDeadLetter(id string) (*DeadLetter, error) {
+	list, err := s.ListDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range list {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+}
+
+// ReplayDeadLetter removes the dead letter, and restarts its workflow with the same event data and
+// context data as a new event.  The ID of the new event is returned.
+func (s *SessionStore) ReplayDeadLetter(id string) (string, error) {
+	d, err := s.GetDeadLetter(id)
+	if err != nil {
+		return "", err
+	}
+	if d.Source == nil || d.Source.Workflow == nil {
+		return "", fmt.Errorf("%w: missing source in dead letter %s", ErrPersistence, id)
+	}
+	if err := s.DeadLetters.Delete(id); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPersistence, err)
+	}
+
+	labels := map[string]string{}
+	for k, v := range d.Source.Labels {
+		labels[k] = v
+	}
+	labels["eventID"] = dipper.NewUUID()
+	msg := &dipper.Message{
+		Channel: dipper.ChannelEventbus,
+		Subject: dipper.EventbusMessage,
+		Labels:  labels,
+		Payload: d.Source.Payload,
+	}
+	ctx := d.Source.Ctx
+	if ctx == nil {
+		ctx = map[string]interface{}{}
+	}
+	dipper.Logger.Infof("[workflow] replaying dead letter %s of event %s as event %s", id, d.EventID, labels["eventID"])
+
+	daemon.Children.Add(1)
+	go func() {
+		defer daemon.Children.Done()
+		s.StartSession(d.Source.Workflow, msg, ctx)
+	}()
+
+	return labels["eventID"], nil
+}
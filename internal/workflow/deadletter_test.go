@@ -0,0 +1,148 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetter(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(2).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		Name:  "deploy",
+		Steps: []config.Workflow{{CallDriver: "foo.bar"}},
+	}, &dipper.Message{
+		Labels:  map[string]string{"eventID": "event1"},
+		Payload: map[string]interface{}{"data": map[string]interface{}{"service": "web"}},
+	}, map[string]interface{}{"env": "prod"})
+	waitChildren(t)
+
+	s.ContinueSession(sent[0].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusError, "reason": "boom"},
+	}, nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the session")
+
+	list, err := s.ListDeadLetters()
+	assert.Nil(t, err, "should list the dead letters")
+	assert.Len(t, list, 1, "should record the session completed with error")
+	d := list[0]
+	assert.Equal(t, "event1", d.EventID)
+	assert.Equal(t, "deploy", d.Name)
+	assert.Equal(t, "boom", d.Reason)
+	assert.Equal(t, "driver foo.bar", d.Performing)
+	assert.Equal(t, map[string]interface{}{"service": "web"}, d.Data, "should record the event data")
+	assert.Equal(t, "prod", d.Ctx["env"], "should record the context data")
+	assert.NotContains(t, d.Summary(), "ctx", "should summarize without the context data")
+
+	got, err := s.GetDeadLetter(d.ID)
+	assert.Nil(t, err, "should get the dead letter")
+	assert.Equal(t, d, got)
+	_, err = s.GetDeadLetter("unknown")
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+
+	eventID, err := s.ReplayDeadLetter(d.ID)
+	assert.Nil(t, err, "should replay the dead letter")
+	waitChildren(t)
+	assert.NotEqual(t, "event1", eventID, "should replay as a new event")
+	assert.Len(t, s.ByEventID(eventID), 1, "should restart the workflow")
+	assert.Equal(t, "deploy", s.ByEventID(eventID)[0].GetName())
+	assert.Len(t, sent, 2, "should call the function again")
+	list, _ = s.ListDeadLetters()
+	assert.Empty(t, list, "should remove the replayed dead letter")
+	_, err = s.ReplayDeadLetter(d.ID)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound, "should not replay twice")
+}
+
+func TestDeadLetterSkipped(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).AnyTimes().Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	wf := &config.Workflow{Steps: []config.Workflow{{CallDriver: "foo.bar"}}}
+	s.StartSession(wf, &dipper.Message{}, map[string]interface{}{})
+	waitChildren(t)
+	s.ContinueSession(sent[0].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusFailure, "reason": "not ready"},
+	}, nil)
+	waitChildren(t)
+
+	s.StartSession(wf, &dipper.Message{Labels: map[string]string{"eventID": "event2"}}, map[string]interface{}{})
+	waitChildren(t)
+	s.CancelEvent("event2", ReasonCancelled)
+	waitChildren(t)
+
+	assert.Zero(t, s.Len(), "should complete the sessions")
+	list, err := s.ListDeadLetters()
+	assert.Nil(t, err)
+	assert.Empty(t, list, "should not record failures or cancelled sessions")
+}
+
+func TestSessionSourcePersisted(t *testing.T) {
+	backend := NewMemoryBackend(0)
+	s, helper := newPersistTestStore(t, backend)
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1)
+	s.StartSession(&config.Workflow{CallDriver: "foo.bar"}, &dipper.Message{
+		Labels: map[string]string{"eventID": "event1"},
+	}, map[string]interface{}{"env": "prod"})
+	waitChildren(t)
+
+	data, _ := backend.Load()
+	assert.Len(t, data, 1, "should checkpoint the session")
+	for _, record := range data {
+		w, err := s.restoreSession(record)
+		assert.Nil(t, err, "should restore the session")
+		assert.Equal(t, "foo.bar", w.source.Workflow.CallDriver, "should restore the source workflow")
+		assert.Equal(t, "event1", w.source.Labels["eventID"])
+		assert.Equal(t, map[string]interface{}{"env": "prod"}, w.source.Ctx, "should restore the source context data")
+	}
+}
+
+func TestMemoryBackendLimit(t *testing.T) {
+	backend := NewMemoryBackend(2)
+	for _, id := range []string{"a", "b", "b", "c"} {
+		assert.Nil(t, backend.Save(id, []byte(id)))
+	}
+	records, _ := backend.Load()
+	assert.Equal(t, map[string][]byte{"b": []byte("b"), "c": []byte("c")}, records, "should drop the oldest records")
+
+	assert.Nil(t, backend.Delete("b"))
+	assert.Nil(t, backend.Save("d", []byte("d")))
+	records, _ = backend.Load()
+	assert.Equal(t, map[string][]byte{"c": []byte("c"), "d": []byte("d")}, records, "should not count the deleted records")
+}
+
+func TestDeadLetterSanitized(t *testing.T) {
+	d := &DeadLetter{
+		ID:   "dead1",
+		Data: map[string]interface{}{"token": "abc", "service": "web"},
+		Ctx:  map[string]interface{}{"password": "secret", "key": "ENC[gcloud-kms,xxx]", "env": "prod"},
+		Source: &SessionSource{
+			Payload: map[string]interface{}{"data": map[string]interface{}{"token": "abc"}},
+			Ctx:     map[string]interface{}{"password": "secret"},
+		},
+	}
+
+	sanitized := d.Sanitized()
+	assert.Equal(t, map[string]interface{}{"token": dipper.RedactedValue, "service": "web"}, sanitized.Data)
+	assert.Equal(t, map[string]interface{}{"password": dipper.RedactedValue, "key": dipper.RedactedValue, "env": "prod"}, sanitized.Ctx)
+	assert.Equal(t, map[string]interface{}{"data": map[string]interface{}{"token": dipper.RedactedValue}}, sanitized.Source.Payload)
+	assert.Equal(t, map[string]interface{}{"password": dipper.RedactedValue}, sanitized.Source.Ctx)
+	assert.Equal(t, "secret", d.Ctx["password"], "should keep the dead letter for replaying")
+	assert.Equal(t, "secret", d.Source.Ctx["password"], "should keep the source for replaying")
+}
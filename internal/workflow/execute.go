@@ -38,18 +38,8 @@ func (w *Session) execute(msg *dipper.Message) {
 	switch {
 	case w.checkCondition() && w.checkLoopCondition(msg):
 		if !w.isIteration() || w.lenOfIterate() > 0 {
-			w.loopCount = 0
-			if w.ID == "" {
-				daemon.Children.Add(1)
-				go func() {
-					defer daemon.Children.Done()
-					defer dipper.SafeExitOnError("Failed in execute %+v", *w.workflow)
-					w.save()
-					defer w.onError()
-					w.executeRound(msg)
-				}()
-			} else {
-				w.executeRound(msg)
+			if w.acquireSlot(msg) {
+				w.run(msg)
 			}
 		} else if w.parent != "" {
 			w.noop(msg)
@@ -61,6 +51,7 @@ func (w *Session) execute(msg *dipper.Message) {
 			panic(err)
 		}
 		w.elseBranch = &elseBranch
+		w.startTimeout()
 		daemon.Children.Add(1)
 		go func() {
 			defer daemon.Children.Done()
@@ -75,6 +66,27 @@ func (w *Session) execute(msg *dipper.Message) {
 	}
 }
 
+// run starts the rounds of the workflow.
+func (w *Session) run(msg *dipper.Message) {
+	w.loopCount = 0
+	if w.ID == "" {
+		daemon.Children.Add(1)
+		go func() {
+			defer daemon.Children.Done()
+			w.execLock.Lock()
+			defer w.execLock.Unlock()
+			defer dipper.SafeExitOnError("Failed in execute %+v", *w.workflow)
+			w.save()
+			w.startTimeout()
+			defer w.onError()
+			w.executeRound(msg)
+		}()
+	} else {
+		w.startTimeout()
+		w.executeRound(msg)
+	}
+}
+
 // noop continues the workflow as doing nothing.
 func (w *Session) noop(msg *dipper.Message) {
 	if msg.Labels["status"] != "success" {
@@ -115,6 +127,10 @@ func (w *Session) executeRound(msg *dipper.Message) {
 		}
 		w.ctx["resume_token"] = w.store.Helper.GetDaemonID() + "/" + w.workflow.Name + "/" + w.ID
 	}
+	if w.workflow.Retry != "" {
+		w.retryCount = 0
+		w.ctx["retry_count"] = 0
+	}
 
 	w.executeIteration(msg)
 }
@@ -208,58 +224,69 @@ func (w *Session) startWait() {
 	if !ok || resumeToken == "" {
 		dipper.Logger.Panicf("[workflow] wait identifier missing for session %s", w.ID)
 	}
-	oldWaiterSession, ok := w.store.suspendedSessions[resumeToken]
-	if ok {
+	if oldWaiterSession, ok := w.store.suspend(resumeToken, w.ID); !ok {
 		dipper.Logger.Panicf("[workflow] wait identifier collided for sessions %s and %s", w.ID, oldWaiterSession)
 	}
-	w.store.suspendedSessions[resumeToken] = w.ID
+	w.resumeToken = resumeToken
 
+	var d time.Duration
 	if strings.ToLower(w.workflow.Wait) != "infinite" {
-		d, err := time.ParseDuration(w.workflow.Wait)
+		var err error
+		d, err = time.ParseDuration(w.workflow.Wait)
 		if err != nil {
 			dipper.Logger.Panicf("[workflow] fail to time.ParseDuration '%s' for %+v", w.workflow.Wait, resumeToken)
 		}
-		daemon.Children.Add(1)
-		go func() {
-			defer daemon.Children.Done()
-			defer dipper.SafeExitOnError("[workflow] resuming session on timeout failed %+v", resumeToken)
+		w.waitUntil = time.Now().Add(d)
+	}
+	w.checkpoint(nil)
 
-			timeoutStatus, _ := dipper.GetMapDataStr(w.ctx, "timeout_status")
-			reason := "timeout"
-			if timeoutStatus == "" {
-				timeoutStatus = SessionStatusSuccess
-				reason = ""
-			}
-			timeoutPayload := w.ctx["return_on_timeout"]
+	if !w.waitUntil.IsZero() {
+		w.waitTimeout(resumeToken, d)
+	}
+}
 
-			timer, cancel := context.WithTimeout(context.Background(), d)
-			defer cancel()
-			w.ctx["_wait_timer"] = timer
-			<-timer.Done()
+// waitTimeout resumes the suspended session after the duration unless resumed earlier.
+func (w *Session) waitTimeout(resumeToken string, d time.Duration) {
+	daemon.Children.Add(1)
+	go func() {
+		defer daemon.Children.Done()
+		defer dipper.SafeExitOnError("[workflow] resuming session on timeout failed %+v", resumeToken)
+
+		timeoutStatus, _ := dipper.GetMapDataStr(w.ctx, "timeout_status")
+		reason := "timeout"
+		if timeoutStatus == "" {
+			timeoutStatus = SessionStatusSuccess
+			reason = ""
+		}
+		timeoutPayload := w.ctx["return_on_timeout"]
 
-			if _, ok := w.ctx["_wait_timer"]; !ok {
-				return
-			}
-			delete(w.ctx, "_wait_timer")
-
-			dipper.Logger.Infof("[workflow] resuming session on timeout %+v", resumeToken)
-
-			daemon.Children.Add(1)
-			go func() {
-				defer daemon.Children.Done()
-				w.store.ResumeSession(resumeToken, &dipper.Message{
-					Payload: map[string]interface{}{
-						"key": resumeToken,
-						"labels": map[string]interface{}{
-							"status": timeoutStatus,
-							"reason": reason,
-						},
-						"payload": timeoutPayload,
+		timer, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+		w.ctx["_wait_timer"] = timer
+		<-timer.Done()
+
+		if _, ok := w.ctx["_wait_timer"]; !ok {
+			return
+		}
+		delete(w.ctx, "_wait_timer")
+
+		dipper.Logger.Infof("[workflow] resuming session on timeout %+v", resumeToken)
+
+		daemon.Children.Add(1)
+		go func() {
+			defer daemon.Children.Done()
+			w.store.ResumeSession(resumeToken, &dipper.Message{
+				Payload: map[string]interface{}{
+					"key": resumeToken,
+					"labels": map[string]interface{}{
+						"status": timeoutStatus,
+						"reason": reason,
 					},
-				})
-			}()
+					"payload": timeoutPayload,
+				},
+			})
 		}()
-	}
+	}()
 }
 
 // executeSwitch will select branch to execute based on the given string.
@@ -310,6 +337,8 @@ func (w *Session) processActionHooks(msg *dipper.Message) bool {
 		fallthrough
 	case w.workflow.Wait != "":
 		fallthrough
+	case w.workflow.Approval != nil:
+		fallthrough
 	case w.workflow.Switch != "":
 		if w.loopCount == 0 && int(w.iteration) == 0 && int(w.current) == 0 {
 			w.fireHook("on_first_action", msg)
@@ -325,10 +354,17 @@ func (w *Session) processActionHooks(msg *dipper.Message) bool {
 
 // executeAction takes actions for a single iteration in a single loop round.
 func (w *Session) executeAction(msg *dipper.Message) {
+	// kept for retrying the action
+	w.retryMsg = msg
 	if w.processActionHooks(msg) { // hook in progress
 		return
 	}
 
+	w.dispatchAction(msg)
+}
+
+// dispatchAction takes the action of the workflow without firing the action hooks.
+func (w *Session) dispatchAction(msg *dipper.Message) {
 	switch {
 	case w.workflow.Workflow != "":
 		envData := w.buildEnvData(msg)
@@ -362,6 +398,7 @@ func (w *Session) executeAction(msg *dipper.Message) {
 	case w.workflow.Steps != nil:
 		w.performing = "steps"
 		w.current = 0
+		w.compensations = nil
 		w.executeStep(msg)
 	case w.workflow.Threads != nil:
 		w.performing = "threads"
@@ -370,6 +407,9 @@ func (w *Session) executeAction(msg *dipper.Message) {
 	case w.workflow.Wait != "":
 		w.performing = "suspending"
 		w.startWait()
+	case w.workflow.Approval != nil:
+		w.performing = "waiting for approval"
+		w.startApproval()
 	case w.workflow.Switch != "":
 		w.performing = "switch"
 		w.executeSwitch(msg)
@@ -431,6 +471,7 @@ func (w *Session) callShorthandFunction(f string, msg *dipper.Message) {
 func (w *Session) callFunction(f *config.Function, msg *dipper.Message) {
 	// stored for doing export context later
 	w.inFlyFunction = f
+	w.checkpoint(msg)
 
 	payload := w.buildEnvData(msg)
 	payload["function"] = *f
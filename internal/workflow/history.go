@@ -0,0 +1,168 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// DefaultHistoryLimit is the default number of the events kept in the history.
+const DefaultHistoryLimit = 1000
+
+// SessionStatusRunning is the status of the session still running in the history.
+const SessionStatusRunning = "running"
+
+// SessionHistory is the audit record of a session along with its child sessions.
+type SessionHistory struct {
+	SessionID      string            `json:"sessionID"`
+	Name           string            `json:"name,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Performing     string            `json:"performing"`
+	Function       string            `json:"function,omitempty"`
+	Params         interface{}       `json:"params,omitempty"`
+	Hook           bool              `json:"hook,omitempty"`
+	Retries        int               `json:"retries,omitempty"`
+	Status         string            `json:"status"`
+	Reason         string            `json:"reason,omitempty"`
+	StartTime      time.Time         `json:"startTime"`
+	CompletionTime *time.Time        `json:"completionTime,omitempty"`
+	Children       []*SessionHistory `json:"children,omitempty"`
+}
+
+// EventHistory keeps the history of the completed top-level sessions by event, the oldest events are
+// dropped when exceeding the limit.
+type EventHistory struct {
+	limit  int
+	events map[string][]*SessionHistory
+	order  []string
+	lock   sync.Mutex
+}
+
+// NewEventHistory creates an empty history keeping up to limit events.
+func NewEventHistory(limit int) *EventHistory {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	return &EventHistory{
+		limit:  limit,
+		events: map[string][]*SessionHistory{},
+	}
+}
+
+// add records the history of a completed top-level session.
+func (h *EventHistory) add(eventID string, node *SessionHistory) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.events[eventID]; !ok {
+		h.order = append(h.order, eventID)
+		for len(h.order) > h.limit {
+			delete(h.events, h.order[0])
+			h.order = h.order[1:]
+		}
+	}
+	h.events[eventID] = append(h.events[eventID], node)
+}
+
+// get returns the history of the completed top-level sessions of the event.
+func (h *EventHistory) get(eventID string) []*SessionHistory {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]*SessionHistory{}, h.events[eventID]...)
+}
+
+// historyNode builds the audit record of the session, with the completed child sessions, and the
+// running child sessions in children.
+func (w *Session) historyNode(msg *dipper.Message, children map[string][]*Session) *SessionHistory {
+	w.ctxLock.Lock()
+	node := &SessionHistory{
+		SessionID:   w.ID,
+		Name:        w.workflow.Name,
+		Description: w.workflow.Description,
+		Performing:  w.performing,
+		Hook:        w.isHook,
+		Retries:     w.retryCount,
+		Status:      SessionStatusRunning,
+		StartTime:   w.startTime,
+		Children:    append([]*SessionHistory{}, w.history...),
+	}
+	w.ctxLock.Unlock()
+
+	if f := w.inFlyFunction; f != nil {
+		if f.Driver != "" {
+			node.Function = f.Driver + "." + f.RawAction
+		} else {
+			node.Function = f.Target.System + "." + f.Target.Function
+		}
+		if f.Parameters != nil {
+			node.Params = dipper.SanitizedData(f.Parameters)
+		}
+	}
+	if msg != nil {
+		completionTime := time.Now()
+		node.CompletionTime = &completionTime
+		node.Status = msg.Labels["status"]
+		node.Reason = msg.Labels["reason"]
+	}
+	for _, child := range children[w.ID] {
+		node.Children = append(node.Children, child.historyNode(nil, children))
+	}
+
+	return node
+}
+
+// recordHistory adds the audit record of the completed session to its parent, or to the history of
+// the event if it is a top-level session.
+func (w *Session) recordHistory(msg *dipper.Message) {
+	node := w.historyNode(msg, nil)
+	if w.parent == "" {
+		w.store.History.add(w.EventID, node)
+
+		return
+	}
+	if p, ok := dipper.IDMapGet(&w.store.sessions, w.parent).(*Session); ok {
+		p.ctxLock.Lock()
+		defer p.ctxLock.Unlock()
+		p.history = append(p.history, node)
+	}
+}
+
+// GetHistory returns the audit records of the top-level sessions of the event, including the running
+// ones, nil if the event is not found.
+func (s *SessionStore) GetHistory(eventID string) []*SessionHistory {
+	ret := s.History.get(eventID)
+	children := s.childSessions()
+	for _, sh := range s.ByEventID(eventID) {
+		if w, ok := sh.(*Session); ok {
+			ret = append(ret, w.historyNode(nil, children))
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+
+	return ret
+}
+
+// FindHistory returns the audit record of the session in the history trees, nil if not found.
+func FindHistory(history []*SessionHistory, sessionID string) *SessionHistory {
+	for _, node := range history {
+		if node.SessionID == sessionID {
+			return node
+		}
+		if found := FindHistory(node.Children, sessionID); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHistoryLimit(t *testing.T) {
+	h := NewEventHistory(2)
+	h.add("e1", &SessionHistory{SessionID: "1"})
+	h.add("e2", &SessionHistory{SessionID: "2"})
+	h.add("e2", &SessionHistory{SessionID: "3"})
+	assert.Len(t, h.get("e2"), 2, "should keep all the sessions of the event")
+
+	h.add("e3", &SessionHistory{SessionID: "4"})
+	assert.Empty(t, h.get("e1"), "should drop the oldest event")
+	assert.Len(t, h.get("e2"), 2)
+	assert.Len(t, h.get("e3"), 1)
+	assert.Equal(t, DefaultHistoryLimit, NewEventHistory(0).limit, "should use the default limit")
+}
+
+func TestSessionHistory(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(2).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		Name: "deploy",
+		Steps: []config.Workflow{
+			{CallDriver: "foo.bar", Local: map[string]interface{}{"service": "web", "password": "ENC[gpg,abc]"}},
+			{CallDriver: "foo.baz"},
+		},
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event1"}}, map[string]interface{}{})
+	waitChildren(t)
+	assert.Nil(t, s.GetHistory("unknown"), "should not find unknown event")
+
+	history := s.GetHistory("event1")
+	assert.Len(t, history, 1, "should include the running session")
+	assert.Equal(t, "deploy", history[0].Name)
+	assert.Equal(t, SessionStatusRunning, history[0].Status)
+	assert.Len(t, history[0].Children, 1, "should include the running step")
+	step := history[0].Children[0]
+	assert.Equal(t, "foo.bar", step.Function, "should record the function called")
+	assert.Equal(t, map[string]interface{}{"service": "web", "password": dipper.RedactedValue}, step.Params, "should redact the params")
+	assert.Nil(t, step.CompletionTime)
+
+	s.ContinueSession(sent[0].Labels["sessionID"], Success(), nil)
+	waitChildren(t)
+	s.ContinueSession(sent[1].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusError, "reason": "boom"},
+	}, nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the session")
+
+	history = s.GetHistory("event1")
+	assert.Len(t, history, 1, "should keep the completed session")
+	assert.Equal(t, SessionStatusError, history[0].Status)
+	assert.Equal(t, "boom", history[0].Reason)
+	assert.NotNil(t, history[0].CompletionTime)
+	assert.Len(t, history[0].Children, 2, "should record all the steps")
+	assert.Equal(t, SessionStatusSuccess, history[0].Children[0].Status)
+	assert.Equal(t, "driver foo.baz", history[0].Children[1].Performing)
+	assert.Equal(t, SessionStatusError, history[0].Children[1].Status)
+
+	found := FindHistory(history, history[0].Children[1].SessionID)
+	assert.Equal(t, history[0].Children[1], found, "should find the session in the tree")
+	assert.Nil(t, FindHistory(history, "unknown"))
+}
@@ -0,0 +1,482 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// DefaultSessionKey is the default key of the redis hash storing the sessions.
+const DefaultSessionKey = "honeydipper/sessions"
+
+// ErrPersistence is the base error for failing to persist or recover the sessions.
+var ErrPersistence = errors.New("session persistence error")
+
+// SessionBackend persists the checkpoints of the sessions, so they can be recovered after the engine
+// restarts.  The checkpoints are opaque to the backend, and keyed by the session IDs.
+type SessionBackend interface {
+	Save(id string, data []byte) error
+	Delete(id string) error
+	Load() (map[string][]byte, error)
+}
+
+// messageRecord is the persisted form of a dipper message kept in a session.
+type messageRecord struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Payload interface{}       `json:"payload,omitempty"`
+}
+
+// sessionRecord is the persisted form of a session at a checkpoint.
+type sessionRecord struct {
+	EventID        string                   `json:"eventID"`
+	Parent         string                   `json:"parent,omitempty"`
+	Workflow       *config.Workflow         `json:"workflow"`
+	Current        int32                    `json:"current"`
+	Iteration      int32                    `json:"iteration"`
+	LoopCount      int                      `json:"loopCount"`
+	RetryCount     int                      `json:"retryCount,omitempty"`
+	Ctx            map[string]interface{}   `json:"ctx"`
+	Event          map[string]interface{}   `json:"event"`
+	Exported       []map[string]interface{} `json:"exported,omitempty"`
+	ElseBranch     *config.Workflow         `json:"elseBranch,omitempty"`
+	InFlyFunction  *config.Function         `json:"inFlyFunction,omitempty"`
+	LoadedContexts []string                 `json:"loadedContexts,omitempty"`
+	CurrentHook    string                   `json:"currentHook,omitempty"`
+	Performing     string                   `json:"performing"`
+	IsHook         bool                     `json:"isHook,omitempty"`
+	ActionMsg      *messageRecord           `json:"actionMsg,omitempty"`
+	SavedMsg       *messageRecord           `json:"savedMsg,omitempty"`
+	OrigMsg        *messageRecord           `json:"origMsg,omitempty"`
+	IterationOut   *messageRecord           `json:"iterationOut,omitempty"`
+	RetryMsg       *messageRecord           `json:"retryMsg,omitempty"`
+	Compensations  []int                    `json:"compensations,omitempty"`
+	Compensating   *messageRecord           `json:"compensating,omitempty"`
+	Compensated    []interface{}            `json:"compensated,omitempty"`
+	ResumeToken    string                   `json:"resumeToken,omitempty"`
+	WaitUntil      time.Time                `json:"waitUntil"`
+	StartTime      time.Time                `json:"startTime"`
+	Source         *SessionSource           `json:"source,omitempty"`
+	History        []*SessionHistory        `json:"history,omitempty"`
+	Slot           string                   `json:"slot,omitempty"`
+	SlotOwner      string                   `json:"slotOwner,omitempty"`
+}
+
+func recordMessage(msg *dipper.Message) *messageRecord {
+	if msg == nil {
+		return nil
+	}
+
+	return &messageRecord{Labels: msg.Labels, Payload: msg.Payload}
+}
+
+func (m *messageRecord) message() *dipper.Message {
+	if m == nil {
+		return nil
+	}
+	if m.Labels == nil {
+		m.Labels = map[string]string{}
+	}
+
+	return &dipper.Message{
+		Channel: dipper.ChannelEventbus,
+		Subject: dipper.EventbusReturn,
+		Labels:  m.Labels,
+		Payload: m.Payload,
+	}
+}
+
+// checkpoint persists the state of the session, so it can be resumed from here with the message
+// if the engine restarts.
+func (w *Session) checkpoint(msg *dipper.Message) {
+	w.ctxLock.Lock()
+	if msg != nil {
+		w.actionMsg = msg
+	}
+	backend := w.store.Backend
+	if backend == nil || w.ID == "" {
+		w.ctxLock.Unlock()
+
+		return
+	}
+	data, err := json.Marshal(w.record())
+	w.ctxLock.Unlock()
+
+	if err == nil {
+		err = backend.Save(w.ID, data)
+	}
+	if err != nil {
+		dipper.Logger.Warningf("[workflow] unable to checkpoint session [%s]: %v", w.ID, err)
+	}
+}
+
+// record builds the persisted form of the session, the timer of the wait is left out.
+func (w *Session) record() *sessionRecord {
+	ctx := make(map[string]interface{}, len(w.ctx))
+	for k, v := range w.ctx {
+		if k != "_wait_timer" {
+			ctx[k] = v
+		}
+	}
+
+	return &sessionRecord{
+		EventID:        w.EventID,
+		Parent:         w.parent,
+		Workflow:       w.workflow,
+		Current:        w.current,
+		Iteration:      w.iteration,
+		LoopCount:      w.loopCount,
+		RetryCount:     w.retryCount,
+		Ctx:            ctx,
+		Event:          w.event,
+		Exported:       w.exported,
+		ElseBranch:     w.elseBranch,
+		InFlyFunction:  w.inFlyFunction,
+		LoadedContexts: w.loadedContexts,
+		CurrentHook:    w.currentHook,
+		Performing:     w.performing,
+		IsHook:         w.isHook,
+		ActionMsg:      recordMessage(w.actionMsg),
+		SavedMsg:       recordMessage(w.savedMsg),
+		OrigMsg:        recordMessage(w.origMsg),
+		IterationOut:   recordMessage(w.iterationOut),
+		RetryMsg:       recordMessage(w.retryMsg),
+		Compensations:  w.compensations,
+		Compensating:   recordMessage(w.compensating),
+		Compensated:    w.compensated,
+		ResumeToken:    w.resumeToken,
+		WaitUntil:      w.waitUntil,
+		StartTime:      w.startTime,
+		Source:         w.source,
+		History:        w.history,
+		Slot:           w.slot,
+		SlotOwner:      w.slotOwner,
+	}
+}
+
+// restoreSession rebuilds a session from its checkpoint, the session is not yet given an ID.
+func (s *SessionStore) restoreSession(data []byte) (*Session, error) {
+	r := &sessionRecord{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPersistence, err)
+	}
+	if r.Workflow == nil {
+		return nil, fmt.Errorf("%w: missing workflow", ErrPersistence)
+	}
+	if r.Ctx == nil {
+		r.Ctx = map[string]interface{}{}
+	}
+
+	return &Session{
+		EventID:        r.EventID,
+		parent:         r.Parent,
+		workflow:       r.Workflow,
+		current:        r.Current,
+		iteration:      r.Iteration,
+		iterationLock:  &sync.Mutex{},
+		iterationOut:   r.IterationOut.message(),
+		loopCount:      r.LoopCount,
+		retryCount:     r.RetryCount,
+		retryMsg:       r.RetryMsg.message(),
+		compensations:  r.Compensations,
+		compensating:   r.Compensating.message(),
+		compensated:    r.Compensated,
+		ctx:            r.Ctx,
+		ctxLock:        &sync.Mutex{},
+		execLock:       &sync.Mutex{},
+		event:          r.Event,
+		exported:       r.Exported,
+		elseBranch:     r.ElseBranch,
+		inFlyFunction:  r.InFlyFunction,
+		store:          s,
+		loadedContexts: r.LoadedContexts,
+		currentHook:    r.CurrentHook,
+		savedMsg:       r.SavedMsg.message(),
+		origMsg:        r.OrigMsg.message(),
+		actionMsg:      r.ActionMsg.message(),
+		performing:     r.Performing,
+		isHook:         r.IsHook,
+		resumeToken:    r.ResumeToken,
+		waitUntil:      r.WaitUntil,
+		startTime:      r.StartTime,
+		source:         r.Source,
+		history:        r.History,
+		slot:           r.Slot,
+		slotOwner:      r.SlotOwner,
+	}, nil
+}
+
+// deleteCheckpoint removes the checkpoint of a completed session.
+func (s *SessionStore) deleteCheckpoint(id string) {
+	if s.Backend != nil {
+		if err := s.Backend.Delete(id); err != nil {
+			dipper.Logger.Warningf("[workflow] unable to delete checkpoint of session [%s]: %v", id, err)
+		}
+	}
+}
+
+// Recover resumes the sessions persisted in the backend from their last checkpoints, then keeps
+// persisting the sessions in the backend.  The recovered sessions are given new IDs, so they don't
+// collide with the sessions started before the recovery, and their old checkpoints are only deleted
+// after the new ones are saved.  The checkpoints unable to recover are kept in the backend.
+func (s *SessionStore) Recover(backend SessionBackend) error {
+	records, err := backend.Load()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPersistence, err)
+	}
+
+	sessions := map[string]*Session{}
+	for id, data := range records {
+		w, err := s.restoreSession(data)
+		if err != nil {
+			dipper.Logger.Warningf("[workflow] keeping checkpoint of session [%s] unable to recover: %v", id, err)
+
+			continue
+		}
+		sessions[id] = w
+	}
+
+	s.assignRecoveredIDs(sessions, records)
+	waiting := map[string]bool{}
+	for id, w := range sessions {
+		if w.parent == "" {
+			continue
+		}
+		if p, ok := sessions[w.parent]; ok {
+			w.parent = p.ID
+			waiting[p.ID] = true
+		} else {
+			dipper.Logger.Warningf("[workflow] recovered session [%s] lost its parent [%s], running detached", id, w.parent)
+			w.parent = ""
+		}
+	}
+
+	s.Backend = backend
+	saved := map[string]bool{}
+	for _, w := range sessions {
+		w.checkpoint(nil)
+		saved[w.ID] = true
+	}
+	for id := range sessions {
+		if !saved[id] {
+			s.deleteCheckpoint(id)
+		}
+	}
+	for _, w := range sessions {
+		w.startTimeout()
+		if !waiting[w.ID] {
+			// sessions waiting for child sessions are continued by the children.
+			daemon.Children.Add(1)
+			go w.resume()
+		}
+	}
+	dipper.Logger.Infof("[workflow] recovered %d sessions", len(sessions))
+
+	return nil
+}
+
+// assignRecoveredIDs gives the recovered sessions new IDs, avoiding the IDs of the other checkpoints
+// so saving a session does not overwrite a checkpoint not yet replaced.
+func (s *SessionStore) assignRecoveredIDs(sessions map[string]*Session, records map[string][]byte) {
+	reserved := []string{}
+	for oldID, w := range sessions {
+		for {
+			w.ID = dipper.IDMapPut(&s.sessions, w)
+			if _, taken := records[w.ID]; !taken || w.ID == oldID {
+				break
+			}
+			reserved = append(reserved, w.ID)
+		}
+	}
+	for _, id := range reserved {
+		dipper.IDMapDel(&s.sessions, id)
+	}
+}
+
+// resume continues a recovered session from its last checkpoint, the action in progress when the
+// checkpoint was made is taken again.
+func (w *Session) resume() {
+	defer daemon.Children.Done()
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	defer dipper.SafeExitOnError("[workflow] error when resuming recovered session %s", w.ID)
+	defer w.onError()
+
+	msg := w.actionMsg
+	if msg == nil {
+		msg = &dipper.Message{Labels: map[string]string{}}
+	}
+	dipper.Logger.Infof("[workflow] resuming recovered session [%s] %s", w.ID, w.performing)
+	if w.slot != "" {
+		w.keepSlot()
+	}
+
+	switch {
+	case w.resumeToken != "":
+		w.store.suspend(w.resumeToken, w.ID)
+		if !w.waitUntil.IsZero() {
+			w.waitTimeout(w.resumeToken, time.Until(w.waitUntil))
+		}
+	case w.currentHook != "":
+		hookBlock, ok := dipper.GetMapData(w.ctx, "hooks."+w.currentHook)
+		if !ok {
+			w.continueAfterHook(Success())
+
+			return
+		}
+		w.executeHook(w.savedMsg, hookBlock)
+	case w.elseBranch != nil:
+		child := w.createChildSession(w.elseBranch, msg)
+		child.execute(msg)
+	case w.workflow.IterateParallel != nil:
+		w.iteration, w.iterationOut = 0, nil
+		if w.origMsg != nil {
+			msg = w.origMsg
+		}
+		w.launchParallelIterations(msg)
+	case len(w.workflow.Steps) > 0:
+		w.executeStep(msg)
+	default:
+		w.dispatchAction(msg)
+	}
+}
+
+// FileBackend persists the sessions as json files in a directory.
+type FileBackend struct {
+	Dir string
+}
+
+// NewFileBackend creates a file backend persisting the sessions in the directory.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPersistence, err)
+	}
+
+	return &FileBackend{Dir: dir}, nil
+}
+
+func (b *FileBackend) path(id string) string {
+	return filepath.Join(b.Dir, id+".json")
+}
+
+// Save writes the checkpoint to a temporary file then renames it, so a crash never leaves a partial
+// checkpoint behind.
+func (b *FileBackend) Save(id string, data []byte) error {
+	f, err := os.CreateTemp(b.Dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), b.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// Delete removes the checkpoint.
+func (b *FileBackend) Delete(id string) error {
+	if err := os.Remove(b.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Load reads all the checkpoints in the directory.
+func (b *FileBackend) Load() (map[string][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(b.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string][]byte{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ret[strings.TrimSuffix(filepath.Base(file), ".json")] = data
+	}
+
+	return ret, nil
+}
+
+// CacheBackend persists the sessions in a redis hash through the cache feature, e.g. the
+// redis-cache driver.
+type CacheBackend struct {
+	Caller dipper.RPCCaller
+	Key    string
+}
+
+// NewCacheBackend creates a backend persisting the sessions in the hash with the key in cache.
+func NewCacheBackend(caller dipper.RPCCaller, key string) *CacheBackend {
+	if key == "" {
+		key = DefaultSessionKey
+	}
+
+	return &CacheBackend{Caller: caller, Key: key}
+}
+
+// Save stores the checkpoint in the hash.
+func (b *CacheBackend) Save(id string, data []byte) error {
+	_, err := b.Caller.Call("cache", "hset", map[string]interface{}{
+		"key":   b.Key,
+		"field": id,
+		"value": string(data),
+	})
+
+	return err
+}
+
+// Delete removes the checkpoint from the hash.
+func (b *CacheBackend) Delete(id string) error {
+	_, err := b.Caller.Call("cache", "hdel", map[string]interface{}{
+		"key":   b.Key,
+		"field": id,
+	})
+
+	return err
+}
+
+// Load reads all the checkpoints in the hash.
+func (b *CacheBackend) Load() (map[string][]byte, error) {
+	ret, err := b.Caller.Call("cache", "hgetall", map[string]interface{}{"key": b.Key})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	if len(ret) > 0 {
+		if err := json.Unmarshal(ret, &fields); err != nil {
+			return nil, err
+		}
+	}
+
+	data := make(map[string][]byte, len(fields))
+	for id, v := range fields {
+		data[id] = []byte(v)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/internal/workflow/mock_workflow"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBackend(t *testing.T) {
+	b, err := NewFileBackend(t.TempDir())
+	assert.Nil(t, err, "should create the directory")
+
+	assert.Nil(t, b.Save("1", []byte(`{"a":1}`)), "should save")
+	assert.Nil(t, b.Save("1", []byte(`{"a":2}`)), "should overwrite")
+	assert.Nil(t, b.Save("2", []byte(`{}`)), "should save")
+	assert.Nil(t, b.Delete("2"), "should delete")
+	assert.Nil(t, b.Delete("3"), "should ignore missing checkpoint")
+
+	data, err := b.Load()
+	assert.Nil(t, err, "should load")
+	assert.Equal(t, map[string][]byte{"1": []byte(`{"a":2}`)}, data, "should load the saved checkpoints")
+}
+
+type fakeHashCaller struct {
+	dipper.RPCCaller
+	hash map[string]string
+}
+
+func (c *fakeHashCaller) Call(feature string, method string, params interface{}) ([]byte, error) {
+	field, _ := dipper.GetMapDataStr(params, "field")
+	switch method {
+	case "hset":
+		c.hash[field] = dipper.MustGetMapDataStr(params, "value")
+	case "hdel":
+		delete(c.hash, field)
+	case "hgetall":
+		return json.Marshal(c.hash)
+	}
+
+	return nil, nil
+}
+
+func TestCacheBackend(t *testing.T) {
+	caller := &fakeHashCaller{hash: map[string]string{}}
+	b := NewCacheBackend(caller, "")
+	assert.Equal(t, DefaultSessionKey, b.Key, "should use the default key")
+
+	assert.Nil(t, b.Save("1", []byte(`{"a":1}`)), "should save")
+	assert.Nil(t, b.Save("2", []byte(`{}`)), "should save")
+	assert.Nil(t, b.Delete("2"), "should delete")
+
+	data, err := b.Load()
+	assert.Nil(t, err, "should load")
+	assert.Equal(t, map[string][]byte{"1": []byte(`{"a":1}`)}, data, "should load the saved checkpoints")
+}
+
+func newPersistTestStore(t *testing.T, backend SessionBackend) (*SessionStore, *mock_workflow.MockSessionStoreHelper) {
+	t.Helper()
+
+	testDataSet := &config.DataSet{}
+	assert.Nil(t, yaml.Unmarshal([]byte(configStr), testDataSet), "test config")
+
+	ctrl := gomock.NewController(t)
+	helper := mock_workflow.NewMockSessionStoreHelper(ctrl)
+	helper.EXPECT().GetConfig().AnyTimes().Return(&config.Config{DataSet: testDataSet})
+	helper.EXPECT().GetDaemonID().AnyTimes().Return("")
+
+	s := NewSessionStore(helper)
+	s.Backend = backend
+	t.Cleanup(func() { delete(dipper.IDMapMetadata, &s.sessions) })
+
+	return s, helper
+}
+
+func waitChildren(t *testing.T) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		daemon.Children.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout due to go routine leak")
+	}
+}
+
+func TestSessionRecovery(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	assert.Nil(t, err, "should create the backend")
+
+	s, helper := newPersistTestStore(t, backend)
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1)
+	s.StartSession(&config.Workflow{
+		Steps: []config.Workflow{{CallDriver: "foo.bar"}, {CallDriver: "foo.baz"}},
+	}, &dipper.Message{}, map[string]interface{}{"key": "value"})
+	waitChildren(t)
+
+	data, _ := backend.Load()
+	assert.Len(t, data, 2, "should checkpoint the session and the step")
+
+	// the engine restarts
+	var sent *dipper.Message
+	s, helper = newPersistTestStore(t, nil)
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1).Do(func(msg *dipper.Message) { sent = msg })
+	assert.Nil(t, s.Recover(backend), "should recover")
+	waitChildren(t)
+
+	assert.Equal(t, 2, s.Len(), "should recover the session and the step")
+	assert.NotNil(t, sent, "should call the driver again")
+	assert.Equal(t, "bar", sent.Payload.(map[string]interface{})["function"].(config.Function).RawAction, "should resume from the first step")
+	assert.Equal(t, "value", dipper.MustGetMapData(sent.Payload, "ctx.key"), "should recover the context")
+
+	helper.EXPECT().SendMessage(gomock.Any()).Times(1).Do(func(msg *dipper.Message) { sent = msg })
+	s.ContinueSession(sent.Labels["sessionID"], Success(), nil)
+	waitChildren(t)
+	assert.Equal(t, "baz", sent.Payload.(map[string]interface{})["function"].(config.Function).RawAction, "should continue to the next step")
+
+	s.ContinueSession(sent.Labels["sessionID"], Success(), nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the session")
+	data, _ = backend.Load()
+	assert.Empty(t, data, "should delete the checkpoints of completed sessions")
+}
+
+func TestSessionRecoveryWait(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	assert.Nil(t, err, "should create the backend")
+
+	s, _ := newPersistTestStore(t, backend)
+	s.StartSession(&config.Workflow{Wait: "infinite"}, &dipper.Message{}, map[string]interface{}{})
+	waitChildren(t)
+	assert.Len(t, s.suspendedSessions, 1, "should suspend the session")
+
+	// the engine restarts
+	s, _ = newPersistTestStore(t, nil)
+	assert.Nil(t, s.Recover(backend), "should recover")
+	waitChildren(t)
+
+	assert.Equal(t, 1, s.Len(), "should recover the suspended session")
+	var token string
+	for token = range s.suspendedSessions {
+	}
+	assert.Equal(t, "//0", token, "should wait on the original resume token")
+
+	s.ResumeSession(token, &dipper.Message{Payload: map[string]interface{}{
+		"labels": map[string]interface{}{"status": SessionStatusSuccess},
+	}})
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete the session")
+	data, _ := backend.Load()
+	assert.Empty(t, data, "should delete the checkpoint")
+}
+
+type orderedBackend struct {
+	*MemoryBackend
+	ops  []string
+	lock sync.Mutex
+}
+
+func (b *orderedBackend) Save(id string, data []byte) error {
+	b.lock.Lock()
+	b.ops = append(b.ops, "save")
+	b.lock.Unlock()
+
+	return b.MemoryBackend.Save(id, data)
+}
+
+func (b *orderedBackend) Delete(id string) error {
+	b.lock.Lock()
+	b.ops = append(b.ops, "delete")
+	b.lock.Unlock()
+
+	return b.MemoryBackend.Delete(id)
+}
+
+func TestSessionRecoveryCheckpoints(t *testing.T) {
+	backend := &orderedBackend{MemoryBackend: NewMemoryBackend(0)}
+	s, _ := newPersistTestStore(t, backend)
+	for _, token := range []string{"t1", "t2", "t3"} {
+		s.StartSession(&config.Workflow{Wait: "infinite"}, &dipper.Message{}, map[string]interface{}{"resume_token": token})
+	}
+	waitChildren(t)
+	assert.Nil(t, backend.Save("broken", []byte("{")))
+	records, _ := backend.Load()
+	assert.Len(t, records, 4, "should checkpoint the sessions")
+
+	// the engine restarts
+	s, _ = newPersistTestStore(t, nil)
+	backend.ops = nil
+	assert.Nil(t, s.Recover(backend), "should recover")
+	waitChildren(t)
+
+	assert.Equal(t, 3, s.Len(), "should recover the suspended sessions")
+	assert.ElementsMatch(t, []string{"t1", "t2", "t3"}, s.suspendedKeys(), "should wait on the original resume tokens")
+	records, _ = backend.Load()
+	assert.Equal(t, []byte("{"), records["broken"], "should keep the checkpoint unable to recover")
+	delete(records, "broken")
+	ids := []string{}
+	for id := range records {
+		ids = append(ids, id)
+	}
+	recovered := []string{}
+	for id := range s.sessions {
+		recovered = append(recovered, id)
+	}
+	assert.ElementsMatch(t, recovered, ids, "should replace the checkpoints of the recovered sessions")
+	for i, op := range backend.ops {
+		if op == "delete" {
+			assert.NotContains(t, backend.ops[i:], "save", "should save the new checkpoints before deleting the old ones")
+		}
+	}
+}
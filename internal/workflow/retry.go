@@ -0,0 +1,135 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package workflow
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/internal/daemon"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+// DefaultRetryBackoff is the default delay before the first retry.
+const DefaultRetryBackoff = time.Second
+
+// names of the backoff types.
+const (
+	BackoffFixed       = "fixed"
+	BackoffExponential = "exponential"
+	BackoffJittered    = "jittered"
+)
+
+// retryAction retries the action of the workflow if it ends with a status matching the retry_on
+// condition and there are retries left, returns false if not retrying.
+func (w *Session) retryAction(msg *dipper.Message) bool {
+	if w.workflow.Retry == "" {
+		return false
+	}
+
+	if msg.Labels["status"] == SessionStatusSuccess && w.iterationOut != nil {
+		msg = w.iterationOut
+	}
+	retries, err := strconv.Atoi(w.workflow.Retry)
+	if err != nil {
+		panic(fmt.Errorf("%w: invalid retry %q: %w", ErrWorkflowError, w.workflow.Retry, err))
+	}
+	if w.retryCount >= retries || !w.matchRetryOn(msg.Labels) {
+		// moving on, the next item or round gets its own retries
+		w.retryCount = 0
+		w.ctx["retry_count"] = 0
+
+		return false
+	}
+
+	delay := w.retryDelay(w.retryCount)
+	w.retryCount++
+	w.ctx["retry_count"] = w.retryCount
+	dipper.Logger.Infof("[workflow] session [%s] retrying %d/%d in %s after %s: %s", w.ID, w.retryCount, retries, delay, msg.Labels["status"], msg.Labels["reason"])
+
+	if delay <= 0 {
+		daemon.Children.Add(1)
+		go w.retry()
+
+		return true
+	}
+	// the pending retry is a timer, so it can be stopped when cancelling, and it does not hold up
+	// the shutdown
+	w.retryTimer = time.AfterFunc(delay, func() {
+		daemon.Children.Add(1)
+		w.retry()
+	})
+
+	return true
+}
+
+// retry runs the action again as a child of the daemon, unless the session is cancelled.
+func (w *Session) retry() {
+	defer daemon.Children.Done()
+	defer dipper.SafeExitOnError("[workflow] session [%s] error when retrying", w.ID)
+	defer w.onError()
+
+	w.execLock.Lock()
+	defer w.execLock.Unlock()
+	w.retryTimer = nil
+	if w.cancelled {
+		return
+	}
+	if w.workflow.IterateParallel != nil {
+		w.iteration, w.iterationOut = 0, nil
+		w.launchParallelIterations(w.origMsg)
+	} else {
+		w.executeAction(w.retryMsg)
+	}
+}
+
+// matchRetryOn checks the status and the reason against the retry_on condition, all the statuses
+// other than success are retried by default.
+func (w *Session) matchRetryOn(labels map[string]string) bool {
+	if labels["status"] == SessionStatusSuccess {
+		return false
+	}
+	if w.workflow.RetryOn == nil {
+		return true
+	}
+
+	return dipper.CompareAll(map[string]interface{}{
+		"status": labels["status"],
+		"reason": labels["reason"],
+	}, w.workflow.RetryOn)
+}
+
+// retryDelay calculates the delay before the retry following the given number of retries.
+func (w *Session) retryDelay(retried int) time.Duration {
+	backoff := DefaultRetryBackoff
+	if w.workflow.Backoff != "" {
+		backoff = dipper.Must(time.ParseDuration(w.workflow.Backoff)).(time.Duration)
+	}
+
+	switch w.workflow.BackoffType {
+	case "", BackoffFixed:
+	case BackoffExponential, BackoffJittered:
+		for i := 0; i < retried && backoff < time.Hour; i++ {
+			backoff *= 2
+		}
+	default:
+		panic(fmt.Errorf("%w: unknown backoff_type %s", ErrWorkflowError, w.workflow.BackoffType))
+	}
+
+	if w.workflow.MaxBackoff != "" {
+		backoff = min(backoff, dipper.Must(time.ParseDuration(w.workflow.MaxBackoff)).(time.Duration))
+	}
+	if w.workflow.BackoffType == BackoffJittered && backoff > 1 {
+		// equal jitter, somewhere between half and the full delay
+		half := backoff / 2
+		backoff = half + rand.N(backoff-half)
+	}
+
+	return backoff
+}
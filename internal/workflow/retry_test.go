@@ -0,0 +1,127 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package workflow
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/internal/config"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	w := &Session{workflow: &config.Workflow{}}
+	assert.Equal(t, DefaultRetryBackoff, w.retryDelay(3), "should use the default backoff")
+
+	w.workflow = &config.Workflow{Backoff: "10s", BackoffType: BackoffFixed}
+	assert.Equal(t, 10*time.Second, w.retryDelay(3), "should not grow with fixed backoff")
+
+	w.workflow = &config.Workflow{Backoff: "10s", BackoffType: BackoffExponential, MaxBackoff: "1m"}
+	assert.Equal(t, 10*time.Second, w.retryDelay(0), "should start with the backoff")
+	assert.Equal(t, 40*time.Second, w.retryDelay(2), "should double every retry")
+	assert.Equal(t, time.Minute, w.retryDelay(3), "should not go beyond max_backoff")
+
+	w.workflow = &config.Workflow{Backoff: "10s", BackoffType: BackoffJittered}
+	for i := 0; i < 10; i++ {
+		d := w.retryDelay(1)
+		assert.True(t, d >= 10*time.Second && d < 20*time.Second, "should jitter between half and full delay, got %s", d)
+	}
+
+	w.workflow = &config.Workflow{BackoffType: "linear"}
+	assert.Panics(t, func() { w.retryDelay(0) }, "should panic with unknown backoff type")
+}
+
+func TestWorkflowRetry(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(3).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		CallDriver: "foo.bar",
+		Retry:      "2",
+		Backoff:    "0s",
+	}, &dipper.Message{}, map[string]interface{}{})
+	waitChildren(t)
+	assert.Len(t, sent, 1, "should call the driver")
+	assert.Equal(t, 0, dipper.MustGetMapData(sent[0].Payload, "ctx.retry_count"), "should start with retry_count 0")
+
+	failed := &dipper.Message{Labels: map[string]string{"status": SessionStatusError, "reason": "boom"}}
+	for i := 1; i <= 2; i++ {
+		s.ContinueSession(sent[i-1].Labels["sessionID"], failed, nil)
+		waitChildren(t)
+		assert.Len(t, sent, i+1, "should retry the driver call")
+		assert.Equal(t, i, dipper.MustGetMapData(sent[i].Payload, "ctx.retry_count"), "should expose retry_count")
+	}
+
+	s.ContinueSession(sent[2].Labels["sessionID"], failed, nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should complete after running out of retries")
+}
+
+func TestWorkflowRetryOn(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).Times(2).Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		Steps:   []config.Workflow{{CallDriver: "foo.bar"}},
+		Retry:   "3",
+		Backoff: "0s",
+		RetryOn: map[string]interface{}{
+			"status": SessionStatusFailure,
+			"reason": regexp.MustCompile("timeout"),
+		},
+	}, &dipper.Message{}, map[string]interface{}{})
+	waitChildren(t)
+
+	s.ContinueSession(sent[0].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusFailure, "reason": "request timeout"},
+	}, nil)
+	waitChildren(t)
+	assert.Len(t, sent, 2, "should retry the steps on matching failure")
+
+	s.ContinueSession(sent[1].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusError, "reason": "request timeout"},
+	}, nil)
+	waitChildren(t)
+	assert.Zero(t, s.Len(), "should not retry on error")
+}
+
+func TestWorkflowRetryCancelled(t *testing.T) {
+	s, helper := newPersistTestStore(t, nil)
+	sent := []*dipper.Message{}
+	helper.EXPECT().SendMessage(gomock.Any()).AnyTimes().Do(func(msg *dipper.Message) { sent = append(sent, msg) })
+
+	s.StartSession(&config.Workflow{
+		CallDriver: "foo.bar",
+		Retry:      "2",
+		Backoff:    "1h",
+	}, &dipper.Message{Labels: map[string]string{"eventID": "event1"}}, map[string]interface{}{})
+	waitChildren(t)
+
+	s.ContinueSession(sent[0].Labels["sessionID"], &dipper.Message{
+		Labels: map[string]string{"status": SessionStatusError, "reason": "boom"},
+	}, nil)
+	waitChildren(t)
+	assert.Equal(t, 1, s.Len(), "should wait for the retry without holding up the shutdown")
+
+	cancelled := s.CancelEvent("event1", ReasonCancelled)
+	waitChildren(t)
+	assert.Len(t, cancelled, 1)
+	assert.Nil(t, cancelled[0].(*Session).retryTimer, "should stop the pending retry")
+	assert.Zero(t, s.Len(), "should complete the session")
+	for _, msg := range sent[1:] {
+		assert.Equal(t, "true", msg.Labels[dipper.EventbusCancel], "should not retry the cancelled session")
+	}
+}
@@ -32,9 +32,18 @@ type Session struct {
 	iterationLock  *sync.Mutex
 	iterationOut   *dipper.Message
 	loopCount      int // counter for looping
+	retryCount     int // counter for retrying the action
+	retryMsg       *dipper.Message
+	retryTimer     *time.Timer // the pending retry
+	timeoutTimer   *time.Timer
+	compensations  []int           // completed steps to be compensated
+	compensating   *dipper.Message // the failure being compensated
+	compensated    []interface{}   // results of the compensations
+	cancelled      bool            // the action is stopped by cancelling the session
 	parent         string
 	ctx            map[string]interface{}
 	ctxLock        *sync.Mutex
+	execLock       *sync.Mutex // serializes the messages with the timeout, the retries and the cancellation
 	event          map[string]interface{}
 	exported       []map[string]interface{}
 	elseBranch     *config.Workflow
@@ -44,12 +53,21 @@ type Session struct {
 	currentHook    string
 	savedMsg       *dipper.Message
 	origMsg        *dipper.Message
+	actionMsg      *dipper.Message // the message of the action in progress, for recovery
 	performing     string
 	isHook         bool
 	context        context.Context
 	cancelFunc     context.CancelFunc
 	startTime      time.Time
 	completionTime time.Time
+	resumeToken    string            // set while suspended
+	waitUntil      time.Time         // when a suspended session times out, zero for infinite
+	source         *SessionSource    // what a top-level session is started with, for dead letters
+	history        []*SessionHistory // audit records of the completed child sessions
+	slot           string            // the concurrency slot held by the session
+	slotOwner      string            // the token identifying the session as the holder of the slot
+	slotTime       time.Time         // when the concurrency slot is acquired
+	slotTimer      *time.Timer       // refreshes the concurrency slot before it expires
 }
 
 // SessionHandler prepare and execute the session provides entry point for SessionStore to invoke and mock for testing.
@@ -312,9 +330,15 @@ func (w *Session) interpolateWorkflow(msg *dipper.Message) {
 	ret.UnlessAll = dipper.Interpolate(v.UnlessAll, envData).([]string)
 	ret.Match = dipper.Interpolate(v.Match, envData)
 	ret.UnlessMatch = dipper.Interpolate(v.UnlessMatch, envData)
+	ret.Timeout = dipper.InterpolateStr(v.Timeout, envData)
 	ret.Retry = dipper.InterpolateStr(v.Retry, envData)
 	ret.Backoff = dipper.InterpolateStr(v.Backoff, envData)
+	ret.BackoffType = dipper.InterpolateStr(v.BackoffType, envData)
+	ret.MaxBackoff = dipper.InterpolateStr(v.MaxBackoff, envData)
+	ret.RetryOn = dipper.Interpolate(v.RetryOn, envData)
 	ret.Wait = dipper.InterpolateStr(v.Wait, envData)
+	ret.Approval = interpolateApproval(v.Approval, envData)
+	ret.Concurrency = interpolateConcurrency(v.Concurrency, envData)
 	ret.CallFunction = dipper.InterpolateStr(v.CallFunction, envData)
 	ret.CallDriver = dipper.InterpolateStr(v.CallDriver, envData)
 
@@ -341,6 +365,7 @@ func (w *Session) interpolateWorkflow(msg *dipper.Message) {
 	// ret.Function = v.Function               // delayed
 	// ret.Steps = v.Steps                     // delayed
 	// ret.Threads = v.Threads                 // delayed
+	// ret.Compensate = v.Compensate           // delayed
 	// ret.Export = v.Export                   // delayed
 	// ret.ExportOnSuccess = v.ExportOnSuccess // delayed
 	// ret.ExportOnFailure = v.ExportOnFailure // delayed
@@ -373,6 +398,7 @@ func (w *Session) inheritParentSettings(p *Session) {
 
 // createChildSession creates a child workflow session.
 func (w *Session) createChildSession(wf *config.Workflow, msg *dipper.Message) *Session {
+	w.checkpoint(msg)
 	child := w.store.newSession(w.ID, w.EventID, wf)
 	child.prepare(msg, w, nil)
 
@@ -25,11 +25,21 @@ type SessionStoreHelper interface {
 	EmitResult(UUID string, result map[string]interface{})
 }
 
-// SessionStore stores session in memory and provides helper function for session to perform.
+// SessionStore stores session in memory and provides helper function for session to perform.  The
+// sessions are also checkpointed to the Backend if set, see Recover.  The top-level sessions completed
+// with error are recorded in the DeadLetters, and the History keeps the audit records of the
+// completed events.  The Coordinator enforces the concurrency limits of the workflows.
 type SessionStore struct {
 	sessions          map[string]SessionHandler
 	suspendedSessions map[string]string
+	suspendedLock     sync.Mutex
 	Helper            SessionStoreHelper
+	Backend           SessionBackend
+	DeadLetters       SessionBackend
+	History           *EventHistory
+	Coordinator       ConcurrencyCoordinator
+	queues            map[string][]*queuedSession
+	concurrencyLock   sync.Mutex
 }
 
 // NewSessionStore initialize the session store.
@@ -38,7 +48,11 @@ func NewSessionStore(helper SessionStoreHelper) *SessionStore {
 		sessions:          map[string]SessionHandler{},
 		suspendedSessions: map[string]string{},
 		Helper:            helper,
+		DeadLetters:       NewMemoryBackend(DefaultDeadLetterLimit),
+		History:           NewEventHistory(DefaultHistoryLimit),
+		queues:            map[string][]*queuedSession{},
 	}
+	s.Coordinator = &localCoordinator{store: s, locks: map[string]localLock{}}
 	dipper.InitIDMap(&s.sessions)
 
 	return s
@@ -57,6 +71,7 @@ func (s *SessionStore) newSession(parent string, eventUUID string, wf *config.Wo
 		EventID:       eventUUID,
 		workflow:      wf,
 		ctxLock:       &sync.Mutex{},
+		execLock:      &sync.Mutex{},
 		iterationLock: &sync.Mutex{},
 		startTime:     time.Now(),
 	}
@@ -90,6 +105,7 @@ func (s *SessionStore) StartDynamicSession(msg *dipper.Message, wfdata interface
 		ctx["_output"] = map[string]interface{}{}
 	}
 	w := s.newSession("", eventUUID, wf)
+	w.(*Session).setSource(msg, ctx)
 	w.prepare(msg, nil, ctx)
 	w.execute(msg)
 
@@ -104,6 +120,7 @@ func (s *SessionStore) StartSession(wf *config.Workflow, msg *dipper.Message, ct
 	if wf.Workflow == "reserved/main" {
 		w.Watch()
 	}
+	w.(*Session).setSource(msg, ctx)
 	w.prepare(msg, nil, ctx)
 	w.execute(msg)
 
@@ -114,16 +131,74 @@ func (s *SessionStore) StartSession(wf *config.Workflow, msg *dipper.Message, ct
 func (s *SessionStore) ContinueSession(sessionID string, msg *dipper.Message, exports []map[string]interface{}) {
 	defer dipper.SafeExitOnError("[workflow] error when continuing workflow session %s", sessionID)
 	w := dipper.IDMapGet(&s.sessions, sessionID).(SessionHandler)
+	if sw, ok := w.(*Session); ok {
+		sw.execLock.Lock()
+		defer sw.execLock.Unlock()
+		if dipper.IDMapGet(&s.sessions, sessionID) != w {
+			// cancelled while waiting
+			return
+		}
+	}
 	defer w.onError()
 	w.continueExec(msg, exports)
 }
 
+// suspend registers the session waiting on the resume token, returns the session already waiting
+// on the token and false if the token is taken.
+func (s *SessionStore) suspend(key string, sessionID string) (string, bool) {
+	s.suspendedLock.Lock()
+	defer s.suspendedLock.Unlock()
+	if old, ok := s.suspendedSessions[key]; ok {
+		return old, false
+	}
+	s.suspendedSessions[key] = sessionID
+
+	return sessionID, true
+}
+
+// suspended returns the session waiting on the resume token.
+func (s *SessionStore) suspended(key string) (string, bool) {
+	s.suspendedLock.Lock()
+	defer s.suspendedLock.Unlock()
+	sessionID, ok := s.suspendedSessions[key]
+
+	return sessionID, ok
+}
+
+// unsuspend removes the resume token if the given session is waiting on it, returns false if not.
+func (s *SessionStore) unsuspend(key string, sessionID string) bool {
+	s.suspendedLock.Lock()
+	defer s.suspendedLock.Unlock()
+	if s.suspendedSessions[key] != sessionID {
+		return false
+	}
+	delete(s.suspendedSessions, key)
+
+	return true
+}
+
+// suspendedKeys returns the resume tokens of the suspended sessions.
+func (s *SessionStore) suspendedKeys() []string {
+	s.suspendedLock.Lock()
+	defer s.suspendedLock.Unlock()
+	ret := make([]string, 0, len(s.suspendedSessions))
+	for key := range s.suspendedSessions {
+		ret = append(ret, key)
+	}
+
+	return ret
+}
+
 // ResumeSession resume a session that is in waiting state.
 func (s *SessionStore) ResumeSession(key string, msg *dipper.Message) {
 	defer dipper.SafeExitOnError("[workflow] error when resuming session for key %s", key)
-	sessionID, ok := s.suspendedSessions[key]
-	if ok {
-		delete(s.suspendedSessions, key)
+	if _, err := s.getApproval(key); err == nil {
+		dipper.Logger.Warningf("[workflow] approval %s can only be decided through the approval API", key)
+
+		return
+	}
+	sessionID, ok := s.suspended(key)
+	if ok && s.unsuspend(key, sessionID) {
 		sessionPayload, _ := dipper.GetMapData(msg.Payload, "payload")
 		sessionLabels := map[string]string{}
 		if labels, ok := dipper.GetMapData(msg.Payload, "labels"); ok {
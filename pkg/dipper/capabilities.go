@@ -0,0 +1,124 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"sort"
+)
+
+const (
+	// ProtocolVersion is the version of the daemon/driver protocol implemented in this library.  Since
+	// version 2, drivers reply to "command:ping" with "state:pong" instead of their state.
+	ProtocolVersion = 2
+
+	// PongProtocolVersion is the first protocol version replying to the pings with "state:pong".
+	PongProtocolVersion = 2
+
+	// MinProtocolVersion is the oldest protocol version the daemon accepts from a driver.
+	MinProtocolVersion = 1
+
+	// CapabilitiesEnv is the environment variable asking a driver to print its capabilities
+	// to the output and exit, instead of starting the message loop.
+	CapabilitiesEnv = "HONEYDIPPER_DRIVER_CAPABILITIES"
+)
+
+// Capabilities describes what a driver is able to do, advertised to the daemon
+// in the payload of the state message replying to "command:start".
+type Capabilities struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Name            string   `json:"name"`
+	Services        []string `json:"services,omitempty"`
+	Commands        []string `json:"commands,omitempty"`
+	RPCMethods      []string `json:"rpcMethods,omitempty"`
+	Framings        []string `json:"framings,omitempty"`
+	Codecs          []string `json:"codecs,omitempty"`
+}
+
+// AcceptsService checks if the driver can be used in the service, no services listed means any.
+func (c *Capabilities) AcceptsService(service string) bool {
+	return len(c.Services) == 0 || contains(c.Services, service)
+}
+
+// HasCommand checks if the driver advertises the command, i.e. the rawAction.
+func (c *Capabilities) HasCommand(command string) bool {
+	return contains(c.Commands, command)
+}
+
+// HasRPCMethod checks if the driver advertises the RPC method.
+func (c *Capabilities) HasRPCMethod(method string) bool {
+	return contains(c.RPCMethods, method)
+}
+
+// SupportsFraming checks if the driver can switch to the framing, drivers always support text.
+func (c *Capabilities) SupportsFraming(framing string) bool {
+	return framing == "" || framing == FramingText || contains(c.Framings, framing)
+}
+
+// SupportsCodec checks if the driver can encode payloads with the codec, drivers always support json.
+func (c *Capabilities) SupportsCodec(codecName string) bool {
+	return IsSameCodec(codecName, CodecJSON) || contains(c.Codecs, codecName)
+}
+
+// DecodeCapabilities decodes the capabilities from the payload encoded with the codec,
+// returns nil if nothing is advertised, e.g. by drivers built with older library.
+func DecodeCapabilities(codecName string, payload []byte) (*Capabilities, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	codec, err := GetCodec(codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Capabilities{}
+	if err := codec.Unmarshal(payload, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Capabilities collects the capabilities of the driver from the registered handlers.
+func (d *Driver) Capabilities() *Capabilities {
+	c := &Capabilities{
+		ProtocolVersion: ProtocolVersion,
+		Name:            d.Name,
+		Services:        d.Services,
+		Commands:        sortedKeys(d.Commands),
+		RPCMethods:      sortedKeys(d.RPCHandlers),
+		Framings:        []string{FramingText, FramingBinary, FramingJSONLines},
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	for name := range codecs {
+		c.Codecs = append(c.Codecs, name)
+	}
+	sort.Strings(c.Codecs)
+
+	return c
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(m map[string]MessageHandler) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriverCapabilities(t *testing.T) {
+	d := NewDriver("operator", "test", DriverWithWriter(&bytes.Buffer{}), DriverWithServices("operator"))
+	d.Commands["run"] = func(*Message) {}
+	d.Commands["apply"] = func(*Message) {}
+	d.RPCHandlers["get"] = func(*Message) {}
+
+	caps := d.Capabilities()
+	assert.Equal(t, ProtocolVersion, caps.ProtocolVersion, "should advertise protocol version")
+	assert.Equal(t, []string{"apply", "run"}, caps.Commands, "should advertise sorted commands")
+	assert.True(t, caps.HasRPCMethod("get"), "should advertise rpc methods")
+	assert.True(t, caps.AcceptsService("operator"), "should accept declared service")
+	assert.False(t, caps.AcceptsService("receiver"), "should not accept other services")
+	assert.True(t, caps.SupportsFraming(FramingBinary), "should support binary framing")
+	assert.True(t, caps.SupportsCodec(CodecMsgpack), "should support msgpack codec")
+
+	for _, codecName := range []string{CodecJSON, CodecMsgpack, CodecCBOR} {
+		encoded, err := EncodeContent(codecName, caps)
+		assert.NoError(t, err, "should encode capabilities with %s", codecName)
+		decoded, err := DecodeCapabilities(codecName, encoded)
+		assert.NoError(t, err, "should decode capabilities with %s", codecName)
+		assert.Equal(t, caps, decoded, "should keep capabilities through %s", codecName)
+	}
+
+	decoded, err := DecodeCapabilities(CodecJSON, nil)
+	assert.NoError(t, err, "legacy drivers advertise nothing")
+	assert.Nil(t, decoded, "legacy drivers advertise nothing")
+
+	assert.True(t, (&Capabilities{}).AcceptsService("engine"), "no services means any service")
+}
+
+func TestDriverDescribeCapabilities(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := NewDriver("operator", "test", DriverWithWriter(out))
+	d.Commands["run"] = func(*Message) {}
+
+	os.Setenv(CapabilitiesEnv, "1")
+	defer os.Unsetenv(CapabilitiesEnv)
+	d.Run()
+
+	caps := &Capabilities{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), caps), "should print capabilities as json")
+	assert.Equal(t, "test", caps.Name, "should print driver name")
+	assert.True(t, caps.HasCommand("run"), "should print commands")
+}
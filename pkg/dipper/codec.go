@@ -0,0 +1,162 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+// names of the payload codecs.
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+	CodecCBOR    = "cbor"
+)
+
+// ErrUnknownCodec indicates the payload codec is not registered.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec encodes and decodes message payloads.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes payloads with encoding/json, the default codec.
+type JSONCodec struct{}
+
+// Marshal encodes the value into json bytes.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the json bytes into the value.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// HandleCodec encodes payloads with one of the ugorji codec handles.
+type HandleCodec struct {
+	Handle codec.Handle
+}
+
+// Marshal encodes the value using the codec handle.
+func (c HandleCodec) Marshal(v interface{}) (ret []byte, err error) {
+	err = codec.NewEncoderBytes(&ret, c.Handle).Encode(v)
+
+	return ret, err
+}
+
+// Unmarshal decodes the bytes into the value using the codec handle.
+func (c HandleCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.Handle).Decode(v)
+}
+
+var (
+	codecs     = map[string]Codec{}
+	codecsLock = sync.RWMutex{}
+)
+
+func init() {
+	mapType := reflect.TypeOf(map[string]interface{}(nil))
+
+	msgpackHandle := &codec.MsgpackHandle{}
+	msgpackHandle.MapType = mapType
+	msgpackHandle.RawToString = true
+	msgpackHandle.WriteExt = true
+
+	cborHandle := &codec.CborHandle{}
+	cborHandle.MapType = mapType
+
+	RegisterCodec(CodecJSON, JSONCodec{})
+	RegisterCodec(CodecMsgpack, HandleCodec{Handle: msgpackHandle})
+	RegisterCodec(CodecCBOR, HandleCodec{Handle: cborHandle})
+}
+
+// RegisterCodec makes a payload codec available under the given name.
+func RegisterCodec(name string, c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[name] = c
+}
+
+// GetCodec returns the codec registered under the name, an empty name means json.
+func GetCodec(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJSON
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	if c, ok := codecs[name]; ok {
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// IsSameCodec checks if the two codec names refer to the same codec.
+func IsSameCodec(a, b string) bool {
+	if a == "" {
+		a = CodecJSON
+	}
+	if b == "" {
+		b = CodecJSON
+	}
+
+	return a == b
+}
+
+// EncodeContent encodes the content into bytes with the named codec.
+func EncodeContent(codecName string, content interface{}) ([]byte, error) {
+	if content == nil {
+		return []byte{}, nil
+	}
+	c, err := GetCodec(codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Marshal(content)
+}
+
+// DecodeContent decodes the bytes with the named codec.
+func DecodeContent(codecName string, content []byte) (interface{}, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	c, err := GetCodec(codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret interface{} = map[string]interface{}{}
+	if err := c.Unmarshal(content, &ret); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// TranscodeContent re-encodes the bytes from one codec into another.
+func TranscodeContent(from string, to string, content []byte) ([]byte, error) {
+	if IsSameCodec(from, to) || len(content) == 0 {
+		return content, nil
+	}
+	decoded, err := DecodeContent(from, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncodeContent(to, decoded)
+}
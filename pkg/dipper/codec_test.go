@@ -0,0 +1,54 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	content := map[string]interface{}{
+		"name":  "test",
+		"items": []interface{}{"a", "b"},
+		"sub":   map[string]interface{}{"key": "value"},
+	}
+
+	for _, name := range []string{"", CodecJSON, CodecMsgpack, CodecCBOR} {
+		encoded, err := EncodeContent(name, content)
+		assert.Nil(t, err, "encoding with %s should not raise err", name)
+
+		decoded, err := DecodeContent(name, encoded)
+		assert.Nil(t, err, "decoding with %s should not raise err", name)
+		assert.Equal(t, content, decoded, "content decoded with %s should match", name)
+	}
+}
+
+func TestCodecUnknown(t *testing.T) {
+	_, err := GetCodec("xml")
+	assert.ErrorIs(t, err, ErrUnknownCodec, "unknown codec should raise err")
+
+	_, err = EncodeContent("xml", map[string]interface{}{})
+	assert.ErrorIs(t, err, ErrUnknownCodec, "encoding with unknown codec should raise err")
+}
+
+func TestTranscodeContent(t *testing.T) {
+	packed, err := EncodeContent(CodecMsgpack, map[string]interface{}{"key": "value"})
+	assert.Nil(t, err, "encoding msgpack should not raise err")
+
+	ret, err := TranscodeContent(CodecMsgpack, CodecJSON, packed)
+	assert.Nil(t, err, "transcoding should not raise err")
+	assert.Equal(t, `{"key":"value"}`, string(ret), "should transcode msgpack into json")
+
+	ret, err = TranscodeContent("", CodecJSON, []byte("opaque"))
+	assert.Nil(t, err, "transcoding between same codecs should not raise err")
+	assert.Equal(t, "opaque", string(ret), "should not touch content when codecs are the same")
+}
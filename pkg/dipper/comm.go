@@ -9,12 +9,12 @@ package dipper
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 	"sync"
 )
 
@@ -26,14 +26,23 @@ const (
 	EventbusMessage = "message"
 	EventbusCommand = "command"
 	EventbusReturn  = "return"
+	EventbusCancel  = "cancel"
+	StatePong       = "pong"
 )
 
-// CommLocks : comm channels are protected with locks.
+// CommLocks : the locks of the comm channels, kept for the drivers locking their own writers.
+//
+// Deprecated: use a Conn, which serializes its own senders.
 var CommLocks = map[io.Writer]*sync.Mutex{}
 
 // MasterCommLock : the lock used to protect the comm locks.
+//
+// Deprecated: use a Conn, which serializes its own senders.
 var MasterCommLock = sync.Mutex{}
 
+// ErrEncoding indicates the payload of a message can not be encoded.
+var ErrEncoding = errors.New("unable to encode payload")
+
 // Message : the message passed between components of the system.
 type Message struct {
 	// on the wire
@@ -45,8 +54,21 @@ type Message struct {
 
 	// runtime meta info in memory
 	IsRaw    bool
+	Codec    string // codec of the raw payload, empty means as is, usually json
 	Reply    chan Message
-	ReturnTo io.Writer
+	ReturnTo MessageReceiver
+
+	ctx context.Context
+}
+
+// Context returns the context of the message, e.g. the context of a rpc call which is done when
+// the caller gives up, defaults to the background context.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
 }
 
 // MessageHandler : a type of functions that take a pointer to a message and handle it.
@@ -116,8 +138,9 @@ func DeserializeContent(content []byte) (ret interface{}) {
 func DeserializePayload(msg *Message) *Message {
 	if msg.IsRaw {
 		if msg.Payload != nil {
-			msg.Payload = DeserializeContent(msg.Payload.([]byte))
+			msg.Payload = Must(DecodeContent(msg.Codec, msg.Payload.([]byte)))
 		}
+		msg.Codec = ""
 
 		msg.IsRaw = false
 	}
@@ -130,103 +153,41 @@ func FetchMessage(in io.Reader) (msg *Message) {
 	return DeserializePayload(FetchRawMessage(in))
 }
 
-// FetchRawMessage : fetch encoded message from input from daemon service.
+// FetchRawMessage : fetch encoded message in text framing from the reader, use a Conn for
+// reading from a comm channel with negotiated format. Panics on error, see ReadMessage.
 func FetchRawMessage(in io.Reader) (msg *Message) {
-	var (
-		channel   string
-		subject   string
-		size      int
-		numLabels int
-	)
-
-	_, err := fmt.Fscanln(in, &channel, &subject, &numLabels, &size)
-	if errors.Is(err, io.EOF) {
+	msg, err := ReadMessage(in)
+	if err != nil {
 		panic(err)
-	} else if err != nil {
-		errMsg := fmt.Sprintf("%+v", err)
-		if strings.Contains(errMsg, "file already closed") {
-			panic(io.EOF)
-		}
-		panic(fmt.Errorf("invalid message envelope: %w", err))
-	}
-
-	msg = &Message{
-		Channel: channel,
-		Subject: subject,
-		IsRaw:   true,
-		Size:    size,
-	}
-
-	msg.Labels = map[string]string{}
-	for ; numLabels > 0; numLabels-- {
-		var (
-			lname string
-			vl    int
-		)
-
-		_, err := fmt.Fscanln(in, &lname, &vl)
-		if err != nil {
-			panic(fmt.Errorf("unable to fetch message label name: %w", err))
-		}
-		if vl > 0 {
-			lvalue := make([]byte, vl)
-			if _, err = io.ReadFull(in, lvalue); err != nil {
-				panic(fmt.Errorf("unable to fetch value for label %s: %w", lname, err))
-			}
-
-			msg.Labels[lname] = string(lvalue)
-		} else {
-			msg.Labels[lname] = ""
-		}
-	}
-
-	if size > 0 {
-		buf := make([]byte, size)
-		_, err := io.ReadFull(in, buf)
-		if err != nil {
-			panic(err)
-		}
-		msg.Payload = buf
 	}
 
 	return msg
 }
 
-// SendMessage : send a message to the io.Writer, may change the message to raw.
+// ReadMessage reads an encoded message in text framing from the reader, returns io.EOF
+// when the reader is closed.
+func ReadMessage(in io.Reader) (*Message, error) {
+	return readMessage(in, FramingText)
+}
+
+// SendMessage : send a message in text framing to the io.Writer, may change the message to raw.
+// Not safe for concurrent use on the same writer, use a Conn for sending through a comm channel.
+// Panics on error, see WriteMessage.
 func SendMessage(out io.Writer, msg *Message) {
-	payload := []byte{}
-	if msg.Payload != nil {
-		if !msg.IsRaw {
-			payload = SerializeContent(msg.Payload)
-		} else {
-			payload = msg.Payload.([]byte)
-		}
-	}
-	size := len(payload)
-	numLabels := len(msg.Labels)
-
-	LockComm(out)
-	defer UnlockComm(out)
-
-	fmt.Fprintf(out, "%s %s %d %d\n", msg.Channel, msg.Subject, numLabels, size)
-	if numLabels > 0 {
-		for lname, lval := range msg.Labels {
-			fmt.Fprintf(out, "%s %d\n", lname, len(lval))
-			_, err := out.Write([]byte(lval))
-			if err != nil {
-				panic(err)
-			}
-		}
-	}
-	if size > 0 {
-		_, err := out.Write(payload)
-		if err != nil {
-			panic(err)
-		}
+	if err := WriteMessage(out, msg); err != nil {
+		panic(err)
 	}
 }
 
+// WriteMessage writes a message in text framing to the io.Writer. Not safe for concurrent
+// use on the same writer, use a Conn for sending through a comm channel.
+func WriteMessage(out io.Writer, msg *Message) error {
+	return writeMessage(out, msg, FramingText, CodecJSON)
+}
+
 // LockComm : Lock the comm channel.
+//
+// Deprecated: use a Conn, which serializes its own senders.
 func LockComm(out io.Writer) {
 	var lock *sync.Mutex
 	func() {
@@ -243,6 +204,8 @@ func LockComm(out io.Writer) {
 }
 
 // UnlockComm : unlock the comm channel.
+//
+// Deprecated: use a Conn, which serializes its own senders.
 func UnlockComm(out io.Writer) {
 	var lock *sync.Mutex
 	func() {
@@ -258,12 +221,66 @@ func UnlockComm(out io.Writer) {
 }
 
 // RemoveComm : remove the lock when the comm channel is closed.
+//
+// Deprecated: use a Conn, which serializes its own senders.
 func RemoveComm(out io.Writer) {
 	MasterCommLock.Lock()
 	defer MasterCommLock.Unlock()
 	delete(CommLocks, out)
 }
 
+func readMessage(in io.Reader, framing string) (*Message, error) {
+	switch framing {
+	case FramingBinary:
+		return readBinaryFrame(in)
+	case FramingJSONLines:
+		return readJSONLineFrame(in)
+	}
+
+	return readTextFrame(in)
+}
+
+func writeMessage(out io.Writer, msg *Message, framing string, codecName string) error {
+	if framing != FramingBinary {
+		codecName = CodecJSON
+	}
+
+	payload := []byte{}
+	if msg.Payload != nil {
+		var err error
+		if !msg.IsRaw {
+			payload, err = EncodeContent(codecName, msg.Payload)
+		} else {
+			var ok bool
+			if payload, ok = msg.Payload.([]byte); !ok {
+				return fmt.Errorf("%w: raw payload is not bytes", ErrEncoding)
+			}
+			switch {
+			case msg.Codec == "":
+				// raw payload from the caller is sent as is.
+				codecName = ""
+			case framing == FramingBinary:
+				// the frame is tagged with the codec, no need to transcode.
+				codecName = msg.Codec
+			default:
+				payload, err = TranscodeContent(msg.Codec, codecName, payload)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrEncoding, err)
+		}
+	}
+
+	switch framing {
+	case FramingBinary:
+		return writeBinaryFrame(out, msg, payload, codecName)
+	case FramingJSONLines:
+		return writeJSONLineFrame(out, msg, payload)
+	}
+
+	return writeTextFrame(out, msg, payload)
+}
+
 // MessageCopy : performs a deep copy of the given map m.
 func MessageCopy(m *Message) (*Message, error) {
 	var buf bytes.Buffer
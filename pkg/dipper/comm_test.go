@@ -10,6 +10,8 @@
 package dipper
 
 import (
+	"bytes"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +37,38 @@ func TestMessageCopy(t *testing.T) {
 	assert.Nil(t, err, "copy message should not raise err")
 	assert.Nil(t, dst2, "Error: Copy of nil should be nil")
 }
+
+func TestReadWriteMessage(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, WriteMessage(b, &Message{
+		Channel: "eventbus",
+		Subject: "message",
+		Payload: map[string]interface{}{"key": "value"},
+	}), "should write message")
+
+	msg, err := ReadMessage(b)
+	assert.NoError(t, err, "should read message")
+	assert.Equal(t, `{"key":"value"}`, string(msg.Payload.([]byte)), "should read the payload")
+
+	_, err = ReadMessage(b)
+	assert.ErrorIs(t, err, io.EOF, "should return EOF at the end")
+
+	err = WriteMessage(b, &Message{Channel: "eventbus", Subject: "message", Payload: "text", IsRaw: true})
+	assert.ErrorIs(t, err, ErrEncoding, "should return error for raw payload not in bytes")
+	assert.Zero(t, b.Len(), "should not write invalid message")
+
+	err = WriteMessage(b, &Message{Channel: "eventbus", Subject: "message", Payload: make(chan int)})
+	assert.ErrorIs(t, err, ErrEncoding, "should return error for payload can not be encoded")
+}
+
+func TestSendMessageNoCommLock(t *testing.T) {
+	b := &bytes.Buffer{}
+	SendMessage(b, &Message{Channel: "eventbus", Subject: "message"})
+	assert.NotContains(t, CommLocks, io.Writer(b), "should not keep a comm lock for the writer")
+
+	LockComm(b)
+	UnlockComm(b)
+	assert.Contains(t, CommLocks, io.Writer(b), "should keep the lock for the deprecated callers")
+	RemoveComm(b)
+	assert.NotContains(t, CommLocks, io.Writer(b), "should remove the lock")
+}
@@ -7,9 +7,10 @@
 package dipper
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -26,16 +27,19 @@ const (
 
 // CommandProvider : an interface for providing Command handling feature.
 type CommandProvider struct {
-	Commands     map[string]MessageHandler
-	ReturnWriter io.Writer
-	Channel      string
-	Subject      string
+	Commands      map[string]MessageHandler
+	DefaultReturn MessageReceiver
+	Channel       string
+	Subject       string
+
+	cancels    map[string]context.CancelFunc
+	cancelLock sync.Mutex
 }
 
 // Init : initializing rpc provider.
-func (p *CommandProvider) Init(channel string, subject string, defaultWriter io.Writer) {
+func (p *CommandProvider) Init(channel string, subject string, defaultReturn MessageReceiver) {
 	p.Commands = map[string]MessageHandler{}
-	p.ReturnWriter = defaultWriter
+	p.DefaultReturn = defaultReturn
 	p.Channel = channel
 	p.Subject = subject
 }
@@ -59,9 +63,11 @@ func (p *CommandProvider) Return(call *Message, retval *Message) {
 		call.Reply = nil
 	}()
 
-	if _, ok := call.Labels["sessionID"]; !ok {
+	sessionID, ok := call.Labels["sessionID"]
+	if !ok {
 		return
 	}
+	p.removeCancel(sessionID)
 
 	retMsg := &Message{
 		Channel: p.Channel,
@@ -86,7 +92,41 @@ func (p *CommandProvider) Return(call *Message, retval *Message) {
 	}
 	retMsg.Payload = retval.Payload
 	retMsg.IsRaw = retval.IsRaw
-	SendMessage(p.ReturnWriter, retMsg)
+	p.DefaultReturn.SendMessage(retMsg)
+}
+
+// ReturnStream returns the result to the caller as a stream, the handler should close the stream
+// when finished, closing with an error marks the command as errored.
+func (p *CommandProvider) ReturnStream(call *Message) *StreamWriter {
+	if call.Reply != nil {
+		// let the wrapper know the return is handled through the stream
+		call.Reply <- Message{Labels: map[string]string{"stream": StreamOpen}}
+	}
+
+	labels := map[string]string{}
+	for k, v := range call.Labels {
+		labels[k] = v
+	}
+	delete(labels, "backoff_ms")
+	delete(labels, "retry")
+	delete(labels, "timeout")
+
+	w := NewStreamWriter(p.DefaultReturn, &Message{
+		Channel: p.Channel,
+		Subject: p.Subject,
+		Labels:  labels,
+	})
+	w.OnClose = func(labels map[string]string, err error) {
+		p.removeCancel(labels["sessionID"])
+		if err != nil {
+			labels["status"] = ERROR
+			labels["reason"] = err.Error()
+		} else {
+			labels["status"] = SUCCESS
+		}
+	}
+
+	return w
 }
 
 type commandWrapper struct {
@@ -126,8 +166,13 @@ func (w *commandWrapper) attempt(replyChannel chan Message) {
 				reply = <-m.Reply
 			}
 
+			if IsStream(&reply) {
+				// the handler returns through a stream
+				return
+			}
+
 			_, hasError := reply.Labels["error"]
-			if status, ok := reply.Labels["status"]; (hasError || (ok && status != SUCCESS)) && w.retry > 0 {
+			if status, ok := reply.Labels["status"]; (hasError || (ok && status != SUCCESS)) && w.retry > 0 && m.Context().Err() == nil {
 				Logger.Debugf("[operaotr] %d retry left for method %s", w.retry, w.method)
 				w.retry--
 				time.Sleep(w.backoff * time.Millisecond)
@@ -138,6 +183,8 @@ func (w *commandWrapper) attempt(replyChannel chan Message) {
 			}
 		case <-apiTimer.C:
 			_ = w.provider.ReturnError(w.msg, "timeout")
+		case <-m.Context().Done():
+			_ = w.provider.ReturnError(w.msg, "cancelled")
 		}
 	}()
 
@@ -155,7 +202,8 @@ func (w *commandWrapper) attempt(replyChannel chan Message) {
 	w.f(&m)
 }
 
-// Router : route the message to rpc handlers.
+// Router : route the message to rpc handlers.  The handler can use the context of the message,
+// which is done when the command is cancelled by the engine.
 func (p *CommandProvider) Router(msg *Message) {
 	method := msg.Labels["method"]
 	f, ok := p.Commands[method]
@@ -164,6 +212,11 @@ func (p *CommandProvider) Router(msg *Message) {
 	}
 
 	retry, timeout, backoff := p.UnpackLabels(msg)
+	if sessionID := msg.Labels["sessionID"]; sessionID != "" {
+		var cancel context.CancelFunc
+		msg.ctx, cancel = context.WithCancel(context.Background())
+		p.addCancel(sessionID, cancel)
+	}
 	w := &commandWrapper{
 		msg:      msg,
 		f:        f,
@@ -176,6 +229,35 @@ func (p *CommandProvider) Router(msg *Message) {
 	w.attempt(make(chan Message, 1))
 }
 
+// Cancel : cancel the context of the command identified by the sessionID label.
+func (p *CommandProvider) Cancel(msg *Message) {
+	p.cancelLock.Lock()
+	cancel, ok := p.cancels[msg.Labels["sessionID"]]
+	p.cancelLock.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (p *CommandProvider) addCancel(key string, cancel context.CancelFunc) {
+	p.cancelLock.Lock()
+	defer p.cancelLock.Unlock()
+	if p.cancels == nil {
+		p.cancels = map[string]context.CancelFunc{}
+	}
+	p.cancels[key] = cancel
+}
+
+func (p *CommandProvider) removeCancel(key string) {
+	p.cancelLock.Lock()
+	defer p.cancelLock.Unlock()
+	if cancel, ok := p.cancels[key]; ok {
+		cancel()
+		delete(p.cancels, key)
+	}
+}
+
 // UnpackLabels loads necessary variables out of the labels.
 func (p *CommandProvider) UnpackLabels(msg *Message) (retry int, timeout, backoffms time.Duration) {
 	var err error
@@ -26,9 +26,9 @@ func TestCommandRetrySuccess(t *testing.T) {
 	counter := 0
 
 	subject := CommandProvider{
-		ReturnWriter: &b,
-		Channel:      "test",
-		Subject:      "test",
+		DefaultReturn: NewConn(nil, &b),
+		Channel:       "test",
+		Subject:       "test",
 
 		Commands: map[string]MessageHandler{
 			"test": func(m *Message) {
@@ -75,9 +75,9 @@ func TestCommandRetryFailure(t *testing.T) {
 	counter = 0
 
 	subject := CommandProvider{
-		ReturnWriter: &b,
-		Channel:      "test",
-		Subject:      "test",
+		DefaultReturn: NewConn(nil, &b),
+		Channel:       "test",
+		Subject:       "test",
 
 		Commands: map[string]MessageHandler{
 			"test": func(m *Message) {
@@ -124,9 +124,9 @@ func TestCommandRetryRougueFunction(t *testing.T) {
 	counter = 0
 
 	subject := CommandProvider{
-		ReturnWriter: &b,
-		Channel:      "test",
-		Subject:      "test",
+		DefaultReturn: NewConn(nil, &b),
+		Channel:       "test",
+		Subject:       "test",
 
 		Commands: map[string]MessageHandler{
 			"test": func(m *Message) {
@@ -189,3 +189,49 @@ func TestCommandRetryRougueFunction(t *testing.T) {
 	assert.Equal(t, "success", ret.Labels["status"], "should return error after retry error")
 	assert.Equal(t, "3", ret.Payload.(map[string]interface{})["counter"], "should return the final counter")
 }
+
+func TestCommandCancel(t *testing.T) {
+	b := bytes.Buffer{}
+	stopped := make(chan struct{})
+
+	subject := CommandProvider{
+		DefaultReturn: NewConn(nil, &b),
+		Channel:       "test",
+		Subject:       "test",
+
+		Commands: map[string]MessageHandler{
+			"test": func(m *Message) {
+				<-m.Context().Done()
+				close(stopped)
+			},
+		},
+	}
+
+	m := &Message{
+		Labels: map[string]string{
+			"method":    "test",
+			"retry":     "2",
+			"sessionID": "3",
+		},
+	}
+
+	go subject.Router(m)
+	time.Sleep(10 * time.Millisecond)
+	subject.Cancel(&Message{Labels: map[string]string{"sessionID": "3"}})
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the handler should be cancelled")
+	}
+	sleepCount := 0
+	for sleepCount < 100 && m.Reply != nil {
+		time.Sleep(time.Millisecond * 5)
+		sleepCount++
+	}
+
+	ret := FetchMessage(&b)
+	assert.Equal(t, "error", ret.Labels["status"], "should return error when cancelled")
+	assert.Equal(t, "cancelled", ret.Labels["reason"], "should not retry cancelled command")
+	assert.Empty(t, subject.cancels, "should forget the cancelled command")
+}
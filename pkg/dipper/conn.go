@@ -0,0 +1,235 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultSendTimeout is the default timeout in seconds for sending a message through a Conn,
+// including the time waiting for other senders.
+const DefaultSendTimeout time.Duration = 30
+
+var (
+	// ErrConnClosed indicates sending through a closed connection.
+	ErrConnClosed = errors.New("connection closed")
+
+	// ErrSendTimeout indicates the peer is not consuming messages fast enough.
+	ErrSendTimeout = errors.New("send timeout")
+)
+
+// Conn is a comm channel between the daemon and a driver. It owns the reader and the writer,
+// the lock serializing the senders and the negotiated message format.  Senders block while the
+// peer is not reading, which pushes back on the producers, until the send timeout is reached.
+type Conn struct {
+	In          io.Reader
+	Out         io.Writer
+	SendTimeout time.Duration
+
+	format    atomic.Pointer[CommFormat]
+	lines     *bufio.Reader
+	sending   chan struct{}
+	pending   int32
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// NewConn creates a connection reading from in and writing to out, using text framing with json.
+func NewConn(in io.Reader, out io.Writer) *Conn {
+	c := &Conn{
+		In:          in,
+		Out:         out,
+		SendTimeout: DefaultSendTimeout * time.Second,
+		sending:     make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+	c.format.Store(&CommFormat{Framing: FramingText, Codec: CodecJSON})
+
+	return c
+}
+
+// Format returns the framing and codec used on the connection.
+func (c *Conn) Format() (framing string, codecName string) {
+	f := c.format.Load()
+
+	return f.Framing, f.Codec
+}
+
+// SetFormat changes the framing and codec used on the connection.
+func (c *Conn) SetFormat(framing string, codecName string) {
+	c.format.Store(&CommFormat{Framing: framing, Codec: codecName})
+}
+
+// SwitchFormat sends the acknowledgement in the current format, then switches the format before
+// any other message can be sent, so the peer can switch after reading the acknowledgement.
+func (c *Conn) SwitchFormat(ack *Message, framing string, codecName string) error {
+	if err := c.acquire(); err != nil {
+		return err
+	}
+	defer c.release()
+
+	if err := c.write(ack); err != nil {
+		return err
+	}
+	c.SetFormat(framing, codecName)
+
+	return nil
+}
+
+// FetchRawMessage reads a message from the connection, panics with io.EOF when closed by peer.
+func (c *Conn) FetchRawMessage() *Message {
+	msg, err := c.ReadMessage()
+	if err != nil {
+		panic(err)
+	}
+
+	return msg
+}
+
+// ReadMessage reads a message from the connection, returns io.EOF when closed by peer.
+func (c *Conn) ReadMessage() (*Message, error) {
+	framing, _ := c.Format()
+	if framing == FramingJSONLines {
+		// jsonl is chosen before the first message and never switched away from, so the
+		// reader can be buffered.
+		if c.lines == nil {
+			c.lines = bufio.NewReader(c.In)
+		}
+
+		return readMessage(c.lines, framing)
+	}
+
+	return readMessage(c.In, framing)
+}
+
+// FetchMessage reads a message from the connection and decodes the payload.
+func (c *Conn) FetchMessage() *Message {
+	return DeserializePayload(c.FetchRawMessage())
+}
+
+// SendMessage sends the message through the connection, panics if the connection is closed
+// or the message can not be sent within the send timeout, see WriteMessage.
+func (c *Conn) SendMessage(msg *Message) {
+	if err := c.WriteMessage(msg); err != nil {
+		panic(err)
+	}
+}
+
+// WriteMessage sends the message through the connection, returns ErrConnClosed if the connection
+// is closed, or ErrSendTimeout if the message can not be sent within the send timeout.
+func (c *Conn) WriteMessage(msg *Message) error {
+	if err := c.acquire(); err != nil {
+		return err
+	}
+	defer c.release()
+
+	return c.write(msg)
+}
+
+// Pending returns the number of senders waiting for the connection.
+func (c *Conn) Pending() int {
+	return int(atomic.LoadInt32(&c.pending))
+}
+
+// Done returns a channel closed when the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Close closes the connection, the writer is closed if it is an io.Closer, senders waiting
+// for the connection fail with ErrConnClosed.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if closer, ok := c.Out.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+
+	return err
+}
+
+// IsConnClosed checks if the error means the connection is closed, by either side.
+func IsConnClosed(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, ErrConnClosed)
+}
+
+func (c *Conn) acquire() error {
+	atomic.AddInt32(&c.pending, 1)
+	defer atomic.AddInt32(&c.pending, -1)
+
+	var timeout <-chan time.Time
+	if c.SendTimeout > 0 {
+		timer := time.NewTimer(c.SendTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case c.sending <- struct{}{}:
+		select {
+		case <-c.closed:
+			c.release()
+
+			return ErrConnClosed
+		default:
+			return nil
+		}
+	case <-c.closed:
+		return ErrConnClosed
+	case <-timeout:
+		return ErrSendTimeout
+	}
+}
+
+func (c *Conn) release() {
+	<-c.sending
+}
+
+func (c *Conn) write(msg *Message) error {
+	if d, ok := c.Out.(writeDeadliner); ok && c.SendTimeout > 0 {
+		if d.SetWriteDeadline(time.Now().Add(c.SendTimeout)) == nil {
+			defer func() { _ = d.SetWriteDeadline(time.Time{}) }()
+		}
+	}
+
+	framing, codecName := c.Format()
+	err := writeMessage(c.Out, msg, framing, codecName)
+	if err == nil || errors.Is(err, ErrInvalidFrame) || errors.Is(err, ErrEncoding) {
+		// nothing is written for invalid messages
+		return err
+	}
+
+	// a partially written frame breaks the stream
+	_ = c.Close()
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrSendTimeout
+	case errors.Is(err, io.ErrClosedPipe), errors.Is(err, os.ErrClosed):
+		return ErrConnClosed
+	}
+
+	return err
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnConcurrentSend(t *testing.T) {
+	r, w := io.Pipe()
+	c := NewConn(r, w)
+	c.SetFormat(FramingBinary, CodecMsgpack)
+
+	count := 50
+	wg := sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.SendMessage(&Message{
+				Channel: "eventbus",
+				Subject: "message",
+				Labels:  map[string]string{"id": strconv.Itoa(i)},
+				Payload: map[string]interface{}{"data": bytes.Repeat([]byte("x"), 10000)},
+			})
+		}(i)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < count; i++ {
+		msg := c.FetchMessage()
+		assert.Equal(t, "eventbus", msg.Channel, "frames should not interleave")
+		seen[msg.Labels["id"]] = true
+	}
+	wg.Wait()
+	assert.Len(t, seen, count, "should receive all messages")
+}
+
+func TestConnSendTimeout(t *testing.T) {
+	_, w := io.Pipe()
+	c := NewConn(nil, w)
+	c.SendTimeout = 50 * time.Millisecond
+
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		defer func() { _ = recover() }()
+		// nobody reads, the writer holds the connection
+		c.SendMessage(&Message{Channel: "eventbus", Subject: "message"})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	assert.PanicsWithValue(t, ErrSendTimeout, func() {
+		c.SendMessage(&Message{Channel: "eventbus", Subject: "message"})
+	}, "should time out waiting for the peer")
+
+	assert.NoError(t, c.Close(), "should close the connection")
+	<-blocked
+	assert.PanicsWithValue(t, ErrConnClosed, func() {
+		c.SendMessage(&Message{Channel: "eventbus", Subject: "message"})
+	}, "should not send through a closed connection")
+
+	select {
+	case <-c.Done():
+	default:
+		assert.Fail(t, "done channel should be closed")
+	}
+}
+
+func TestConnSwitchFormat(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewConn(b, b)
+
+	c.SwitchFormat(&Message{Channel: ChannelState, Subject: "format"}, FramingBinary, CodecCBOR)
+	c.SendMessage(&Message{Channel: "eventbus", Subject: "message", Payload: map[string]interface{}{"key": "value"}})
+
+	ack := FetchRawMessage(b)
+	assert.Equal(t, "format", ack.Subject, "acknowledgement should be sent in text")
+
+	framing, codecName := c.Format()
+	assert.Equal(t, FramingBinary, framing, "should switch to binary framing")
+	assert.Equal(t, CodecCBOR, codecName, "should switch to cbor")
+
+	msg := c.FetchMessage()
+	assert.Equal(t, map[string]interface{}{"key": "value"}, msg.Payload, "should read in the new format")
+}
+
+func TestDriverJSONLines(t *testing.T) {
+	t.Setenv(FramingEnv, FramingJSONLines)
+	out := &bytes.Buffer{}
+	d := NewDriver("operator", "test", DriverWithReader(&bytes.Buffer{}), DriverWithWriter(out))
+
+	framing, codecName := d.Conn().Format()
+	assert.Equal(t, FramingJSONLines, framing, "should use jsonl framing from the environment")
+	assert.Equal(t, CodecJSON, codecName, "should use json with jsonl framing")
+
+	d.Conn().SendMessage(&Message{Channel: "state", Subject: "alive"})
+	assert.Equal(t, `{"channel":"state","subject":"alive"}`+"\n", out.String(), "should send jsonl")
+
+	d = NewDriver("operator", "test", DriverWithReader(&bytes.Buffer{}), DriverWithWriter(out), DriverWithFraming(FramingText))
+	framing, _ = d.Conn().Format()
+	assert.Equal(t, FramingText, framing, "option should override the environment")
+}
@@ -8,9 +8,13 @@ package dipper
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -38,6 +42,7 @@ type Driver struct {
 	CommandProvider
 	Name            string
 	Service         string
+	Services        []string
 	State           string
 	In              io.Reader
 	Out             io.Writer
@@ -48,6 +53,11 @@ type Driver struct {
 	Reload          MessageHandler
 	ReadySignal     chan bool
 	APITimeout      time.Duration
+
+	conn     *Conn
+	connLock sync.Mutex
+	resumed  bool // reconnected by daemon, guarded by connLock
+	framing  string
 }
 
 // DriverOption provides a way to pass parameters to NewDriver method to override
@@ -68,6 +78,29 @@ func DriverWithWriter(w io.Writer) DriverOption {
 	}
 }
 
+// DriverWithServices declares the services the driver can be loaded in, advertised in capabilities.
+func DriverWithServices(services ...string) DriverOption {
+	return func(d *Driver) {
+		d.Services = services
+	}
+}
+
+// DriverWithFraming makes the driver use the framing from the start without negotiation, instead of
+// the framing in the environment variable set by the daemon.
+func DriverWithFraming(framing string) DriverOption {
+	return func(d *Driver) {
+		d.framing = framing
+	}
+}
+
+// DriverWithLogFile overrides the file the driver logs to, instead of the file descriptor passed by
+// the daemon, so drivers can run in tests.
+func DriverWithLogFile(f *os.File) DriverOption {
+	return func(d *Driver) {
+		logFile = f
+	}
+}
+
 // NewDriver : create a blank driver object.
 func NewDriver(service string, name string, opts ...DriverOption) *Driver {
 	driver := Driver{
@@ -77,24 +110,28 @@ func NewDriver(service string, name string, opts ...DriverOption) *Driver {
 		In:          os.Stdin,
 		Out:         os.Stdout,
 		ReadySignal: make(chan bool),
+		framing:     os.Getenv(FramingEnv),
 	}
 
 	for _, opt := range opts {
 		opt(&driver)
 	}
 
-	driver.RPCProvider.Init("rpc", "return", driver.Out)
+	returner := &NullReceiver{SendMessageFunc: func(m *Message) { driver.Conn().SendMessage(m) }}
+	driver.RPCProvider.Init("rpc", "return", returner)
 	driver.RPCCallerBase.Init(&driver, "rpc", "call")
-	driver.CommandProvider.Init("eventbus", "return", driver.Out)
+	driver.CommandProvider.Init("eventbus", "return", returner)
 
 	driver.MessageHandlers = map[string]MessageHandler{
 		"command:options":  driver.ReceiveOptions,
-		"command:ping":     driver.Ping,
+		"command:ping":     driver.Pong,
 		"command:start":    driver.start,
 		"command:stop":     driver.stop,
 		"rpc:call":         driver.RPCProvider.Router,
 		"rpc:return":       driver.HandleReturn,
+		"rpc:cancel":       driver.RPCProvider.Cancel,
 		"eventbus:command": driver.CommandProvider.Router,
+		"eventbus:cancel":  driver.CommandProvider.Cancel,
 	}
 
 	driver.GetLogger()
@@ -104,35 +141,125 @@ func NewDriver(service string, name string, opts ...DriverOption) *Driver {
 
 // Run : start a loop to communicate with daemon.
 func (d *Driver) Run() {
+	if os.Getenv(CapabilitiesEnv) != "" {
+		// only describing the driver, e.g. for config check and docs
+		Must(json.NewEncoder(d.Out).Encode(d.Capabilities()))
+
+		return
+	}
+
 	Logger.Infof("[%s] driver loaded", d.Service)
+	if addr := os.Getenv(ListenEnv); addr != "" {
+		// running outside of the daemon
+		l, err := listenFromEnv(addr)
+		if err != nil {
+			Logger.Fatalf("[%s] unable to listen on %s: %v", d.Service, addr, err)
+		}
+		d.Serve(l)
+
+		return
+	}
+
+	d.loop()
+	if d.State != DriverStateCompleted { // allow graceful shutdown during testing.
+		Logger.Fatalf("[%s] daemon closed channel", d.Service)
+	}
+}
+
+// Serve : accept connections from daemon one at a time and communicate with it, the driver keeps
+// running when daemon disconnects, until the listener is closed.
+func (d *Driver) Serve(l net.Listener) {
+	Logger.Infof("[%s] waiting for daemon on %s", d.Service, l.Addr())
+	for d.State != DriverStateCompleted {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			Logger.Warningf("[%s] failed to accept connection: %v", d.Service, err)
+
+			continue
+		}
+
+		Logger.Infof("[%s] daemon connected from %s", d.Service, c.RemoteAddr())
+		d.connLock.Lock()
+		d.In, d.Out = c, c
+		if d.ReadySignal == nil {
+			// the options of the new connection come before starting again
+			d.ReadySignal = make(chan bool)
+		}
+		d.connLock.Unlock()
+
+		d.loop()
+		_ = c.Close()
+		d.connLock.Lock()
+		d.resumed = true
+		d.connLock.Unlock()
+		Logger.Warningf("[%s] daemon disconnected", d.Service)
+	}
+}
+
+// loop : handle the messages from daemon until the channel is closed.
+func (d *Driver) loop() {
 	for {
-		func() {
-			defer SafeExitOnError("[%s] Resuming driver message loop", d.Service)
-			defer CatchError(io.EOF, func() {
-				if d.State != DriverStateCompleted { // allow graceful shutdown during testing.
-					Logger.Fatalf("[%s] daemon closed channel", d.Service)
-				}
-			})
-			for {
-				msg := FetchRawMessage(d.In)
-				go func() {
-					defer SafeExitOnError("[%s] Continuing driver message loop", d.Service)
-					if handler, ok := d.MessageHandlers[msg.Channel+":"+msg.Subject]; ok {
-						handler(msg)
-					} else {
-						Logger.Infof("[%s] skipping message without handler: %s:%s", d.Service, msg.Channel, msg.Subject)
-					}
-				}()
-			}
-		}()
+		msg, err := d.Conn().ReadMessage()
+		switch {
+		case IsConnClosed(err):
+			return
+		case err != nil:
+			Logger.Warningf("[%s] Resuming driver message loop: %v", d.Service, err)
+		default:
+			d.dispatch(msg)
+
+			continue
+		}
+
 		// allow graceful shutdown during testing.
 		if d.State == DriverStateCompleted {
-			break
+			return
 		}
 	}
 }
 
-// Ping : respond to daemon ping request with driver state.
+// dispatch : pass the message to its handler, stream messages are handled in order.
+func (d *Driver) dispatch(msg *Message) {
+	if msg.Channel == "command" && msg.Subject == "options" {
+		// switch format before reading the next message
+		d.negotiateFormat(msg)
+	}
+	if IsStream(msg) {
+		// keep the stream messages in order
+		d.dispatchStream(msg)
+
+		return
+	}
+	go func() {
+		defer SafeExitOnError("[%s] Continuing driver message loop", d.Service)
+		if handler, ok := d.MessageHandlers[msg.Channel+":"+msg.Subject]; ok {
+			handler(msg)
+		} else {
+			Logger.Infof("[%s] skipping message without handler: %s:%s", d.Service, msg.Channel, msg.Subject)
+		}
+	}()
+}
+
+// dispatchStream : handle a stream message synchronously in the message loop.
+func (d *Driver) dispatchStream(msg *Message) {
+	defer SafeExitOnError("[%s] Continuing driver message loop", d.Service)
+	if handler, ok := d.MessageHandlers[msg.Channel+":"+msg.Subject]; ok {
+		handler(msg)
+	} else {
+		Logger.Infof("[%s] skipping stream message without handler: %s:%s", d.Service, msg.Channel, msg.Subject)
+	}
+}
+
+// OpenStream : start sending a message to daemon as a stream, the caller should close the stream when finished.
+func (d *Driver) OpenStream(m *Message) *StreamWriter {
+	Logger.Infof("[%s] opening stream to daemon %s:%s", d.Service, m.Channel, m.Subject)
+
+	return NewStreamWriter(d, m)
+}
+
+// Ping : report the driver state to daemon.
 func (d *Driver) Ping(msg *Message) {
 	d.SendMessage(&Message{
 		Channel: "state",
@@ -140,6 +267,39 @@ func (d *Driver) Ping(msg *Message) {
 	})
 }
 
+// Pong : respond to daemon ping request, with the driver state in the label.
+func (d *Driver) Pong(msg *Message) {
+	d.SendMessage(&Message{
+		Channel: ChannelState,
+		Subject: StatePong,
+		Labels:  map[string]string{"state": d.State},
+	})
+}
+
+// negotiateFormat : agree on the framing and codec requested by daemon in options labels.
+func (d *Driver) negotiateFormat(msg *Message) {
+	framing, hasFraming := msg.Labels["framing"]
+	codecName, hasCodec := msg.Labels["codec"]
+	if !hasFraming && !hasCodec {
+		return
+	}
+
+	framing, codecName = NegotiateFormat(framing, codecName)
+
+	// acknowledge in the current format, then switch
+	err := d.Conn().SwitchFormat(&Message{
+		Channel: ChannelState,
+		Subject: "format",
+		Labels: map[string]string{
+			"framing": framing,
+			"codec":   codecName,
+		},
+	}, framing, codecName)
+	if err != nil {
+		Logger.Warningf("[%s] unable to switch to %s framing with %s codec: %v", d.Service, framing, codecName, err)
+	}
+}
+
 // ReceiveOptions : receive options from daemon.
 func (d *Driver) ReceiveOptions(msg *Message) {
 	msg = DeserializePayload(msg)
@@ -158,21 +318,37 @@ func (d *Driver) ReceiveOptions(msg *Message) {
 			d.APITimeout = time.Duration(apiTimeout)
 		}
 	}
-	if d.ReadySignal != nil {
-		close(d.ReadySignal)
+	d.connLock.Lock()
+	ready := d.ReadySignal
+	d.connLock.Unlock()
+	if ready != nil {
+		close(ready)
 	}
 }
 
 func (d *Driver) start(msg *Message) {
-	if d.ReadySignal != nil {
-		<-d.ReadySignal
+	d.connLock.Lock()
+	ready := d.ReadySignal
+	d.connLock.Unlock()
+	if ready != nil {
+		<-ready
+	}
+
+	d.connLock.Lock()
+	if d.ReadySignal == ready {
 		d.ReadySignal = nil
 	}
+	resumed := d.resumed
+	d.resumed = false
+	d.connLock.Unlock()
 
 	if d.State == "alive" {
-		if d.Reload != nil {
+		switch {
+		case d.Reload != nil:
 			d.Reload(msg)
-		} else {
+		case resumed:
+			// the daemon can not restart a remote driver, keep running with the new options
+		default:
 			d.State = "cold"
 		}
 	} else {
@@ -181,7 +357,12 @@ func (d *Driver) start(msg *Message) {
 		}
 		d.State = "alive"
 	}
-	d.Ping(msg)
+	// report the state along with the capabilities
+	d.SendMessage(&Message{
+		Channel: ChannelState,
+		Subject: d.State,
+		Payload: d.Capabilities(),
+	})
 }
 
 func (d *Driver) stop(msg *Message) {
@@ -195,8 +376,31 @@ func (d *Driver) stop(msg *Message) {
 
 // SendMessage : send a prepared message to daemon.
 func (d *Driver) SendMessage(m *Message) {
+	if err := d.WriteMessage(m); err != nil {
+		panic(err)
+	}
+}
+
+// WriteMessage : send a prepared message to daemon, returns error instead of panic.
+func (d *Driver) WriteMessage(m *Message) error {
 	Logger.Infof("[%s] sending raw message to daemon %s:%s", d.Service, m.Channel, m.Subject)
-	SendMessage(d.Out, m)
+
+	return d.Conn().WriteMessage(m)
+}
+
+// Conn returns the connection to daemon through In and Out, a new connection is
+// created if they are replaced, e.g. in tests.
+func (d *Driver) Conn() *Conn {
+	d.connLock.Lock()
+	defer d.connLock.Unlock()
+	if d.conn == nil || d.conn.In != d.In || d.conn.Out != d.Out {
+		d.conn = NewConn(d.In, d.Out)
+		if d.framing == FramingJSONLines {
+			d.conn.SetFormat(NegotiateFormat(d.framing, CodecJSON))
+		}
+	}
+
+	return d.conn
 }
 
 // CheckOption : get the data from options and check if it is truthy.
@@ -226,7 +430,12 @@ func (d *Driver) GetLogger() *logging.Logger {
 			levelstr = "INFO"
 		}
 		if logFile == nil {
-			logFile = os.NewFile(DriverLogDescriptor, "log")
+			if os.Getenv(ListenEnv) != "" {
+				// not a child process of daemon
+				logFile = os.Stderr
+			} else {
+				logFile = os.NewFile(DriverLogDescriptor, "log")
+			}
 		}
 
 		return GetLogger(d.Name, levelstr, logFile)
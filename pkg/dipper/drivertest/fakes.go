@@ -0,0 +1,341 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package drivertest
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+var (
+	// ErrFailToLock means the lock is held by someone else.
+	ErrFailToLock = errors.New("fail to lock")
+	// ErrFailToUnlock means the lock is not held.
+	ErrFailToUnlock = errors.New("fail to unlock")
+	// ErrNotOwner means the lock is no longer held by the owner.
+	ErrNotOwner = errors.New("not the lock owner")
+)
+
+// Cache is an in-memory fake of the cache feature, e.g. the redis-cache driver.
+type Cache struct {
+	lock   sync.Mutex
+	values map[string]string
+	lists  map[string][]string
+	hashes map[string]map[string]string
+}
+
+// FakeCache answers the RPC calls to the cache feature with an in-memory cache.
+func (h *Harness) FakeCache() *Cache {
+	c := &Cache{values: map[string]string{}, lists: map[string][]string{}, hashes: map[string]map[string]string{}}
+	for method, f := range map[string]RPCFunc{
+		"save":    c.save,
+		"load":    c.load,
+		"incr":    c.incr,
+		"del":     c.del,
+		"exists":  c.exists,
+		"rpush":   c.rpush,
+		"lrange":  c.lrange,
+		"blpop":   c.blpop,
+		"hset":    c.hset,
+		"hdel":    c.hdel,
+		"hgetall": c.hgetall,
+	} {
+		h.Fake("cache", method, f)
+	}
+
+	return c
+}
+
+// Get returns the value saved in the cache.
+func (c *Cache) Get(key string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v, ok := c.values[key]
+
+	return v, ok
+}
+
+// Set saves a value in the cache.
+func (c *Cache) Set(key string, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.values[key] = value
+}
+
+// List returns the items pushed to the list in the cache.
+func (c *Cache) List(key string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string{}, c.lists[key]...)
+}
+
+// Hash returns a copy of the fields saved in the hash in the cache.
+func (c *Cache) Hash(key string) map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ret := map[string]string{}
+	for k, v := range c.hashes[key] {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+func stringify(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return string(dipper.Must(json.Marshal(v)).([]byte))
+}
+
+func (c *Cache) save(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	c.Set(dipper.MustGetMapDataStr(msg.Payload, "key"), stringify(dipper.MustGetMapData(msg.Payload, "value")))
+
+	return nil, nil
+}
+
+func (c *Cache) load(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	if v, ok := c.Get(dipper.MustGetMapDataStr(msg.Payload, "key")); ok {
+		return []byte(v), nil
+	}
+
+	return nil, nil
+}
+
+func (c *Cache) incr(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n, _ := strconv.Atoi(c.values[key])
+	c.values[key] = strconv.Itoa(n + 1)
+
+	return []byte(c.values[key]), nil
+}
+
+func (c *Cache) del(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.values, key)
+	delete(c.lists, key)
+	delete(c.hashes, key)
+
+	return nil, nil
+}
+
+func (c *Cache) exists(msg *dipper.Message) (interface{}, error) {
+	key := string(msg.Payload.([]byte))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, isValue := c.values[key]
+	_, isList := c.lists[key]
+	_, isHash := c.hashes[key]
+	if isValue || isList || isHash {
+		return []byte{1}, nil
+	}
+
+	return nil, nil
+}
+
+func (c *Cache) rpush(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	val := dipper.MustGetMapData(msg.Payload, "value")
+	if toJSON, _ := dipper.GetMapDataBool(msg.Payload, "toJson"); toJSON {
+		val = string(dipper.Must(json.Marshal(val)).([]byte))
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lists[key] = append(c.lists[key], stringify(val))
+
+	return nil, nil
+}
+
+func (c *Cache) lrange(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	start, _ := dipper.GetMapDataInt(msg.Payload, "start")
+	stop, ok := dipper.GetMapDataInt(msg.Payload, "stop")
+	if !ok {
+		stop = -1
+	}
+	raw, _ := dipper.GetMapDataBool(msg.Payload, "raw")
+	del, _ := dipper.GetMapDataBool(msg.Payload, "del")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	list := c.lists[key]
+	if stop < 0 {
+		stop += len(list)
+	}
+	items := []string{}
+	for i := max(start, 0); i <= stop && i < len(list); i++ {
+		items = append(items, list[i])
+	}
+	if del {
+		delete(c.lists, key)
+	}
+
+	if raw {
+		return []byte(strings.Join(items, "")), nil
+	}
+
+	return []byte("[" + strings.Join(items, ", ") + "]"), nil
+}
+
+func (c *Cache) blpop(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	list := c.lists[key]
+	if len(list) == 0 {
+		return []byte{}, nil
+	}
+	c.lists[key] = list[1:]
+
+	return []byte(list[0]), nil
+}
+
+func (c *Cache) hset(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	field := dipper.MustGetMapDataStr(msg.Payload, "field")
+	val := stringify(dipper.MustGetMapData(msg.Payload, "value"))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.hashes[key] == nil {
+		c.hashes[key] = map[string]string{}
+	}
+	c.hashes[key][field] = val
+
+	return nil, nil
+}
+
+func (c *Cache) hdel(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+	field := dipper.MustGetMapDataStr(msg.Payload, "field")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.hashes[key], field)
+	if len(c.hashes[key]) == 0 {
+		delete(c.hashes, key)
+	}
+
+	return nil, nil
+}
+
+func (c *Cache) hgetall(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	key := dipper.MustGetMapDataStr(msg.Payload, "key")
+
+	return dipper.Must(json.Marshal(c.Hash(key))).([]byte), nil
+}
+
+// Locker is an in-memory fake of the locker feature, e.g. the redislock driver.
+type Locker struct {
+	lock   sync.Mutex
+	locks  map[string]time.Time
+	owners map[string]string
+}
+
+// FakeLocker answers the RPC calls to the locker feature with in-memory locks.
+func (h *Harness) FakeLocker() *Locker {
+	l := &Locker{locks: map[string]time.Time{}, owners: map[string]string{}}
+	h.Fake("locker", "lock", l.acquire)
+	h.Fake("locker", "unlock", l.release)
+	h.Fake("locker", "refresh", l.refresh)
+
+	return l
+}
+
+// IsLocked checks if the lock is held and not expired.
+func (l *Locker) IsLocked(name string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.isLocked(name)
+}
+
+func (l *Locker) isLocked(name string) bool {
+	expiry, ok := l.locks[name]
+
+	return ok && time.Now().Before(expiry)
+}
+
+func (l *Locker) acquire(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	name := dipper.MustGetMapDataStr(msg.Payload, "name")
+	expire := dipper.Must(time.ParseDuration(dipper.MustGetMapDataStr(msg.Payload, "expire"))).(time.Duration)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.isLocked(name) {
+		return nil, ErrFailToLock
+	}
+	l.locks[name] = time.Now().Add(expire)
+	l.owners[name], _ = dipper.GetMapDataStr(msg.Payload, "owner")
+
+	return nil, nil
+}
+
+func (l *Locker) refresh(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	name := dipper.MustGetMapDataStr(msg.Payload, "name")
+	owner := dipper.MustGetMapDataStr(msg.Payload, "owner")
+	expire := dipper.Must(time.ParseDuration(dipper.MustGetMapDataStr(msg.Payload, "expire"))).(time.Duration)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.isLocked(name) || l.owners[name] != owner {
+		return nil, ErrNotOwner
+	}
+	l.locks[name] = time.Now().Add(expire)
+
+	return nil, nil
+}
+
+func (l *Locker) release(msg *dipper.Message) (interface{}, error) {
+	msg = dipper.DeserializePayload(msg)
+	name := dipper.MustGetMapDataStr(msg.Payload, "name")
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.isLocked(name) {
+		return nil, ErrFailToUnlock
+	}
+	if owner, ok := dipper.GetMapDataStr(msg.Payload, "owner"); ok && l.owners[name] != owner {
+		return nil, ErrFailToUnlock
+	}
+	delete(l.locks, name)
+	delete(l.owners, name)
+
+	return nil, nil
+}
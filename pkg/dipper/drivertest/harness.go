@@ -0,0 +1,323 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+// Package drivertest provides a harness for testing drivers in process.  The harness plays the part
+// of the daemon, it sends the options to the driver, invokes the commands and the RPCs, collects
+// the events and answers the RPC calls made by the driver with fakes.
+package drivertest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+)
+
+const (
+	// DefaultTimeout is the default time to wait for a message from the driver.
+	DefaultTimeout = 5 * time.Second
+
+	// queueSize is the number of the messages kept for each channel and subject until received.
+	queueSize = 100
+)
+
+// ErrNoFake means the driver calls a RPC that is not faked.
+var ErrNoFake = errors.New("no fake")
+
+// RPCFunc fakes a RPC method of a feature, the returned []byte is sent as is, other values are
+// encoded as the payload.
+type RPCFunc func(msg *dipper.Message) (interface{}, error)
+
+// Harness runs a driver in the test process and plays the part of the daemon.
+type Harness struct {
+	// Driver is the driver under test.
+	Driver *dipper.Driver
+	// Timeout is the time to wait for a message from the driver.
+	Timeout time.Duration
+
+	t    testing.TB
+	conn *dipper.Conn
+	in   *io.PipeWriter
+	out  *io.PipeReader
+	done chan struct{}
+
+	lock     sync.Mutex
+	counter  int
+	fakes    map[string]RPCFunc
+	returns  map[string]chan *dipper.Message
+	messages map[string]chan *dipper.Message
+}
+
+// New creates a driver for the service, and runs it with the run function in the background.  The
+// run function is expected to set up the handlers and call Run on the driver, e.g. the main function
+// of the driver.  The driver is stopped when the test finishes.
+func New(t testing.TB, service string, name string, run func(*dipper.Driver), opts ...dipper.DriverOption) *Harness {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	logFile, ok := dipper.LoggingWriter.(*os.File)
+	if !ok {
+		var err error
+		if logFile, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+			t.Fatalf("unable to open %s for logging: %v", os.DevNull, err)
+		}
+	}
+	opts = append([]dipper.DriverOption{
+		dipper.DriverWithReader(inR),
+		dipper.DriverWithWriter(outW),
+		dipper.DriverWithLogFile(logFile),
+	}, opts...)
+
+	h := &Harness{
+		Driver:   dipper.NewDriver(service, name, opts...),
+		Timeout:  DefaultTimeout,
+		t:        t,
+		conn:     dipper.NewConn(outR, inW),
+		in:       inW,
+		out:      outR,
+		done:     make(chan struct{}),
+		fakes:    map[string]RPCFunc{},
+		returns:  map[string]chan *dipper.Message{},
+		messages: map[string]chan *dipper.Message{},
+	}
+
+	go func() {
+		defer close(h.done)
+		run(h.Driver)
+	}()
+	go h.receive()
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+// Close stops the driver and waits for it to exit.
+func (h *Harness) Close() {
+	h.Driver.State = dipper.DriverStateCompleted
+	h.in.Close()
+	select {
+	case <-h.done:
+	case <-time.After(h.Timeout):
+		h.t.Errorf("driver %s did not exit", h.Driver.Name)
+	}
+	h.out.Close()
+}
+
+// Start sends the driver data in the options and starts the driver, then waits for it to be alive.
+func (h *Harness) Start(data interface{}) {
+	h.t.Helper()
+
+	h.Send(&dipper.Message{
+		Channel: "command",
+		Subject: "options",
+		Payload: map[string]interface{}{"data": data},
+	})
+	h.Send(&dipper.Message{
+		Channel: "command",
+		Subject: "start",
+	})
+	if state := h.Next(dipper.ChannelState, "alive"); state == nil {
+		h.t.Fatalf("driver %s is not alive", h.Driver.Name)
+	}
+}
+
+// Send sends a message to the driver.
+func (h *Harness) Send(msg *dipper.Message) {
+	h.t.Helper()
+
+	if err := h.conn.WriteMessage(msg); err != nil {
+		h.t.Fatalf("unable to send %s:%s to driver: %v", msg.Channel, msg.Subject, err)
+	}
+}
+
+// Command invokes a command of the driver like a workflow does, and returns the eventbus:return
+// message with the status and the payload.
+func (h *Harness) Command(method string, params interface{}) *dipper.Message {
+	h.t.Helper()
+
+	id, ret := h.expectReturn("eventbus")
+	h.Send(&dipper.Message{
+		Channel: "eventbus",
+		Subject: "command",
+		Labels: map[string]string{
+			"method":    method,
+			"sessionID": id,
+		},
+		Payload: params,
+	})
+
+	return h.wait(ret, "eventbus:return of "+method)
+}
+
+// Call invokes a RPC method of the driver, and returns the rpc:return message, the error is in the
+// error label.
+func (h *Harness) Call(method string, params interface{}) *dipper.Message {
+	h.t.Helper()
+
+	id, ret := h.expectReturn("rpc")
+	h.Send(&dipper.Message{
+		Channel: "rpc",
+		Subject: "call",
+		Labels: map[string]string{
+			"method": method,
+			"rpcID":  id,
+			"caller": "drivertest",
+		},
+		Payload: params,
+	})
+
+	return h.wait(ret, "rpc:return of "+method)
+}
+
+// Next waits for the next message from the driver on the channel and subject, and returns nil if
+// the driver does not send the message in time.
+func (h *Harness) Next(channel string, subject string) *dipper.Message {
+	h.t.Helper()
+
+	return h.wait(h.queue(channel+":"+subject), channel+":"+subject)
+}
+
+// Event waits for the next event emitted by the driver.
+func (h *Harness) Event() *dipper.Message {
+	h.t.Helper()
+
+	return h.Next("eventbus", "message")
+}
+
+// Fake answers the RPC calls to the method of the feature made by the driver with the function.
+func (h *Harness) Fake(feature string, method string, f RPCFunc) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.fakes[feature+"."+method] = f
+}
+
+func (h *Harness) expectReturn(channel string) (string, chan *dipper.Message) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.counter++
+	id := strconv.Itoa(h.counter)
+	ret := make(chan *dipper.Message, 1)
+	h.returns[channel+":"+id] = ret
+
+	return id, ret
+}
+
+func (h *Harness) queue(key string) chan *dipper.Message {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	q, ok := h.messages[key]
+	if !ok {
+		q = make(chan *dipper.Message, queueSize)
+		h.messages[key] = q
+	}
+
+	return q
+}
+
+func (h *Harness) wait(ch <-chan *dipper.Message, what string) *dipper.Message {
+	h.t.Helper()
+
+	select {
+	case msg := <-ch:
+		return dipper.DeserializePayload(msg)
+	case <-time.After(h.Timeout):
+		h.t.Errorf("timeout waiting for %s from driver %s", what, h.Driver.Name)
+
+		return nil
+	}
+}
+
+// receive dispatches the messages from the driver until it exits.
+func (h *Harness) receive() {
+	for {
+		msg, err := h.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		key := ""
+		switch msg.Channel + ":" + msg.Subject {
+		case "rpc:call":
+			go h.serve(msg)
+
+			continue
+		case "rpc:return":
+			key = "rpc:" + msg.Labels["rpcID"]
+		case "eventbus:return":
+			key = "eventbus:" + msg.Labels["sessionID"]
+		}
+
+		h.lock.Lock()
+		ret, ok := h.returns[key]
+		delete(h.returns, key)
+		h.lock.Unlock()
+		if !ok {
+			ret = h.queue(msg.Channel + ":" + msg.Subject)
+		}
+		ret <- msg
+	}
+}
+
+// serve answers a RPC call from the driver with the fake.
+func (h *Harness) serve(msg *dipper.Message) {
+	feature, method := msg.Labels["feature"], msg.Labels["method"]
+
+	h.lock.Lock()
+	f, ok := h.fakes[feature+"."+method]
+	h.lock.Unlock()
+
+	if !ok {
+		f = func(*dipper.Message) (interface{}, error) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrNoFake, feature, method)
+		}
+	}
+	payload, err := call(f, msg)
+
+	rpcID := msg.Labels["rpcID"]
+	if rpcID == "" || rpcID == dipper.RPCSkip {
+		return
+	}
+	ret := &dipper.Message{
+		Channel: "rpc",
+		Subject: "return",
+		Labels: map[string]string{
+			"rpcID":  rpcID,
+			"caller": msg.Labels["caller"],
+		},
+	}
+	switch {
+	case err != nil:
+		ret.Labels["error"] = err.Error()
+	case payload != nil:
+		ret.Payload = payload
+		if b, ok := payload.([]byte); ok {
+			ret.Payload, ret.IsRaw = b, true
+		}
+	}
+	_ = h.conn.WriteMessage(ret)
+}
+
+// call runs the fake, and turns the panic into an error.
+func call(f RPCFunc, msg *dipper.Message) (payload interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return f(msg)
+}
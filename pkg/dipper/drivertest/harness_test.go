@@ -0,0 +1,129 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package drivertest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	if dipper.Logger == nil {
+		logFile, err := os.Create("test.log")
+		if err != nil {
+			panic(err)
+		}
+		defer logFile.Close()
+		dipper.Logger = dipper.GetLogger("test", "INFO", logFile, logFile)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestDriver sets up a driver using the cache and the locker.
+func runTestDriver(d *dipper.Driver) {
+	d.Commands["greet"] = func(msg *dipper.Message) {
+		msg = dipper.DeserializePayload(msg)
+		greeting, _ := d.GetOptionStr("data.greeting")
+		msg.Reply <- dipper.Message{
+			Payload: map[string]interface{}{"text": greeting + " " + dipper.MustGetMapDataStr(msg.Payload, "name")},
+		}
+	}
+	d.Commands["emit"] = func(msg *dipper.Message) {
+		d.EmitEvent(map[string]interface{}{"data": "fired"})
+		msg.Reply <- dipper.Message{}
+	}
+	d.Commands["remember"] = func(msg *dipper.Message) {
+		msg = dipper.DeserializePayload(msg)
+		name := dipper.MustGetMapDataStr(msg.Payload, "name")
+		dipper.Must(d.Call("locker", "lock", map[string]interface{}{"name": name, "expire": "1m"}))
+		dipper.Must(d.Call("cache", "save", map[string]interface{}{"key": name, "value": "remembered"}))
+		msg.Reply <- dipper.Message{}
+	}
+	d.RPCHandlers["recall"] = func(msg *dipper.Message) {
+		msg = dipper.DeserializePayload(msg)
+		ret, err := d.Call("cache", "load", map[string]interface{}{"key": dipper.MustGetMapDataStr(msg.Payload, "name")})
+		if err != nil {
+			panic(err)
+		}
+		msg.Reply <- dipper.Message{Payload: map[string]interface{}{"value": string(ret)}}
+	}
+	d.Run()
+}
+
+func TestHarness(t *testing.T) {
+	h := New(t, "operator", "test", runTestDriver)
+	cache := h.FakeCache()
+	locker := h.FakeLocker()
+	h.Start(map[string]interface{}{"greeting": "hello"})
+
+	ret := h.Command("greet", map[string]interface{}{"name": "world"})
+	assert.Equal(t, dipper.SUCCESS, ret.Labels["status"], "should return success")
+	assert.Equal(t, "hello world", dipper.MustGetMapDataStr(ret.Payload, "text"), "should use the driver data")
+
+	ret = h.Command("emit", nil)
+	assert.Equal(t, dipper.SUCCESS, ret.Labels["status"])
+	event := h.Event()
+	assert.Equal(t, "fired", dipper.MustGetMapDataStr(event.Payload, "data"), "should receive the emitted event")
+
+	ret = h.Command("remember", map[string]interface{}{"name": "key1"})
+	assert.Equal(t, dipper.SUCCESS, ret.Labels["status"])
+	assert.True(t, locker.IsLocked("key1"), "should acquire the lock in the fake locker")
+	v, ok := cache.Get("key1")
+	assert.True(t, ok)
+	assert.Equal(t, "remembered", v, "should save in the fake cache")
+
+	ret = h.Command("remember", map[string]interface{}{"name": "key1"})
+	assert.Equal(t, dipper.ERROR, ret.Labels["status"], "should fail to lock twice")
+
+	ret = h.Call("recall", map[string]interface{}{"name": "key1"})
+	assert.Empty(t, ret.Labels["error"])
+	assert.Equal(t, "remembered", dipper.MustGetMapDataStr(ret.Payload, "value"), "should load from the fake cache")
+
+	ret = h.Command("unknown", nil)
+	assert.Equal(t, dipper.ERROR, ret.Labels["status"], "should fail unknown commands")
+}
+
+func TestHarnessFake(t *testing.T) {
+	h := New(t, "operator", "test", runTestDriver)
+	h.Start(nil)
+
+	ret := h.Call("recall", map[string]interface{}{"name": "key1"})
+	assert.Contains(t, ret.Labels["error"], ErrNoFake.Error(), "should fail the calls not faked")
+
+	h.Fake("cache", "load", func(msg *dipper.Message) (interface{}, error) {
+		return []byte("faked"), nil
+	})
+	ret = h.Call("recall", map[string]interface{}{"name": "key1"})
+	assert.Equal(t, "faked", dipper.MustGetMapDataStr(ret.Payload, "value"), "should answer with the fake")
+}
+
+func TestCacheLists(t *testing.T) {
+	c := &Cache{values: map[string]string{}, lists: map[string][]string{}}
+	for _, v := range []interface{}{"a", map[string]interface{}{"b": 1}} {
+		_, err := c.rpush(&dipper.Message{Payload: map[string]interface{}{"key": "list", "value": v}})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"a", `{"b":1}`}, c.List("list"))
+
+	ret, _ := c.lrange(&dipper.Message{Payload: map[string]interface{}{"key": "list"}})
+	assert.Equal(t, `[a, {"b":1}]`, string(ret.([]byte)))
+
+	ret, _ = c.blpop(&dipper.Message{Payload: map[string]interface{}{"key": "list"}})
+	assert.Equal(t, "a", string(ret.([]byte)), "should pop the first item")
+
+	ret, _ = c.exists(&dipper.Message{Payload: []byte("list")})
+	assert.Equal(t, []byte{1}, ret)
+	_, _ = c.del(&dipper.Message{Payload: map[string]interface{}{"key": "list"}})
+	ret, _ = c.exists(&dipper.Message{Payload: []byte("list")})
+	assert.Nil(t, ret, "should delete the list")
+}
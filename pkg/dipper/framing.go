@@ -0,0 +1,415 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// names of the message framings.
+const (
+	FramingText      = "text"
+	FramingBinary    = "binary"
+	FramingJSONLines = "jsonl"
+)
+
+// FramingEnv is the environment variable telling the driver to use a framing from the start
+// without negotiation, e.g. jsonl for drivers written in other languages.
+const FramingEnv = "HONEYDIPPER_DRIVER_FRAMING"
+
+const (
+	// BinaryFrameVersion is the version of the binary envelope layout.
+	BinaryFrameVersion byte = 1
+
+	// MaxBinaryFrameSize is the largest binary frame accepted from a peer.
+	MaxBinaryFrameSize = 1 << 30
+)
+
+// ErrInvalidFrame indicates the message envelope can not be parsed.
+var ErrInvalidFrame = errors.New("invalid message envelope")
+
+// CommFormat describes how messages are framed and encoded on a comm channel.
+type CommFormat struct {
+	Framing string
+	Codec   string
+}
+
+// NegotiateFormat picks the framing and codec to use based on what the peer asks for,
+// falling back to text and json for anything not supported.
+func NegotiateFormat(framing string, codecName string) (string, string) {
+	switch framing {
+	case FramingBinary, FramingJSONLines:
+	default:
+		framing = FramingText
+	}
+
+	if _, err := GetCodec(codecName); err != nil || framing != FramingBinary {
+		// only binary framing has a place to tag the payload encoding.
+		codecName = CodecJSON
+	}
+
+	return framing, codecName
+}
+
+func readTextFrame(in io.Reader) (*Message, error) {
+	var (
+		channel   string
+		subject   string
+		size      int
+		numLabels int
+	)
+
+	_, err := fmt.Fscanln(in, &channel, &subject, &numLabels, &size)
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	} else if err != nil {
+		if strings.Contains(err.Error(), "file already closed") {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	msg := &Message{
+		Channel: channel,
+		Subject: subject,
+		IsRaw:   true,
+		Size:    size,
+	}
+
+	msg.Labels = map[string]string{}
+	for ; numLabels > 0; numLabels-- {
+		var (
+			lname string
+			vl    int
+		)
+
+		_, err := fmt.Fscanln(in, &lname, &vl)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch message label name: %w", err)
+		}
+		if vl > 0 {
+			lvalue := make([]byte, vl)
+			if _, err = io.ReadFull(in, lvalue); err != nil {
+				return nil, fmt.Errorf("unable to fetch value for label %s: %w", lname, err)
+			}
+
+			msg.Labels[lname] = string(lvalue)
+		} else {
+			msg.Labels[lname] = ""
+		}
+	}
+
+	if size > 0 {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return nil, err
+		}
+		msg.Payload = buf
+	}
+
+	return msg, nil
+}
+
+func writeTextFrame(out io.Writer, msg *Message, payload []byte) error {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s %s %d %d\n", msg.Channel, msg.Subject, len(msg.Labels), len(payload))
+	for lname, lval := range msg.Labels {
+		fmt.Fprintf(&buf, "%s %d\n", lname, len(lval))
+		buf.WriteString(lval)
+	}
+	buf.Write(payload)
+
+	_, err := out.Write(buf.Bytes())
+
+	return err
+}
+
+// jsonLine is a message in the jsonl framing, one JSON object per line.  The payload is embedded
+// as is if it is valid JSON, otherwise it is carried base64 encoded in payloadBase64.
+type jsonLine struct {
+	Channel       string            `json:"channel"`
+	Subject       string            `json:"subject"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+	PayloadBase64 []byte            `json:"payloadBase64,omitempty"`
+}
+
+func readJSONLineFrame(in io.Reader) (*Message, error) {
+	var line []byte
+	for len(bytes.TrimSpace(line)) == 0 {
+		var err error
+		if line, err = readLine(in); err != nil {
+			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "file already closed") {
+				return nil, io.EOF
+			}
+
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+		}
+	}
+
+	frame := jsonLine{}
+	if err := json.Unmarshal(line, &frame); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+	if frame.Channel == "" || frame.Subject == "" {
+		return nil, fmt.Errorf("%w: missing channel or subject", ErrInvalidFrame)
+	}
+
+	msg := &Message{
+		Channel: frame.Channel,
+		Subject: frame.Subject,
+		Labels:  frame.Labels,
+		IsRaw:   true,
+	}
+	if msg.Labels == nil {
+		msg.Labels = map[string]string{}
+	}
+
+	var payload []byte
+	switch {
+	case len(frame.PayloadBase64) > 0:
+		payload = frame.PayloadBase64
+	case len(frame.Payload) > 0 && string(frame.Payload) != "null":
+		payload = frame.Payload
+	}
+	if len(payload) > 0 {
+		msg.Payload = payload
+		msg.Size = len(payload)
+	}
+
+	return msg, nil
+}
+
+// readLine reads up to and including the next newline, the line is returned without error if the
+// stream ends with an unterminated line.
+func readLine(in io.Reader) ([]byte, error) {
+	if r, ok := in.(*bufio.Reader); ok {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			err = nil
+		}
+
+		return line, err
+	}
+
+	// read byte by byte so nothing after the line is consumed from an unbuffered stream.
+	var (
+		line []byte
+		b    [1]byte
+	)
+	for len(line) <= MaxBinaryFrameSize {
+		if _, err := io.ReadFull(in, b[:]); err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				return line, nil
+			}
+
+			return nil, err
+		}
+		line = append(line, b[0])
+		if b[0] == '\n' {
+			return line, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: line too long", ErrInvalidFrame)
+}
+
+func writeJSONLineFrame(out io.Writer, msg *Message, payload []byte) error {
+	frame := jsonLine{
+		Channel: msg.Channel,
+		Subject: msg.Subject,
+		Labels:  msg.Labels,
+	}
+	if len(payload) > 0 {
+		if json.Valid(payload) {
+			frame.Payload = payload
+		} else {
+			frame.PayloadBase64 = payload
+		}
+	}
+
+	// the embedded payload is compacted by the encoder, so the frame stays on a single line.
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	_, err = out.Write(append(line, '\n'))
+
+	return err
+}
+
+// A binary frame is laid out as below, all integers in big endian.
+//
+//	uint32  length of the rest of the frame
+//	uint8   version
+//	uint8   length of channel, followed by channel
+//	uint8   length of subject, followed by subject
+//	uint8   length of codec name, followed by codec name
+//	uint16  number of labels, followed by each label as
+//	          uint16 length of name, name, uint32 length of value, value
+//	payload takes the rest of the frame
+func readBinaryFrame(in io.Reader) (*Message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "file already closed") {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxBinaryFrameSize {
+		return nil, fmt.Errorf("%w: frame too large %d", ErrInvalidFrame, length)
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(in, frame); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	r := &frameReader{buf: frame}
+	if v := r.uint8(); v != BinaryFrameVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidFrame, v)
+	}
+
+	msg := &Message{
+		Channel: string(r.bytes(int(r.uint8()))),
+		Subject: string(r.bytes(int(r.uint8()))),
+		Codec:   string(r.bytes(int(r.uint8()))),
+		IsRaw:   true,
+		Labels:  map[string]string{},
+	}
+
+	for numLabels := r.uint16(); numLabels > 0 && r.err == nil; numLabels-- {
+		lname := string(r.bytes(int(r.uint16())))
+		msg.Labels[lname] = string(r.bytes(int(r.uint32())))
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, r.err)
+	}
+
+	if payload := r.buf[r.pos:]; len(payload) > 0 {
+		msg.Payload = payload
+		msg.Size = len(payload)
+	}
+
+	return msg, nil
+}
+
+func writeBinaryFrame(out io.Writer, msg *Message, payload []byte, codecName string) error {
+	if len(msg.Channel) > math.MaxUint8 || len(msg.Subject) > math.MaxUint8 || len(codecName) > math.MaxUint8 {
+		return fmt.Errorf("%w: channel, subject or codec name too long", ErrInvalidFrame)
+	}
+	if len(msg.Labels) > math.MaxUint16 {
+		return fmt.Errorf("%w: too many labels", ErrInvalidFrame)
+	}
+
+	w := &frameWriter{}
+	w.buf.Write([]byte{0, 0, 0, 0})
+	w.buf.WriteByte(BinaryFrameVersion)
+	w.buf.WriteByte(byte(len(msg.Channel)))
+	w.buf.WriteString(msg.Channel)
+	w.buf.WriteByte(byte(len(msg.Subject)))
+	w.buf.WriteString(msg.Subject)
+	w.buf.WriteByte(byte(len(codecName)))
+	w.buf.WriteString(codecName)
+	w.uint16(uint16(len(msg.Labels)))
+	for lname, lval := range msg.Labels {
+		if len(lname) > math.MaxUint16 {
+			return fmt.Errorf("%w: label name too long %s", ErrInvalidFrame, lname[:math.MaxUint8])
+		}
+		w.uint16(uint16(len(lname)))
+		w.buf.WriteString(lname)
+		w.uint32(uint32(len(lval)))
+		w.buf.WriteString(lval)
+	}
+	w.buf.Write(payload)
+
+	frame := w.buf.Bytes()
+	if len(frame)-4 > MaxBinaryFrameSize {
+		return fmt.Errorf("%w: frame too large %d", ErrInvalidFrame, len(frame)-4)
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	_, err := out.Write(frame)
+
+	return err
+}
+
+type frameReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *frameReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.pos+n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+
+		return nil
+	}
+	ret := r.buf[r.pos : r.pos+n]
+	r.pos += n
+
+	return ret
+}
+
+func (r *frameReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (r *frameReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+func (r *frameReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+type frameWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *frameWriter) uint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *frameWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextFraming(t *testing.T) {
+	b := &bytes.Buffer{}
+	SendMessage(b, &Message{
+		Channel: "eventbus",
+		Subject: "message",
+		Labels:  map[string]string{"label1": "value1"},
+		Payload: map[string]interface{}{"key": "value"},
+	})
+
+	msg := FetchMessage(b)
+	assert.Equal(t, "eventbus", msg.Channel, "channel should match")
+	assert.Equal(t, "message", msg.Subject, "subject should match")
+	assert.Equal(t, "value1", msg.Labels["label1"], "label should match")
+	assert.Equal(t, map[string]interface{}{"key": "value"}, msg.Payload, "payload should match")
+}
+
+func TestBinaryFraming(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewConn(b, b)
+	c.SetFormat(FramingBinary, CodecMsgpack)
+
+	c.SendMessage(&Message{
+		Channel: "eventbus",
+		Subject: "message",
+		Labels:  map[string]string{"label1": "value1", "empty": ""},
+		Payload: map[string]interface{}{"key": "value"},
+	})
+	c.SendMessage(&Message{
+		Channel: "rpc",
+		Subject: "return",
+		Payload: []byte("opaque"),
+		IsRaw:   true,
+	})
+
+	msg := c.FetchRawMessage()
+	assert.Equal(t, "eventbus", msg.Channel, "channel should match")
+	assert.Equal(t, "message", msg.Subject, "subject should match")
+	assert.Equal(t, CodecMsgpack, msg.Codec, "payload should be tagged with codec")
+	assert.Equal(t, map[string]string{"label1": "value1", "empty": ""}, msg.Labels, "labels should match")
+	assert.Equal(t, map[string]interface{}{"key": "value"}, DeserializePayload(msg).Payload, "payload should match")
+
+	msg = c.FetchRawMessage()
+	assert.Equal(t, "", msg.Codec, "raw payload should not be tagged")
+	assert.Equal(t, []byte("opaque"), msg.Payload, "raw payload should be sent as is")
+
+	assert.PanicsWithValue(t, io.EOF, func() { c.FetchRawMessage() }, "should panic with EOF at the end")
+}
+
+func TestBinaryFramingInvalid(t *testing.T) {
+	c := NewConn(bytes.NewBuffer([]byte{0, 0, 0, 2, 9, 0}), nil)
+	c.SetFormat(FramingBinary, CodecJSON)
+
+	assert.Panics(t, func() { c.FetchRawMessage() }, "should panic with unsupported version")
+}
+
+func TestJSONLinesFraming(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewConn(b, b)
+	c.SetFormat(NegotiateFormat(FramingJSONLines, CodecMsgpack))
+
+	c.SendMessage(&Message{
+		Channel: "eventbus",
+		Subject: "message",
+		Labels:  map[string]string{"label1": "value1"},
+		Payload: map[string]interface{}{"key": "line1\nline2"},
+	})
+	c.SendMessage(&Message{
+		Channel: "rpc",
+		Subject: "return",
+		Payload: []byte{0xff, 0x00},
+		IsRaw:   true,
+	})
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte("\n")), "each message should take one line")
+	b.WriteString("\n{\"channel\":\"command\",\"subject\":\"start\"}")
+
+	msg := c.FetchMessage()
+	assert.Equal(t, "eventbus", msg.Channel, "channel should match")
+	assert.Equal(t, "message", msg.Subject, "subject should match")
+	assert.Equal(t, map[string]string{"label1": "value1"}, msg.Labels, "labels should match")
+	assert.Equal(t, map[string]interface{}{"key": "line1\nline2"}, msg.Payload, "payload should be embedded as json")
+
+	msg = c.FetchRawMessage()
+	assert.Equal(t, []byte{0xff, 0x00}, msg.Payload, "non json payload should be carried in base64")
+
+	msg = c.FetchRawMessage()
+	assert.Equal(t, "command:start", msg.Channel+":"+msg.Subject, "should skip blank line and read unterminated line")
+	assert.Nil(t, msg.Payload, "payload should be empty")
+
+	assert.PanicsWithValue(t, io.EOF, func() { c.FetchRawMessage() }, "should panic with EOF at the end")
+}
+
+func TestJSONLinesFramingUnbuffered(t *testing.T) {
+	in := bytes.NewBufferString(`{"channel":"rpc","subject":"call","payload":{"a":1}}` + "\n" + "rest")
+	msg, err := readMessage(in, FramingJSONLines)
+	assert.Nil(t, err, "should read the line")
+	assert.Equal(t, `{"a":1}`, string(msg.Payload.([]byte)), "payload should match")
+	assert.Equal(t, "rest", in.String(), "should not consume beyond the line")
+
+	_, err = readMessage(bytes.NewBufferString("{\"channel\":\"rpc\"}\n"), FramingJSONLines)
+	assert.ErrorIs(t, err, ErrInvalidFrame, "should reject missing subject")
+}
+
+func TestSendTaggedPayloadThroughText(t *testing.T) {
+	packed, err := EncodeContent(CodecCBOR, map[string]interface{}{"key": "value"})
+	assert.Nil(t, err, "encoding cbor should not raise err")
+
+	b := &bytes.Buffer{}
+	SendMessage(b, &Message{
+		Channel: "rpc",
+		Subject: "return",
+		Payload: packed,
+		IsRaw:   true,
+		Codec:   CodecCBOR,
+	})
+
+	msg := FetchRawMessage(b)
+	assert.Equal(t, `{"key":"value"}`, string(msg.Payload.([]byte)), "should transcode into json for text framing")
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	framing, codecName := NegotiateFormat(FramingBinary, CodecCBOR)
+	assert.Equal(t, FramingBinary, framing, "should accept binary framing")
+	assert.Equal(t, CodecCBOR, codecName, "should accept cbor codec")
+
+	framing, codecName = NegotiateFormat(FramingBinary, "xml")
+	assert.Equal(t, FramingBinary, framing, "should accept binary framing")
+	assert.Equal(t, CodecJSON, codecName, "should fall back to json for unknown codec")
+
+	framing, codecName = NegotiateFormat("morse", CodecMsgpack)
+	assert.Equal(t, FramingText, framing, "should fall back to text for unknown framing")
+	assert.Equal(t, CodecJSON, codecName, "should use json with text framing")
+
+	framing, codecName = NegotiateFormat(FramingJSONLines, CodecCBOR)
+	assert.Equal(t, FramingJSONLines, framing, "should accept jsonl framing")
+	assert.Equal(t, CodecJSON, codecName, "should use json with jsonl framing")
+}
@@ -12,6 +12,7 @@
 package mock_dipper
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -106,6 +107,36 @@ func (mr *MockRPCCallerMockRecorder) Call(feature, method, params interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Call", reflect.TypeOf((*MockRPCCaller)(nil).Call), feature, method, params)
 }
 
+// CallContext mocks base method.
+func (m *MockRPCCaller) CallContext(ctx context.Context, feature, method string, params interface{}) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CallContext", ctx, feature, method, params)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CallContext indicates an expected call of CallContext.
+func (mr *MockRPCCallerMockRecorder) CallContext(ctx, feature, method, params interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CallContext", reflect.TypeOf((*MockRPCCaller)(nil).CallContext), ctx, feature, method, params)
+}
+
+// CallCtx mocks base method.
+func (m *MockRPCCaller) CallCtx(ctx context.Context, feature, method string, params interface{}) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CallCtx", ctx, feature, method, params)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CallCtx indicates an expected call of CallCtx.
+func (mr *MockRPCCallerMockRecorder) CallCtx(ctx, feature, method, params interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CallCtx", reflect.TypeOf((*MockRPCCaller)(nil).CallCtx), ctx, feature, method, params)
+}
+
 // CallNoWait mocks base method.
 func (m *MockRPCCaller) CallNoWait(feature, method string, params interface{}) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,126 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Environment variables for running a driver outside of the daemon, listening for the daemon to connect.
+const (
+	// ListenEnv is the address to listen on, e.g. unix:///var/run/honeydipper/driver.sock or tcp://:9000.
+	ListenEnv = "HONEYDIPPER_DRIVER_LISTEN"
+
+	// TLSCertEnv is the certificate file presented to the daemon, required when listening on tcp.
+	TLSCertEnv = "HONEYDIPPER_DRIVER_TLS_CERT"
+
+	// TLSKeyEnv is the private key file of the certificate.
+	TLSKeyEnv = "HONEYDIPPER_DRIVER_TLS_KEY"
+
+	// TLSCAEnv is the CA file for verifying the client certificate of the daemon.
+	TLSCAEnv = "HONEYDIPPER_DRIVER_TLS_CA"
+
+	// InsecureEnv allows listening on tcp without tls when set to "true", matching the insecure
+	// remote drivers in the daemon.
+	InsecureEnv = "HONEYDIPPER_DRIVER_INSECURE"
+)
+
+var (
+	// ErrInvalidAddress indicates the address is not a unix or tcp address.
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrInvalidCertificate indicates the certificates can not be loaded.
+	ErrInvalidCertificate = errors.New("invalid certificate")
+)
+
+// ParseAddress splits an address like unix:///path/to/socket or tcp://host:port into network and address.
+func ParseAddress(addr string) (network string, address string, err error) {
+	network, address, found := strings.Cut(addr, "://")
+	if !found || address == "" || (network != "unix" && network != "tcp") {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidAddress, addr)
+	}
+
+	return network, address, nil
+}
+
+// LoadTLSConfig creates a tls config with the certificate and the CA, the CA is used for verifying both
+// server and client certificates for mutual authentication.
+func LoadTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificate in %s", ErrInvalidCertificate, caFile)
+		}
+		cfg.RootCAs = pool
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// Listen listens on the network address, with tls if tlsConfig is not nil.  The stale unix socket
+// left by a previous run is removed.
+func Listen(network string, address string, tlsConfig *tls.Config) (net.Listener, error) {
+	if network == "unix" {
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(address)
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	return l, nil
+}
+
+// listenFromEnv creates the listener for the daemon to connect to as configured in environment variables,
+// tcp requires mutual tls unless marked insecure, unix socket uses mutual tls when the certificates are given.
+func listenFromEnv(addr string) (net.Listener, error) {
+	network, address, err := ParseAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	certFile, caFile := os.Getenv(TLSCertEnv), os.Getenv(TLSCAEnv)
+	switch {
+	case certFile != "" && caFile != "":
+		if tlsConfig, err = LoadTLSConfig(caFile, certFile, os.Getenv(TLSKeyEnv)); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case certFile != "" || caFile != "" || (network == "tcp" && os.Getenv(InsecureEnv) != "true"):
+		return nil, fmt.Errorf("%w: both %s and %s are required for mutual tls", ErrInvalidCertificate, TLSCertEnv, TLSCAEnv)
+	}
+
+	return Listen(network, address, tlsConfig)
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddress(t *testing.T) {
+	network, address, err := ParseAddress("unix:///var/run/driver.sock")
+	assert.NoError(t, err, "should parse unix address")
+	assert.Equal(t, "unix", network, "should parse unix network")
+	assert.Equal(t, "/var/run/driver.sock", address, "should parse socket path")
+
+	network, address, err = ParseAddress("tcp://driver:9000")
+	assert.NoError(t, err, "should parse tcp address")
+	assert.Equal(t, "tcp", network, "should parse tcp network")
+	assert.Equal(t, "driver:9000", address, "should parse host and port")
+
+	for _, addr := range []string{"driver:9000", "udp://driver:9000", "tcp://"} {
+		_, _, err = ParseAddress(addr)
+		assert.ErrorIs(t, err, ErrInvalidAddress, "should refuse %s", addr)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	_, err := LoadTLSConfig("", "missing.crt", "missing.key")
+	assert.ErrorIs(t, err, ErrInvalidCertificate, "should fail on missing certificate")
+
+	ca := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, os.WriteFile(ca, []byte("not a certificate"), 0o600))
+	_, err = LoadTLSConfig(ca, "", "")
+	assert.ErrorIs(t, err, ErrInvalidCertificate, "should fail on malformed CA")
+}
+
+func TestListenFromEnvRequiresTLS(t *testing.T) {
+	_, err := listenFromEnv("tcp://127.0.0.1:0")
+	assert.ErrorIs(t, err, ErrInvalidCertificate, "should refuse tcp without mutual tls")
+}
+
+func TestListenFromEnvInsecure(t *testing.T) {
+	t.Setenv(InsecureEnv, "true")
+	l, err := listenFromEnv("tcp://127.0.0.1:0")
+	assert.NoError(t, err, "should listen on plain tcp when marked insecure")
+	if l != nil {
+		_ = l.Close()
+	}
+}
+
+func TestDriverServe(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "driver.sock")
+	l, err := Listen("unix", sock, nil)
+	assert.NoError(t, err, "should listen on unix socket")
+
+	d := NewDriver("operator", "test")
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		d.Serve(l)
+	}()
+
+	session := func(expected string) {
+		c, err := net.Dial("unix", sock)
+		assert.NoError(t, err, "should connect to driver")
+		defer c.Close()
+
+		conn := NewConn(c, c)
+		conn.SendMessage(&Message{Channel: "command", Subject: "options", Payload: map[string]interface{}{}})
+		conn.SendMessage(&Message{Channel: "command", Subject: "start"})
+		msg := conn.FetchRawMessage()
+		assert.Equal(t, ChannelState, msg.Channel, "driver should report state")
+		assert.Equal(t, expected, msg.Subject, "driver should report %s", expected)
+
+		conn.SendMessage(&Message{Channel: "command", Subject: "ping"})
+		msg = conn.FetchRawMessage()
+		assert.Equal(t, StatePong, msg.Subject, "driver should reply to ping with pong")
+		assert.Equal(t, expected, msg.Labels["state"], "pong should carry the driver state")
+	}
+
+	session("alive")
+	// daemon restarted
+	session("alive")
+
+	assert.NoError(t, l.Close())
+	<-served
+}
@@ -7,6 +7,8 @@
 package dipper
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +22,9 @@ const DefaultRPCTimeout time.Duration = 10
 // RPCSkip is used to indicate no RPC return is expected.
 const RPCSkip = "skip"
 
+// RPCCancel is the subject of the message asking the callee to cancel a call.
+const RPCCancel = "cancel"
+
 var (
 	// ErrTimeout indicates a timeout error.
 	ErrTimeout = errors.New("timeout")
@@ -40,6 +45,8 @@ type RPCCallerStub interface {
 // RPCCaller defines all method required for making rpc alls.
 type RPCCaller interface {
 	Call(feature string, method string, params interface{}) ([]byte, error)
+	CallContext(ctx context.Context, feature string, method string, params interface{}) ([]byte, error)
+	CallCtx(ctx context.Context, feature string, method string, params interface{}) ([]byte, error)
 	CallNoWait(feature string, method string, params interface{}) error
 	CallRaw(feature string, method string, params []byte) ([]byte, error)
 	CallRawNoWait(feature string, method string, params []byte, rpcID string) (ret error)
@@ -54,6 +61,7 @@ type RPCCallerBase struct {
 	Result  map[string]chan interface{}
 	Lock    sync.Mutex
 	Counter int
+	Streams StreamReaders
 }
 
 // Init : initializing rpc caller.
@@ -85,18 +93,65 @@ func (c *RPCCallerBase) CallNoWait(feature string, method string, params interfa
 
 // CallRaw : making a RPC call to another driver with raw data.
 func (c *RPCCallerBase) CallRaw(feature string, method string, params []byte) ([]byte, error) {
+	return c.CallRawContext(context.Background(), feature, method, params)
+}
+
+// CallContext : making a RPC call to another driver with structured data, see CallRawContext.
+func (c *RPCCallerBase) CallContext(ctx context.Context, feature string, method string, params interface{}) ([]byte, error) {
+	payload, err := EncodeContent(CodecJSON, params)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCError, err)
+	}
+
+	return c.CallRawContext(ctx, feature, method, payload)
+}
+
+// CallCtx : making a RPC call to another driver with structured data, same as CallContext.
+//
+// Deprecated: use CallContext instead.
+func (c *RPCCallerBase) CallCtx(ctx context.Context, feature string, method string, params interface{}) ([]byte, error) {
+	return c.CallContext(ctx, feature, method, params)
+}
+
+// CallRawContext : making a RPC call to another driver with raw data.  The deadline of the context
+// is passed to the callee in the timeout label, and the callee is asked to cancel the call when
+// the context is done before the return arrives.  The call times out in DefaultRPCTimeout if the
+// context has no deadline.
+func (c *RPCCallerBase) CallRawContext(ctx context.Context, feature string, method string, params []byte) ([]byte, error) {
+	return c.CallWithMessageContext(ctx, &Message{
+		Labels: map[string]string{
+			"feature": feature,
+			"method":  method,
+		},
+		Payload: params,
+		IsRaw:   true,
+	})
+}
+
+// CallWithMessageContext: making a RPC call with pre-built message, see CallRawContext.
+func (c *RPCCallerBase) CallWithMessageContext(ctx context.Context, msg *Message) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Labels["timeout"] = time.Until(deadline).String()
+	} else {
+		// both sides use the default timeout
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*DefaultRPCTimeout)
+		defer cancel()
+	}
+
 	// keep track the call in the map
 	result := make(chan interface{}, 1)
 	rpcID := IDMapPut(&c.Result, result)
 	defer IDMapDel(&c.Result, rpcID)
+	msg.Labels["rpcID"] = rpcID
 
-	if err := c.CallRawNoWait(feature, method, params, rpcID); err != nil {
+	if err := c.CallWithMessageNoWait(msg); err != nil {
 		return nil, err
 	}
 
-	rpcTimer := time.NewTimer(time.Second * DefaultRPCTimeout)
-	defer rpcTimer.Stop()
-
 	// waiting for the result to come back
 	select {
 	case msg := <-result:
@@ -104,53 +159,85 @@ func (c *RPCCallerBase) CallRaw(feature string, method string, params []byte) ([
 			return nil, nil
 		} else if e, ok := msg.(error); ok {
 			return nil, e
+		} else if r, ok := msg.(*StreamReader); ok {
+			defer r.Close()
+			// the stream is read within the timeout of the call as well
+			stop := context.AfterFunc(ctx, func() { r.CloseWithError(contextError(ctx.Err())) })
+			defer stop()
+
+			return io.ReadAll(r)
 		}
 
 		return msg.([]byte), nil
-	case <-rpcTimer.C:
-		return nil, ErrTimeout
+	case <-ctx.Done():
+		// let the callee stop working on the call
+		_ = c.send(&Message{
+			Channel: c.Channel,
+			Subject: RPCCancel,
+			Labels: map[string]string{
+				"rpcID":   rpcID,
+				"feature": msg.Labels["feature"],
+				"caller":  "-",
+			},
+		})
+
+		return nil, contextError(ctx.Err())
 	}
 }
 
-// CallWithMessage: making a RPC call with pre-built message.
-func (c *RPCCallerBase) CallWithMessage(msg *Message) ([]byte, error) {
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	return err
+}
+
+// CallStream : making a RPC call to another driver and read the return as a stream. The
+// caller should close the returned reader. Returns not streamed by the callee are wrapped
+// in a reader as well.
+func (c *RPCCallerBase) CallStream(feature string, method string, params interface{}) (io.ReadCloser, error) {
 	// keep track the call in the map
 	result := make(chan interface{}, 1)
 	rpcID := IDMapPut(&c.Result, result)
 	defer IDMapDel(&c.Result, rpcID)
-	msg.Labels["rpcID"] = rpcID
 
-	timeout := time.Second * DefaultRPCTimeout
-	if t, ok := msg.Labels["timeout"]; ok && len(t) > 0 {
-		timeout = Must(time.ParseDuration(t)).(time.Duration)
-	}
-
-	var ret []byte
-	err := c.CallWithMessageNoWait(msg)
-	if err != nil {
-		return ret, err
+	if err := c.CallRawNoWait(feature, method, SerializeContent(params), rpcID); err != nil {
+		return nil, err
 	}
 
-	waitTimer := time.NewTimer(timeout)
-	defer waitTimer.Stop()
+	rpcTimer := time.NewTimer(time.Second * DefaultRPCTimeout)
+	defer rpcTimer.Stop()
 
-	// waiting for the result to come back
+	// waiting for the return or the stream to open
 	select {
 	case msg := <-result:
-		if msg == nil {
-			break
+		switch v := msg.(type) {
+		case nil:
+			return io.NopCloser(&bytes.Buffer{}), nil
+		case error:
+			return nil, v
+		case *StreamReader:
+			return v, nil
+		default:
+			return io.NopCloser(bytes.NewReader(v.([]byte))), nil
 		}
+	case <-rpcTimer.C:
+		return nil, ErrTimeout
+	}
+}
 
-		if e, ok := msg.(error); ok {
-			err = e
-		} else {
-			ret = msg.([]byte)
-		}
-	case <-waitTimer.C:
-		err = ErrTimeout
+// CallWithMessage: making a RPC call with pre-built message, the call times out in the duration
+// specified in the timeout label or DefaultRPCTimeout.
+func (c *RPCCallerBase) CallWithMessage(msg *Message) ([]byte, error) {
+	ctx := context.Background()
+	if t, ok := msg.Labels["timeout"]; ok && len(t) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, Must(time.ParseDuration(t)).(time.Duration))
+		defer cancel()
 	}
 
-	return ret, err
+	return c.CallWithMessageContext(ctx, msg)
 }
 
 // CallWithMessageNoWait: making a RPC call with pre-built message without waiting for return.
@@ -163,35 +250,18 @@ func (c *RPCCallerBase) CallWithMessageNoWait(msg *Message) (ret error) {
 	if len(msg.Labels["rpcID"]) == 0 {
 		msg.Labels["rcpID"] = RPCSkip
 	}
-	feature := msg.Labels["feature"]
-	receiver := c.Parent.GetReceiver(feature).(MessageReceiver)
-	if receiver == nil {
-		return fmt.Errorf("%w: feature not available: %s", ErrRPCError, feature)
-	}
-	receiver.SendMessage(msg)
 
-	return nil
+	return c.send(msg)
 }
 
 // CallRawNoWait : making a RPC call to another driver with raw data not expecting return.
-func (c *RPCCallerBase) CallRawNoWait(feature string, method string, params []byte, rpcID string) (ret error) {
-	defer func() {
-		if r := recover(); r != nil {
-			ret = r.(error)
-		}
-	}()
-
+func (c *RPCCallerBase) CallRawNoWait(feature string, method string, params []byte, rpcID string) error {
 	if rpcID == "" {
 		rpcID = RPCSkip
 	}
 
-	receiver := c.Parent.GetReceiver(feature).(MessageReceiver)
-	if receiver == nil {
-		return fmt.Errorf("%w: feature not available: %s", ErrRPCError, feature)
-	}
-
 	// making the call by sending a message
-	receiver.SendMessage(&Message{
+	return c.send(&Message{
 		Channel: c.Channel,
 		Subject: c.Subject,
 		Labels: map[string]string{
@@ -203,12 +273,35 @@ func (c *RPCCallerBase) CallRawNoWait(feature string, method string, params []by
 		Payload: params,
 		IsRaw:   true,
 	})
+}
+
+// send : sending a rpc message to the receiver of the feature in the feature label.
+func (c *RPCCallerBase) send(msg *Message) (ret error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret = r.(error)
+		}
+	}()
+
+	feature := msg.Labels["feature"]
+	receiver, _ := c.Parent.GetReceiver(feature).(MessageReceiver)
+	if receiver == nil {
+		return fmt.Errorf("%w: feature not available: %s", ErrRPCError, feature)
+	}
+	receiver.SendMessage(msg)
 
 	return nil
 }
 
-// HandleReturn : receiving return of a RPC call.
+// HandleReturn : receiving return of a RPC call, stream messages should be handled in the order received.
 func (c *RPCCallerBase) HandleReturn(m *Message) {
+	stage, isStream := m.Labels["stream"]
+	if isStream && stage != StreamOpen {
+		c.Streams.Dispatch(m)
+
+		return
+	}
+
 	rpcID := m.Labels["rpcID"]
 	item := IDMapGet(&c.Result, rpcID)
 	if item == nil {
@@ -218,9 +311,20 @@ func (c *RPCCallerBase) HandleReturn(m *Message) {
 
 	reason, ok := m.Labels["error"]
 
-	if ok {
+	switch {
+	case isStream:
+		result <- c.Streams.Open(m)
+	case ok:
 		result <- fmt.Errorf("%w: reason: %s", ErrRPCError, reason)
-	} else {
+	case m.Payload != nil && m.Codec != "":
+		// callers expect the raw return in json
+		payload, err := TranscodeContent(m.Codec, CodecJSON, m.Payload.([]byte))
+		if err != nil {
+			result <- fmt.Errorf("%w: %w", ErrRPCError, err)
+		} else {
+			result <- payload
+		}
+	default:
 		result <- m.Payload
 	}
 }
@@ -228,17 +332,21 @@ func (c *RPCCallerBase) HandleReturn(m *Message) {
 // RPCProvider : an interface for providing RPC handling feature.
 type RPCProvider struct {
 	RPCHandlers   map[string]MessageHandler
-	DefaultReturn io.Writer
+	DefaultReturn MessageReceiver
 	Channel       string
 	Subject       string
+
+	cancels    map[string]context.CancelFunc
+	cancelLock sync.Mutex
 }
 
 // Init : initializing rpc provider.
-func (p *RPCProvider) Init(channel string, subject string, defaultWriter io.Writer) {
+func (p *RPCProvider) Init(channel string, subject string, defaultReturn MessageReceiver) {
 	p.RPCHandlers = map[string]MessageHandler{}
-	p.DefaultReturn = defaultWriter
+	p.DefaultReturn = defaultReturn
 	p.Channel = channel
 	p.Subject = subject
+	p.cancels = map[string]context.CancelFunc{}
 }
 
 // ReturnError : return error to rpc caller.
@@ -247,7 +355,7 @@ func (p *RPCProvider) ReturnError(call *Message, reason string) {
 	if returnTo == nil {
 		returnTo = p.DefaultReturn
 	}
-	SendMessage(returnTo, &Message{
+	returnTo.SendMessage(&Message{
 		Channel: p.Channel,
 		Subject: p.Subject,
 		Labels: map[string]string{
@@ -264,7 +372,7 @@ func (p *RPCProvider) Return(call *Message, retval *Message) {
 	if returnTo == nil {
 		returnTo = p.DefaultReturn
 	}
-	SendMessage(returnTo, &Message{
+	returnTo.SendMessage(&Message{
 		Channel: p.Channel,
 		Subject: p.Subject,
 		Labels: map[string]string{
@@ -276,7 +384,30 @@ func (p *RPCProvider) Return(call *Message, retval *Message) {
 	})
 }
 
-// Router : route the message to rpc handlers.
+// ReturnStream : return a value to rpc caller as a stream, the handler should close the stream
+// when finished.
+func (p *RPCProvider) ReturnStream(call *Message) *StreamWriter {
+	returnTo := call.ReturnTo
+	if returnTo == nil {
+		returnTo = p.DefaultReturn
+	}
+	if call.Reply != nil {
+		// let the router know the return is handled through the stream
+		call.Reply <- Message{Labels: map[string]string{"stream": StreamOpen}}
+	}
+
+	return NewStreamWriter(returnTo, &Message{
+		Channel: p.Channel,
+		Subject: p.Subject,
+		Labels: map[string]string{
+			"rpcID":  call.Labels["rpcID"],
+			"caller": call.Labels["caller"],
+		},
+	})
+}
+
+// Router : route the message to rpc handlers.  The handler can use the context of the message,
+// which is done when the call times out or is cancelled by the caller.
 func (p *RPCProvider) Router(msg *Message) {
 	method := msg.Labels["method"]
 	timeout := time.Second * DefaultRPCTimeout
@@ -285,24 +416,33 @@ func (p *RPCProvider) Router(msg *Message) {
 	}
 	f := p.RPCHandlers[method]
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msg.ctx = ctx
+
 	returnerExited := make(chan struct{})
 
-	if msg.Labels["rpcID"] != RPCSkip {
+	if rpcID := msg.Labels["rpcID"]; rpcID != RPCSkip {
+		key := msg.Labels["caller"] + ":" + rpcID
+		p.addCancel(key, cancel)
+		defer p.removeCancel(key)
+
 		msg.Reply = make(chan Message, 1)
 
 		go func() {
-			returnTimer := time.NewTimer(timeout)
 			defer close(returnerExited)
-			defer returnTimer.Stop()
 			select {
 			case reply := <-msg.Reply:
 				if reason, ok := reply.Labels["error"]; ok {
 					p.ReturnError(msg, reason)
-				} else {
+				} else if !IsStream(&reply) {
 					p.Return(msg, &reply)
 				}
-			case <-returnTimer.C:
-				p.ReturnError(msg, "timeout")
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					p.ReturnError(msg, "timeout")
+				}
+				// the caller is not waiting for cancelled calls
 			}
 		}()
 
@@ -321,3 +461,28 @@ func (p *RPCProvider) Router(msg *Message) {
 	}
 	f(msg)
 }
+
+// Cancel : cancel the context of the call identified by the rpcID and caller labels.
+func (p *RPCProvider) Cancel(msg *Message) {
+	key := msg.Labels["caller"] + ":" + msg.Labels["rpcID"]
+
+	p.cancelLock.Lock()
+	cancel, ok := p.cancels[key]
+	p.cancelLock.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (p *RPCProvider) addCancel(key string, cancel context.CancelFunc) {
+	p.cancelLock.Lock()
+	defer p.cancelLock.Unlock()
+	p.cancels[key] = cancel
+}
+
+func (p *RPCProvider) removeCancel(key string) {
+	p.cancelLock.Lock()
+	defer p.cancelLock.Unlock()
+	delete(p.cancels, key)
+}
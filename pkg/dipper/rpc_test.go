@@ -10,6 +10,7 @@
 package dipper
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -67,3 +68,108 @@ func TestRPCCallRaw(t *testing.T) {
 	assert.True(t, ok, "rpc call payload should be byte array")
 	assert.Equal(t, "hello world", string(received), "rpc should be unchanged")
 }
+
+func TestRPCCallContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := RPCCallerBase{}
+	cancelled := make(chan *Message, 1)
+	var receiver MessageReceiver = &NullReceiver{
+		SendMessageFunc: func(msg *Message) {
+			if msg.Subject == RPCCancel {
+				cancelled <- msg
+			} else if msg.Labels["method"] == "echo" {
+				go c.HandleReturn(&Message{
+					Labels:  map[string]string{"rpcID": msg.Labels["rpcID"]},
+					Payload: msg.Payload,
+					IsRaw:   true,
+				})
+			}
+		},
+	}
+
+	mockStub := mock_dipper.NewMockRPCCallerStub(ctrl)
+	mockStub.EXPECT().GetName().AnyTimes().Return("mockCaller")
+	mockStub.EXPECT().GetReceiver(gomock.AssignableToTypeOf("")).AnyTimes().Return(receiver)
+	c.Init(mockStub, "rpc", "call")
+
+	ret, err := c.CallContext(context.Background(), "target", "echo", map[string]interface{}{"key": "value"})
+	assert.NoError(t, err, "CallContext should not return error")
+	assert.JSONEq(t, `{"key":"value"}`, string(ret), "CallContext should return the payload")
+	ret, err = c.CallCtx(context.Background(), "target", "echo", map[string]interface{}{"key": "value"})
+	assert.NoError(t, err, "CallCtx should not return error")
+	assert.JSONEq(t, `{"key":"value"}`, string(ret), "CallCtx should work as CallContext")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.CallContext(ctx, "target", "noreturn", nil)
+	assert.ErrorIs(t, err, ErrTimeout, "CallContext should time out with the context")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "CallContext should return the context error")
+	m := <-cancelled
+	assert.Equal(t, "target", m.Labels["feature"], "should ask the callee to cancel the call")
+	assert.NotEmpty(t, m.Labels["rpcID"], "should identify the call to cancel")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = c.CallContext(ctx, "target", "noreturn", nil)
+	assert.ErrorIs(t, err, context.Canceled, "CallContext should stop waiting when canceled")
+}
+
+func TestRPCCallContextDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := RPCCallerBase{}
+	var timeout string
+	var receiver MessageReceiver = &NullReceiver{
+		SendMessageFunc: func(msg *Message) {
+			timeout = msg.Labels["timeout"]
+			go c.HandleReturn(&Message{Labels: map[string]string{"rpcID": msg.Labels["rpcID"]}})
+		},
+	}
+
+	mockStub := mock_dipper.NewMockRPCCallerStub(ctrl)
+	mockStub.EXPECT().GetReceiver(gomock.AssignableToTypeOf("")).AnyTimes().Return(receiver)
+	c.Init(mockStub, "rpc", "call")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := c.CallContext(ctx, "target", "method", nil)
+	assert.NoError(t, err, "CallContext should not return error")
+	d, err := time.ParseDuration(timeout)
+	assert.NoError(t, err, "timeout label should be a duration")
+	assert.True(t, d > 0 && d <= time.Second, "timeout label should carry the remaining time")
+}
+
+func TestRPCProviderCancel(t *testing.T) {
+	returns := make(chan *Message, 1)
+	p := RPCProvider{}
+	p.Init("rpc", "return", &NullReceiver{SendMessageFunc: func(m *Message) { returns <- m }})
+
+	started := make(chan struct{}, 1)
+	var handlerErr error
+	p.RPCHandlers["wait"] = func(m *Message) {
+		started <- struct{}{}
+		<-m.Context().Done()
+		handlerErr = m.Context().Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Router(&Message{Labels: map[string]string{"rpcID": "1", "caller": "test", "method": "wait"}})
+	}()
+	<-started
+	p.Cancel(&Message{Labels: map[string]string{"rpcID": "1", "caller": "test"}})
+	<-done
+	assert.ErrorIs(t, handlerErr, context.Canceled, "handler context should be cancelled by the caller")
+	assert.Empty(t, returns, "should not return cancelled calls")
+
+	go func() { <-started }()
+	p.Router(&Message{Labels: map[string]string{"rpcID": "2", "caller": "test", "method": "wait", "timeout": "10ms"}})
+	assert.ErrorIs(t, handlerErr, context.DeadlineExceeded, "handler context should time out")
+	m := <-returns
+	assert.Equal(t, "timeout", m.Labels["error"], "should return timeout error")
+	assert.Empty(t, p.cancels, "should clean up finished calls")
+}
@@ -8,11 +8,20 @@
 package dipper
 
 import (
+	"regexp"
+	"strings"
+
 	util "github.com/Masterminds/goutils"
 )
 
 const MaxLabelLen = 256
 
+// RedactedValue replaces the sensitive data in SanitizedData.
+const RedactedValue = "***"
+
+// sensitiveKeys matches the keys of the sensitive data.
+var sensitiveKeys = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private|api_?key|authorization)`)
+
 func SanitizedLabels(l map[string]string) map[string]string {
 	sl := map[string]string{}
 
@@ -23,3 +32,34 @@ func SanitizedLabels(l map[string]string) map[string]string {
 
 	return sl
 }
+
+// SanitizedData returns a copy of the data with the values of the sensitive keys and the encrypted or
+// looked up values redacted.
+func SanitizedData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if sensitiveKeys.MatchString(k) {
+				ret[k] = RedactedValue
+			} else {
+				ret[k] = SanitizedData(item)
+			}
+		}
+
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, item := range v {
+			ret[i] = SanitizedData(item)
+		}
+
+		return ret
+	case string:
+		if strings.HasPrefix(v, "ENC[") || strings.HasPrefix(v, "LOOKUP[") {
+			return RedactedValue
+		}
+	}
+
+	return data
+}
@@ -29,3 +29,31 @@ func TestSanitizedLabels(t *testing.T) {
 	assert.Equal(t, src["str2"], dst["str2"], "max length string should not change")
 	assert.Equal(t, "..."+strings.Repeat("x", MaxLabelLen-6)+"123", dst["str3"], "long string should be abbreviated")
 }
+
+func TestSanitizedData(t *testing.T) {
+	src := map[string]interface{}{
+		"url":      "https://example.com",
+		"password": "secret",
+		"headers": map[string]interface{}{
+			"Authorization": "Bearer xyz",
+			"Accept":        "application/json",
+		},
+		"keys":  []interface{}{"ENC[gpg,abc]", "LOOKUP[vault,path]", "plain"},
+		"count": 3,
+	}
+
+	dst := SanitizedData(src)
+
+	assert.Equal(t, map[string]interface{}{
+		"url":      "https://example.com",
+		"password": RedactedValue,
+		"headers": map[string]interface{}{
+			"Authorization": RedactedValue,
+			"Accept":        "application/json",
+		},
+		"keys":  []interface{}{RedactedValue, RedactedValue, "plain"},
+		"count": 3,
+	}, dst, "should redact the sensitive data")
+	assert.Equal(t, "secret", src["password"], "should not change the source")
+	assert.Nil(t, SanitizedData(nil))
+}
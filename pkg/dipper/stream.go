@@ -0,0 +1,472 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+package dipper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// stages of a stream, passed in the "stream" label.
+const (
+	StreamOpen  = "open"
+	StreamChunk = "chunk"
+	StreamClose = "close"
+)
+
+const (
+	// DefaultStreamChunkSize is the max size in bytes of the payload in a stream chunk message.
+	DefaultStreamChunkSize = 64 * 1024
+
+	// StreamBuffer is the number of chunks buffered for a stream reader, the stream is aborted with
+	// ErrStreamOverflow if the reader falls behind further, so the message loop is never blocked.
+	StreamBuffer = 64
+
+	// DefaultStreamMaxSize is the max size in bytes of a stream collected by StreamAssembler.
+	DefaultStreamMaxSize = 64 * 1024 * 1024
+
+	// DefaultStreamIdleTimeout is how long StreamAssembler keeps a stream without receiving any message.
+	DefaultStreamIdleTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrStreamClosed indicates writing to a closed stream.
+	ErrStreamClosed = errors.New("stream closed")
+
+	// ErrStreamError indicates the sender closed the stream with an error.
+	ErrStreamError = errors.New("stream error")
+
+	// ErrStreamDropped indicates the stream is dropped before being assembled.
+	ErrStreamDropped = errors.New("stream dropped")
+
+	// ErrStreamOverflow indicates the stream is aborted as the reader is not keeping up.
+	ErrStreamOverflow = errors.New("stream overflow")
+)
+
+// IsStream checks if the message is part of a stream.
+func IsStream(msg *Message) bool {
+	_, ok := msg.Labels["stream"]
+
+	return ok
+}
+
+// StreamWriter sends the payload of a message as a stream of chunk messages.  The
+// stream messages keep the channel, subject and labels of the original message, so
+// they can be routed the same way, with "stream" and "streamID" labels added.
+type StreamWriter struct {
+	ID        string
+	ChunkSize int
+	OnClose   func(labels map[string]string, err error)
+
+	receiver MessageReceiver
+	channel  string
+	subject  string
+	labels   map[string]string
+	lock     sync.Mutex
+	closed   bool
+}
+
+// NewStreamWriter opens a stream to the receiver using channel, subject and labels from the message.
+func NewStreamWriter(receiver MessageReceiver, msg *Message) *StreamWriter {
+	w := &StreamWriter{
+		ID:        uuid.NewString(),
+		ChunkSize: DefaultStreamChunkSize,
+		receiver:  receiver,
+		channel:   msg.Channel,
+		subject:   msg.Subject,
+		labels:    map[string]string{},
+	}
+	for k, v := range msg.Labels {
+		w.labels[k] = v
+	}
+
+	w.send(StreamOpen, nil, nil)
+
+	return w
+}
+
+func (w *StreamWriter) send(stage string, payload []byte, extra map[string]string) {
+	labels := map[string]string{}
+	for k, v := range w.labels {
+		labels[k] = v
+	}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	labels["stream"] = stage
+	labels["streamID"] = w.ID
+
+	msg := &Message{
+		Channel: w.channel,
+		Subject: w.subject,
+		Labels:  labels,
+		IsRaw:   true,
+	}
+	if len(payload) > 0 {
+		msg.Payload = payload
+	}
+	w.receiver.SendMessage(msg)
+}
+
+// Write sends the bytes as one or more chunk messages.
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, ErrStreamClosed
+	}
+
+	chunkSize := w.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+
+	for sent := 0; sent < len(p); sent += chunkSize {
+		end := sent + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		// the receiver may keep the chunk, so send a copy
+		w.send(StreamChunk, append([]byte(nil), p[sent:end]...), nil)
+	}
+
+	return len(p), nil
+}
+
+// Close ends the stream successfully.
+func (w *StreamWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError ends the stream, the error, if any, is passed to the receiver in the "error" label.
+func (w *StreamWriter) CloseWithError(err error) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return ErrStreamClosed
+	}
+	w.closed = true
+
+	labels := map[string]string{}
+	if w.OnClose != nil {
+		w.OnClose(labels, err)
+	} else if err != nil {
+		labels["error"] = err.Error()
+	}
+	w.send(StreamClose, nil, labels)
+
+	return nil
+}
+
+// StreamReader exposes the chunks of a stream as an io.Reader.
+type StreamReader struct {
+	// Message is the stream open message, with all the labels.
+	Message *Message
+
+	readers  *StreamReaders
+	chunks   chan []byte
+	closing  chan struct{}
+	closeErr error
+	current  []byte
+	err      error
+	once     sync.Once
+}
+
+func newStreamReader(readers *StreamReaders, msg *Message) *StreamReader {
+	return &StreamReader{
+		Message: msg,
+		readers: readers,
+		chunks:  make(chan []byte, StreamBuffer),
+		closing: make(chan struct{}),
+	}
+}
+
+// Read reads the payload of the stream, blocks until the next chunk arrives or the reader is closed.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		select {
+		case <-r.closing:
+			return 0, r.closeErr
+		default:
+		}
+
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				if r.err != nil {
+					return 0, r.err
+				}
+
+				return 0, io.EOF
+			}
+			r.current = chunk
+		case <-r.closing:
+			return 0, r.closeErr
+		}
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+
+	return n, nil
+}
+
+// Close stops receiving the stream, the remaining chunks are discarded.
+func (r *StreamReader) Close() error {
+	r.CloseWithError(ErrStreamClosed)
+
+	return nil
+}
+
+// CloseWithError stops receiving the stream, the pending and the following reads return the error.
+func (r *StreamReader) CloseWithError(err error) {
+	r.once.Do(func() {
+		r.closeErr = err
+		close(r.closing)
+		if r.readers != nil {
+			r.readers.remove(r)
+		}
+	})
+}
+
+// push passes the chunk to the reader without blocking, returns false if the buffer is full.
+func (r *StreamReader) push(chunk []byte) bool {
+	select {
+	case r.chunks <- chunk:
+	case <-r.closing:
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (r *StreamReader) finish(err error) {
+	r.err = err
+	close(r.chunks)
+}
+
+// StreamReaders keeps track of the streams being received, the zero value is ready to use.
+type StreamReaders struct {
+	lock    sync.Mutex
+	readers map[string]*StreamReader
+}
+
+// Open starts receiving a stream with the stream open message.
+func (s *StreamReaders) Open(msg *Message) *StreamReader {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.readers == nil {
+		s.readers = map[string]*StreamReader{}
+	}
+
+	r := newStreamReader(s, msg)
+	s.readers[msg.Labels["streamID"]] = r
+
+	return r
+}
+
+// remove stops dispatching to the reader.
+func (s *StreamReaders) remove(r *StreamReader) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := r.Message.Labels["streamID"]
+	if s.readers[id] == r {
+		delete(s.readers, id)
+	}
+}
+
+// Dispatch passes a chunk or close message to its stream reader, the messages of a
+// stream have to be dispatched in the order they are received.  Dispatch never blocks,
+// a reader not keeping up with the stream is closed with ErrStreamOverflow.
+func (s *StreamReaders) Dispatch(msg *Message) {
+	id := msg.Labels["streamID"]
+
+	s.lock.Lock()
+	r, ok := s.readers[id]
+	if ok && msg.Labels["stream"] == StreamClose {
+		delete(s.readers, id)
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		Logger.Debugf("[stream] dropping message for unknown stream %s", id)
+
+		return
+	}
+
+	switch msg.Labels["stream"] {
+	case StreamChunk:
+		if msg.Payload != nil && !r.push(msg.Payload.([]byte)) {
+			Logger.Warningf("[stream] aborting stream %s, the reader is not keeping up", id)
+			r.CloseWithError(ErrStreamOverflow)
+		}
+	case StreamClose:
+		var err error
+		if reason, ok := msg.Labels["error"]; ok {
+			err = fmt.Errorf("%w: %s", ErrStreamError, reason)
+		}
+		r.finish(err)
+	}
+}
+
+// StreamAssembler collects the chunks of streams into complete messages.  The streams exceeding
+// MaxSize, or not receiving any message for IdleTimeout, are dropped, and replaced with a message
+// with the labels of the stream, an error status and the reason, so the receivers waiting for the
+// message are not left hanging.  The zero value is ready to use with the defaults.
+type StreamAssembler struct {
+	MaxSize     int
+	IdleTimeout time.Duration
+	// OnDrop receives the replacements of the dropped streams.
+	OnDrop func(*Message)
+
+	lock    sync.Mutex
+	streams map[string]*assemblingStream
+}
+
+type assemblingStream struct {
+	msg   *Message
+	buf   bytes.Buffer
+	timer *time.Timer
+}
+
+// Add adds a stream message to the assembler, returns the complete message when the stream is closed.
+func (a *StreamAssembler) Add(msg *Message) *Message {
+	assembled, dropped := a.add(msg)
+	if dropped != nil && a.OnDrop != nil {
+		a.OnDrop(dropped)
+	}
+
+	return assembled
+}
+
+func (a *StreamAssembler) add(msg *Message) (assembled *Message, dropped *Message) {
+	id := msg.Labels["streamID"]
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.streams == nil {
+		a.streams = map[string]*assemblingStream{}
+	}
+
+	switch msg.Labels["stream"] {
+	case StreamOpen:
+		if old, ok := a.streams[id]; ok {
+			old.timer.Stop()
+		}
+		stream := &assemblingStream{
+			msg: &Message{
+				Channel: msg.Channel,
+				Subject: msg.Subject,
+				Labels:  msg.Labels,
+				IsRaw:   true,
+			},
+		}
+		stream.timer = time.AfterFunc(a.idleTimeout(), func() { a.expire(id, stream) })
+		a.streams[id] = stream
+	case StreamChunk:
+		stream, ok := a.streams[id]
+		if !ok || msg.Payload == nil {
+			return nil, nil
+		}
+		chunk := msg.Payload.([]byte)
+		if stream.buf.Len()+len(chunk) > a.maxSize() {
+			Logger.Warningf("[stream] dropping stream %s exceeding %d bytes", id, a.maxSize())
+
+			return nil, a.drop(id, stream, fmt.Sprintf("%v: exceeding %d bytes", ErrStreamDropped, a.maxSize()))
+		}
+		stream.buf.Write(chunk)
+		stream.timer.Reset(a.idleTimeout())
+	case StreamClose:
+		stream, ok := a.streams[id]
+		if !ok {
+			return nil, nil
+		}
+		stream.timer.Stop()
+		delete(a.streams, id)
+
+		assembled = stream.msg
+		for k, v := range msg.Labels {
+			assembled.Labels[k] = v
+		}
+		delete(assembled.Labels, "stream")
+		delete(assembled.Labels, "streamID")
+
+		if stream.buf.Len() > 0 {
+			assembled.Payload = stream.buf.Bytes()
+			assembled.Size = stream.buf.Len()
+		}
+
+		return assembled, nil
+	}
+
+	return nil, nil
+}
+
+// drop removes the stream, returns the replacement with the labels of the stream, an error status
+// and the reason.
+func (a *StreamAssembler) drop(id string, stream *assemblingStream, reason string) *Message {
+	stream.timer.Stop()
+	delete(a.streams, id)
+
+	labels := map[string]string{}
+	for k, v := range stream.msg.Labels {
+		labels[k] = v
+	}
+	delete(labels, "stream")
+	delete(labels, "streamID")
+	labels["status"] = ERROR
+	labels["reason"] = reason
+
+	return &Message{
+		Channel: stream.msg.Channel,
+		Subject: stream.msg.Subject,
+		Labels:  labels,
+	}
+}
+
+// expire drops the stream idle for too long, and passes the replacement to OnDrop.
+func (a *StreamAssembler) expire(id string, stream *assemblingStream) {
+	a.lock.Lock()
+	if a.streams[id] != stream {
+		// completed or replaced already
+		a.lock.Unlock()
+
+		return
+	}
+	Logger.Warningf("[stream] dropping stream %s idle for %s", id, a.idleTimeout())
+	dropped := a.drop(id, stream, fmt.Sprintf("%v: idle for %s", ErrStreamDropped, a.idleTimeout()))
+	a.lock.Unlock()
+
+	if a.OnDrop != nil {
+		a.OnDrop(dropped)
+	}
+}
+
+func (a *StreamAssembler) idleTimeout() time.Duration {
+	if a.IdleTimeout <= 0 {
+		return DefaultStreamIdleTimeout
+	}
+
+	return a.IdleTimeout
+}
+
+func (a *StreamAssembler) maxSize() int {
+	if a.MaxSize <= 0 {
+		return DefaultStreamMaxSize
+	}
+
+	return a.MaxSize
+}
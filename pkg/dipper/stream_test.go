@@ -0,0 +1,254 @@
+// Copyright 2022 PayPal Inc.
+
+// This Source Code Form is subject to the terms of the MIT License.
+// If a copy of the MIT License was not distributed with this file,
+// you can obtain one at https://mit-license.org/.
+
+//go:build !integration
+// +build !integration
+
+package dipper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/honeydipper/honeydipper/v3/pkg/dipper/mock_dipper"
+	"github.com/stretchr/testify/assert"
+)
+
+var errStreamTest = errors.New("broken stream")
+
+func TestStreamWriterAssembler(t *testing.T) {
+	assembler := &StreamAssembler{}
+	var assembled *Message
+	count := 0
+
+	w := NewStreamWriter(&NullReceiver{SendMessageFunc: func(m *Message) {
+		count++
+		assert.Equal(t, "eventbus", m.Channel, "stream message should keep channel")
+		assert.Equal(t, "1", m.Labels["sessionID"], "stream message should keep labels")
+		if ret := assembler.Add(m); ret != nil {
+			assembled = ret
+		}
+	}}, &Message{
+		Channel: "eventbus",
+		Subject: "return",
+		Labels:  map[string]string{"sessionID": "1"},
+	})
+	w.ChunkSize = 4
+
+	n, err := w.Write([]byte("hello world"))
+	assert.Nil(t, err, "write should not raise err")
+	assert.Equal(t, 11, n, "should write all bytes")
+	assert.Nil(t, w.Close(), "close should not raise err")
+	assert.ErrorIs(t, w.Close(), ErrStreamClosed, "should not close twice")
+	_, err = w.Write([]byte("more"))
+	assert.ErrorIs(t, err, ErrStreamClosed, "should not write after close")
+
+	assert.Equal(t, 5, count, "should send open, 3 chunks and close")
+	assert.NotNil(t, assembled, "should assemble the message on close")
+	assert.Equal(t, "hello world", string(assembled.Payload.([]byte)), "should assemble the payload")
+	assert.Equal(t, map[string]string{"sessionID": "1"}, assembled.Labels, "should remove stream labels")
+}
+
+func TestStreamReaders(t *testing.T) {
+	readers := &StreamReaders{}
+	var r *StreamReader
+
+	w := NewStreamWriter(&NullReceiver{SendMessageFunc: func(m *Message) {
+		if m.Labels["stream"] == StreamOpen {
+			r = readers.Open(m)
+		} else {
+			readers.Dispatch(m)
+		}
+	}}, &Message{Channel: "rpc", Subject: "return"})
+
+	_, _ = w.Write([]byte("line 1\n"))
+	_, _ = w.Write([]byte("line 2\n"))
+	_ = w.Close()
+
+	ret, err := io.ReadAll(r)
+	assert.Nil(t, err, "reading stream should not raise err")
+	assert.Equal(t, "line 1\nline 2\n", string(ret), "should read all chunks")
+
+	w = NewStreamWriter(&NullReceiver{SendMessageFunc: func(m *Message) {
+		if m.Labels["stream"] == StreamOpen {
+			r = readers.Open(m)
+		} else {
+			readers.Dispatch(m)
+		}
+	}}, &Message{Channel: "rpc", Subject: "return"})
+	_, _ = w.Write([]byte("partial"))
+	_ = w.CloseWithError(errStreamTest)
+
+	ret, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrStreamError, "should raise err when stream closed with error")
+	assert.Equal(t, "partial", string(ret), "should read chunks before the error")
+}
+
+func TestStreamReadersOverflow(t *testing.T) {
+	readers := &StreamReaders{}
+	r := readers.Open(&Message{Labels: map[string]string{"stream": StreamOpen, "streamID": "1"}})
+	chunk := &Message{Labels: map[string]string{"stream": StreamChunk, "streamID": "1"}, Payload: []byte("x")}
+	for i := 0; i <= StreamBuffer; i++ {
+		readers.Dispatch(chunk)
+	}
+
+	_, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrStreamOverflow, "should abort the stream instead of blocking")
+	assert.Empty(t, readers.readers, "should stop dispatching to the aborted reader")
+}
+
+func TestStreamAssemblerLimits(t *testing.T) {
+	dropped := make(chan *Message, 2)
+	assembler := &StreamAssembler{MaxSize: 4, IdleTimeout: time.Millisecond * 10, OnDrop: func(m *Message) { dropped <- m }}
+	open := func(id string) {
+		assembler.Add(&Message{Channel: "eventbus", Subject: "return", Labels: map[string]string{"stream": StreamOpen, "streamID": id, "sessionID": id}})
+	}
+	chunk := func(id string, data string) *Message {
+		return assembler.Add(&Message{Labels: map[string]string{"stream": StreamChunk, "streamID": id}, Payload: []byte(data)})
+	}
+
+	open("big")
+	assert.Nil(t, chunk("big", "hello"))
+	assert.NotContains(t, assembler.streams, "big", "should drop the stream exceeding the max size")
+	ret := <-dropped
+	assert.Equal(t, "return", ret.Subject)
+	assert.Equal(t, map[string]string{"sessionID": "big", "status": ERROR, "reason": ErrStreamDropped.Error() + ": exceeding 4 bytes"}, ret.Labels, "should replace the dropped stream with an error")
+
+	open("idle")
+	select {
+	case ret := <-dropped:
+		assert.Equal(t, "idle", ret.Labels["sessionID"], "should replace the idle stream with an error")
+		assert.Equal(t, ERROR, ret.Labels["status"])
+	case <-time.After(time.Second):
+		assert.Fail(t, "should drop the idle stream")
+	}
+	assembler.lock.Lock()
+	assert.NotContains(t, assembler.streams, "idle", "should drop the idle stream")
+	assembler.lock.Unlock()
+
+	open("active")
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 5)
+		chunk("active", "a")
+	}
+	assert.NotNil(t, assembler.Add(&Message{Labels: map[string]string{"stream": StreamClose, "streamID": "active"}}), "should keep the active stream")
+	assert.Empty(t, dropped, "should not drop the active stream")
+}
+
+func TestRPCCallStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := RPCCallerBase{}
+	receiver := &NullReceiver{
+		SendMessageFunc: func(msg *Message) {
+			go func() {
+				w := NewStreamWriter(&NullReceiver{SendMessageFunc: c.HandleReturn}, &Message{
+					Channel: "rpc",
+					Subject: "return",
+					Labels:  map[string]string{"rpcID": msg.Labels["rpcID"]},
+				})
+				_, _ = w.Write([]byte("streamed"))
+				_ = w.Close()
+			}()
+		},
+	}
+
+	mockStub := mock_dipper.NewMockRPCCallerStub(ctrl)
+	mockStub.EXPECT().GetName().AnyTimes().Return("mockCaller")
+	mockStub.EXPECT().GetReceiver(gomock.AssignableToTypeOf("")).AnyTimes().Return(receiver)
+	c.Init(mockStub, "rpc", "call")
+
+	r, err := c.CallStream("target", "testmethod", nil)
+	assert.Nil(t, err, "CallStream should not raise err")
+	ret, err := io.ReadAll(r)
+	assert.Nil(t, err, "reading stream should not raise err")
+	assert.Equal(t, "streamed", string(ret), "should receive streamed return")
+	assert.Nil(t, r.Close(), "closing stream should not raise err")
+
+	raw, err := c.CallRaw("target", "testmethod", nil)
+	assert.Nil(t, err, "CallRaw should not raise err with streamed return")
+	assert.Equal(t, "streamed", string(raw), "CallRaw should assemble streamed return")
+}
+
+func TestRPCCallStreamTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := RPCCallerBase{}
+	receiver := &NullReceiver{
+		SendMessageFunc: func(msg *Message) {
+			if msg.Subject == RPCCancel {
+				return
+			}
+			go func() {
+				w := NewStreamWriter(&NullReceiver{SendMessageFunc: c.HandleReturn}, &Message{
+					Channel: "rpc",
+					Subject: "return",
+					Labels:  map[string]string{"rpcID": msg.Labels["rpcID"]},
+				})
+				_, _ = w.Write([]byte("never closed"))
+			}()
+		},
+	}
+
+	mockStub := mock_dipper.NewMockRPCCallerStub(ctrl)
+	mockStub.EXPECT().GetName().AnyTimes().Return("mockCaller")
+	mockStub.EXPECT().GetReceiver(gomock.AssignableToTypeOf("")).AnyTimes().Return(receiver)
+	c.Init(mockStub, "rpc", "call")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := c.CallRawContext(ctx, "target", "testmethod", nil)
+	assert.ErrorIs(t, err, ErrTimeout, "should time out reading a stream that is never closed")
+}
+
+func TestCommandReturnStream(t *testing.T) {
+	b := &bytes.Buffer{}
+	done := make(chan struct{})
+
+	subject := &CommandProvider{
+		DefaultReturn: NewConn(nil, b),
+		Channel:       "eventbus",
+		Subject:       "return",
+	}
+	subject.Commands = map[string]MessageHandler{
+		"test": func(m *Message) {
+			w := subject.ReturnStream(m)
+			go func() {
+				defer close(done)
+				_, _ = w.Write([]byte(`{"log":"done"}`))
+				_ = w.Close()
+			}()
+		},
+	}
+	subject.Router(&Message{
+		Labels: map[string]string{
+			"method":    "test",
+			"sessionID": "1",
+			"timeout":   "1",
+		},
+	})
+
+	<-done
+	time.Sleep(time.Millisecond * 10)
+
+	assembler := &StreamAssembler{}
+	var assembled *Message
+	for b.Len() > 0 && assembled == nil {
+		assembled = assembler.Add(FetchRawMessage(b))
+	}
+	assert.NotNil(t, assembled, "should receive a complete stream")
+	assert.Equal(t, SUCCESS, assembled.Labels["status"], "should return success")
+	assert.NotContains(t, assembled.Labels, "timeout", "should remove timeout label")
+	assert.Equal(t, map[string]interface{}{"log": "done"}, DeserializePayload(assembled).Payload, "should receive the payload")
+	assert.Equal(t, 0, b.Len(), "should not send another return")
+}